@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/andrewweb/hackday/pkg/cache"
+	"github.com/andrewweb/hackday/pkg/render"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cacheDir   string
+	noCache    bool
+	pruneAfter time.Duration
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", "", "Directory for cached commit data (default ~/.cache/repo-analyzer)")
+	rootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "Fetch everything from the provider without using the cache")
+
+	cachePruneCmd.Flags().DurationVar(&pruneAfter, "older-than", 30*24*time.Hour, "Remove entries unused for longer than this (0 removes everything)")
+
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
+
+// openCache opens the cache directory, or returns nil when caching is disabled
+func openCache() (*cache.Store, error) {
+	if noCache {
+		return nil, nil
+	}
+	dir := cacheDir
+	if dir == "" {
+		var err error
+		if dir, err = cache.DefaultDir(); err != nil {
+			return nil, err
+		}
+	}
+	return cache.Open(dir)
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the on-disk cache",
+	Long:  `Commit details, blame at a fixed commit and provider list responses are kept on disk so repeat analyses use no API quota.`,
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the size of the cache",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openCache()
+		if err != nil {
+			return err
+		}
+		stats, err := store.Stats()
+		if err != nil {
+			return err
+		}
+		if output != render.Table {
+			return render.Write(os.Stdout, output, render.CacheStatsDocument(stats))
+		}
+
+		fmt.Printf("Directory: %s\n", stats.Dir)
+		fmt.Printf("Entries:   %d\n", stats.Entries)
+		fmt.Printf("Size:      %s\n", formatBytes(stats.Bytes))
+		if stats.Entries > 0 {
+			fmt.Printf("Oldest:    %s\n", stats.Oldest.Format(time.RFC3339))
+			fmt.Printf("Newest:    %s\n", stats.Newest.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cache entries that have not been used recently",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openCache()
+		if err != nil {
+			return err
+		}
+		result, err := store.Prune(pruneAfter)
+		if err != nil {
+			return err
+		}
+		if output != render.Table {
+			return render.Write(os.Stdout, output, render.CachePruneDocument(result))
+		}
+		fmt.Printf("Removed %d entries (%s)\n", result.Entries, formatBytes(result.Bytes))
+		return nil
+	},
+}
+
+// formatBytes renders a size with a binary unit, e.g. 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
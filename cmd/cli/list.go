@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+
+	"github.com/andrewweb/hackday/pkg/render"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	prsCmd.Flags().StringVar(&repoName, "repo", "", "Repository to list pull requests of (owner/name, a GitLab group/subgroup/project or project ID, organization/project/name for Azure DevOps, a web URL, or a path for the local provider)")
+
+	rootCmd.AddCommand(reposCmd)
+	rootCmd.AddCommand(prsCmd)
+}
+
+var reposCmd = &cobra.Command{
+	Use:   "repos",
+	Short: "List the repositories the token can access",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repoClient, done, err := connect(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		repos, err := repoClient.ListRepositories(cmd.Context())
+		if err != nil {
+			return err
+		}
+		return render.Write(os.Stdout, output, render.RepositoriesDocument(repos))
+	},
+}
+
+var prsCmd = &cobra.Command{
+	Use:   "prs",
+	Short: "List the open pull requests of a repository",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if repoName == "" {
+			return usageErrorf("--repo is required")
+		}
+
+		repoClient, done, err := connect(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		ctx, clearProgress := showProgress(cmd.Context())
+		prs, err := repoClient.ListPullRequests(ctx, repoRef)
+		clearProgress()
+		if err != nil {
+			return err
+		}
+		return render.Write(os.Stdout, output, render.PullRequestsDocument(prs))
+	},
+}
@@ -1,13 +1,51 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
+// Exit codes
+const (
+	exitFailure   = 1   // the analysis failed, e.g. a provider error
+	exitUsage     = 2   // required input was missing or invalid
+	exitCancelled = 130 // interrupted, as a shell reports SIGINT
+)
+
+// usageError is an error caused by missing or invalid input rather than by
+// the analysis itself
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
 func main() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	// Cancel in-flight work on Ctrl-C instead of killing the process mid-request
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "Cancelled")
+			os.Exit(exitCancelled)
+		}
+		fmt.Fprintln(os.Stderr, err)
+
+		var usage *usageError
+		if errors.As(err, &usage) {
+			os.Exit(exitUsage)
+		}
+		os.Exit(exitFailure)
 	}
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+
+	"github.com/andrewweb/hackday/pkg/overlap"
+	"github.com/andrewweb/hackday/pkg/render"
+	"github.com/andrewweb/hackday/pkg/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	overlapCmd.Flags().StringVar(&repoName, "repo", "", "Repository whose open pull requests to compare (owner/name, a GitLab group/subgroup/project or project ID, organization/project/name for Azure DevOps, a web URL, or a path for the local provider)")
+	overlapCmd.Flags().StringSliceVar(&filePatterns, "files", nil, "Only compare files matching these globs (e.g. 'src/**/*.go'); repeatable")
+
+	rootCmd.AddCommand(overlapCmd)
+}
+
+var overlapCmd = &cobra.Command{
+	Use:   "overlap",
+	Short: "Find open pull requests that change the same files",
+	Long: `Cross-references the open pull requests of a repository and lists the pairs
+that change the same files, most overlapping first. Where the provider returns
+diffs, pairs whose hunks touch the same lines rank highest, as they are the
+most likely to conflict.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if repoName == "" {
+			return usageErrorf("--repo is required")
+		}
+		if err := repo.ValidatePatterns(filePatterns); err != nil {
+			return usageErrorf("%v", err)
+		}
+
+		repoClient, done, err := connect(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		ctx, clearProgress := showProgress(cmd.Context())
+		prs, err := repoClient.ListPullRequests(ctx, repoRef)
+		clearProgress()
+		if err != nil {
+			return err
+		}
+		for i := range prs {
+			prs[i].ChangedFiles = repo.FilterFiles(prs[i].ChangedFiles, filePatterns)
+		}
+
+		return render.Write(os.Stdout, output, render.OverlapDocument(repoRef.String(), len(prs), overlap.Find(prs)))
+	},
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/andrewweb/hackday/pkg/repo"
+)
+
+// phaseLabels describes each progress phase for the progress line
+var phaseLabels = map[string]string{
+	repo.PhaseAuth:             "Authenticating",
+	repo.PhaseListPullRequests: "Listing pull requests",
+	repo.PhaseBlame:            "Blaming files",
+	repo.PhaseFetchCommits:     "Fetching commits",
+	repo.PhaseClone:            "Cloning",
+	repo.PhaseAnalysis:         "Running analysis",
+}
+
+// progressLine shows progress on a single line of a terminal, rewriting it
+// on every update
+type progressLine struct {
+	mu    sync.Mutex
+	w     io.Writer
+	shown bool
+}
+
+// showProgress returns a context whose operations show their progress on
+// stderr, and a function clearing the progress line, to be called before
+// anything else is printed. Progress is only shown when stderr is a terminal.
+func showProgress(ctx context.Context) (context.Context, func()) {
+	if !isTerminal(os.Stderr) {
+		return ctx, func() {}
+	}
+	line := &progressLine{w: os.Stderr}
+	return repo.WithProgress(ctx, line.update), line.clear
+}
+
+func (l *progressLine) update(p repo.Progress) {
+	text := phaseLabels[p.Phase]
+	if text == "" {
+		text = p.Phase
+	}
+	if p.Total > 0 {
+		text += fmt.Sprintf(" %d/%d", p.Done, p.Total)
+	}
+	if len(p.Authors) > 0 {
+		text += " (" + topAuthors(p.Authors, 3) + ")"
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, "\r\033[K%s...", text)
+	l.shown = true
+}
+
+func (l *progressLine) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.shown {
+		fmt.Fprint(l.w, "\r\033[K")
+		l.shown = false
+	}
+}
+
+// topAuthors lists the n authors with the most lines, as "alice 120, bob 40"
+func topAuthors(authors map[string]int, n int) string {
+	names := make([]string, 0, len(authors))
+	for name := range authors {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if authors[names[i]] != authors[names[j]] {
+			return authors[names[i]] > authors[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	var parts []string
+	for i, name := range names {
+		if i == n {
+			parts = append(parts, fmt.Sprintf("+%d more", len(names)-n))
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s %d", name, authors[name]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -2,31 +2,87 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/andrewweb/hackday/pkg/auth"
+	"github.com/andrewweb/hackday/pkg/maat"
+	"github.com/andrewweb/hackday/pkg/render"
 	"github.com/andrewweb/hackday/pkg/repo"
 	"github.com/google/go-github/v45/github"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/xanzy/go-gitlab"
 )
 
 var (
-	provider string
-	token    string
+	provider     string
+	token        string
+	baseURL      string
+	configPath   string
+	localPath    string
+	analysisName string
+	maxItems     int
+	concurrency  int
+	waitForReset time.Duration
+	since        string
+	until        string
+	ref          string
+	repoName     string
+	// repoRef is --repo, parsed by connect once the provider is known, or the
+	// repository selected interactively
+	repoRef      repo.RepoRef
+	prNumber     int
+	headBranch   string
+	baseBranch   string
+	filePatterns []string
+	outputName   string
+	output       render.Format
 )
 
 func init() {
-	rootCmd.PersistentFlags().StringVarP(&provider, "provider", "p", "", "Git provider (github or gitlab)")
+	rootCmd.PersistentFlags().StringVarP(&provider, "provider", "p", "", "Git provider (github, gitlab, bitbucket, gitea, azure or local)")
 	rootCmd.PersistentFlags().StringVarP(&token, "token", "t", "", "Personal access token")
+	rootCmd.PersistentFlags().StringVar(&baseURL, "base-url", "", "Root URL of a self-hosted instance, such as GitHub Enterprise Server or a self-managed GitLab")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "JSON file of per-provider settings (defaults to ~/.repo-analyzer.json)")
+	rootCmd.PersistentFlags().StringVar(&localPath, "path", ".", "Path to a git working copy (local provider)")
+	rootCmd.PersistentFlags().IntVar(&concurrency, "concurrency", repo.DefaultConcurrency, "Number of commits to fetch in parallel")
+	rootCmd.PersistentFlags().IntVar(&maxItems, "max-items", repo.DefaultMaxItems, "Maximum number of items to fetch per list (0 for no limit)")
+	rootCmd.PersistentFlags().StringVarP(&outputName, "output", "o", "table",
+		fmt.Sprintf("Output format (%s)", strings.Join(render.Formats(), ", ")))
+	rootCmd.PersistentFlags().DurationVar(&waitForReset, "wait-for-reset", 0, "Longest time to wait for an exhausted API rate limit to reset (0 to fail instead)")
+
+	for _, cmd := range []*cobra.Command{blameCmd, logCmd} {
+		cmd.Flags().StringVar(&since, "since", "", "Only analyse commits authored after this date (2024-01-01) or age (90d, 12w, 6m)")
+		cmd.Flags().StringVar(&until, "until", "", "Only analyse commits authored before this date or age")
+		cmd.Flags().StringVar(&ref, "ref", "", "Branch, tag or commit to analyse (log: history to walk; blame: ref to blame at); --branch is an alias")
+		// --branch is read as --ref, so both set one flag
+		cmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+			if name == "branch" {
+				name = "ref"
+			}
+			return pflag.NormalizedName(name)
+		})
+		cmd.Flags().StringVar(&repoName, "repo", "", "Repository to analyse (owner/name, a GitLab group/subgroup/project or project ID, organization/project/name for Azure DevOps, a web URL, or a path for the local provider); skips the repository prompt")
+		cmd.Flags().StringSliceVar(&filePatterns, "files", nil, "Only analyse files matching these globs (e.g. 'src/**/*.go'); repeatable")
+	}
+	blameCmd.Flags().IntVar(&prNumber, "pr", 0, "Pull request number to blame; skips the pull request prompt")
+	blameCmd.Flags().StringVar(&headBranch, "head", "", "Branch to blame as a pull request (local provider); skips the pull request prompt")
+	blameCmd.Flags().StringVar(&baseBranch, "base", "", "Branch --head is compared with (local provider; defaults to the default branch)")
+	logCmd.Flags().IntVar(&prNumber, "pr", 0, "Pull request that must exist; the analysis still covers the repository's history")
+	logCmd.Flags().StringVarP(&analysisName, "analysis", "a", "fragmentation",
+		fmt.Sprintf("code-maat analysis to run (%s)", strings.Join(maat.Analyses(), ", ")))
+
+	// Bad flags are usage errors, like missing input
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageErrorf("%v", err)
+	})
 
 	// Add subcommands
 	rootCmd.AddCommand(blameCmd)
@@ -37,272 +93,461 @@ func init() {
 var blameCmd = &cobra.Command{
 	Use:   "blame",
 	Short: "Run git-blame analysis on a repository",
-	Long:  `Analyzes the blame information for files in a pull request, showing which authors modified which lines.`,
+	Long:  `Analyzes the blame information for files in a pull request, showing which authors own the surviving lines at the pull request's base commit.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runAnalysis("blame")
+		return runAnalysis(cmd.Context(), "blame")
 	},
 }
 
 var logCmd = &cobra.Command{
 	Use:   "log",
 	Short: "Run git-log analysis on a repository",
-	Long:  `Runs code-maat analysis on the repository's git log.`,
+	Long:  `Runs a code-maat analysis on the repository's commit history.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runAnalysis("log")
+		if !maat.IsValid(analysisName) {
+			return usageErrorf("invalid analysis %q. Must be one of: %s", analysisName, strings.Join(maat.Analyses(), ", "))
+		}
+		return runAnalysis(cmd.Context(), "log")
 	},
 }
 
-func splitRepoFullName(fullName string) (string, string, error) {
-	parts := strings.Split(fullName, "/")
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("invalid repository name format: %s. Expected format: owner/repo", fullName)
+// status receives prompts and progress messages. Results go to stdout; with a
+// machine-readable --output everything else goes to stderr, so stdout can be
+// piped into other tools.
+func status() io.Writer {
+	if output == render.Table {
+		return os.Stdout
 	}
-	return parts[0], parts[1], nil
+	return os.Stderr
 }
 
-func runAnalysis(analysisType string) error {
-	// Get analysis type if not specified
-	if analysisType == "" {
-		fmt.Print("Select analysis type (blame/log): ")
-		reader := bufio.NewReader(os.Stdin)
-		input, err := reader.ReadString('\n')
-		if err != nil {
-			return fmt.Errorf("failed to read input: %v", err)
-		}
-		analysisType = strings.TrimSpace(strings.ToLower(input))
-		if analysisType != "blame" && analysisType != "log" {
-			return fmt.Errorf("invalid analysis type. Must be 'blame' or 'log'")
-		}
-	}
-
-	// Get provider if not specified
-	if provider == "" {
-		fmt.Print("Select provider (github/gitlab): ")
-		reader := bufio.NewReader(os.Stdin)
-		input, err := reader.ReadString('\n')
-		if err != nil {
-			return fmt.Errorf("failed to read input: %v", err)
-		}
-		provider = strings.TrimSpace(strings.ToLower(input))
-	}
+// stdin is shared by every prompt so buffered input is never lost between them
+var stdin = bufio.NewReader(os.Stdin)
 
-	// Get token if not specified
-	if token == "" {
-		// Try to get token from environment first
-		token = auth.GetTokenFromEnv(provider)
-
-		// If not in environment, try to get from cache
-		if token == "" {
-			cachedToken, err := auth.GetCachedToken(provider)
-			if err != nil {
-				fmt.Printf("Warning: Failed to load cached token: %v\n", err)
-			}
-			token = cachedToken
-		}
+// interactive reports whether stdin is a terminal a user can answer prompts
+// on. Pipes, files and the null device are not.
+func interactive() bool {
+	return isTerminal(os.Stdin)
+}
 
-		// If still no token, prompt user
-		if token == "" {
-			fmt.Printf("Enter %s personal access token: ", provider)
-			reader := bufio.NewReader(os.Stdin)
-			input, err := reader.ReadString('\n')
-			if err != nil {
-				return fmt.Errorf("failed to read input: %v", err)
-			}
-			token = strings.TrimSpace(input)
+// isTerminal reports whether f is a terminal, and not the null device, which
+// is also a character device
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
 
-			// Save the token to cache
-			if err := auth.SaveToken(provider, token); err != nil {
-				fmt.Printf("Warning: Failed to save token to cache: %v\n", err)
-			}
-		}
+// prompt prints message and reads a trimmed line from stdin, giving up when
+// ctx is cancelled. When stdin is not a terminal, it fails without reading,
+// naming the flag that provides the answer instead.
+func prompt(ctx context.Context, message, flag string) (string, error) {
+	if !interactive() {
+		return "", usageErrorf("%s is required when stdin is not a terminal", flag)
 	}
+	fmt.Fprint(status(), message)
 
-	// Create repository client based on provider
-	var repoClient repo.RepositoryClient
-	switch provider {
-	case "github":
-		authProvider := auth.NewGitHubAuth(token)
-		if err := authProvider.Authenticate(); err != nil {
-			return err
-		}
-		repoClient = repo.NewGitHubClient(authProvider.GetClient().(*github.Client))
-	case "gitlab":
-		authProvider := auth.NewGitLabAuth(token)
-		if err := authProvider.Authenticate(); err != nil {
-			return err
+	type result struct {
+		line string
+		err  error
+	}
+	lines := make(chan result, 1)
+	go func() {
+		line, err := stdin.ReadString('\n')
+		lines <- result{line, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(status())
+		return "", ctx.Err()
+	case r := <-lines:
+		if r.err != nil {
+			return "", fmt.Errorf("failed to read input: %v", r.err)
 		}
-		repoClient = repo.NewGitLabClient(authProvider.GetClient().(*gitlab.Client))
-	default:
-		return fmt.Errorf("unsupported provider: %s", provider)
+		return strings.TrimSpace(r.line), nil
 	}
+}
 
-	fmt.Printf("Successfully authenticated with %s\n", provider)
-
-	// List repositories
-	repos, err := repoClient.ListRepositories()
+// analysisWindow builds the analysis window from the --since, --until and --ref flags
+func analysisWindow() (repo.Window, error) {
+	now := time.Now()
+	start, err := repo.ParseTime(since, now)
 	if err != nil {
-		return err
+		return repo.Window{}, fmt.Errorf("invalid --since: %v", err)
 	}
-
-	// Display repositories and get selection
-	fmt.Println(repo.FormatRepoList(repos))
-	fmt.Print("Select a repository (number): ")
-	reader := bufio.NewReader(os.Stdin)
-	input, err := reader.ReadString('\n')
+	end, err := repo.ParseUntil(until, now)
 	if err != nil {
-		return fmt.Errorf("failed to read input: %v", err)
+		return repo.Window{}, fmt.Errorf("invalid --until: %v", err)
 	}
-
-	selection, err := strconv.Atoi(strings.TrimSpace(input))
-	if err != nil || selection < 1 || selection > len(repos) {
-		return fmt.Errorf("invalid selection")
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return repo.Window{}, fmt.Errorf("--until must not be before --since")
 	}
+	return repo.Window{Since: start, Until: end, Ref: ref}, nil
+}
 
-	selectedRepo := repos[selection-1]
-	fmt.Printf("\nSelected repository: %s\n", selectedRepo.FullName)
-	fmt.Printf("URL: %s\n", selectedRepo.URL)
-
-	// List pull requests
-	prs, err := repoClient.ListPullRequests(selectedRepo.FullName)
+func runAnalysis(ctx context.Context, analysisType string) error {
+	window, err := analysisWindow()
 	if err != nil {
-		return err
+		return usageErrorf("%v", err)
+	}
+	if err := repo.ValidatePatterns(filePatterns); err != nil {
+		return usageErrorf("%v", err)
 	}
 
-	if len(prs) == 0 {
-		fmt.Println("\nNo open pull requests found.")
-		return nil
+	// Get analysis type if not specified
+	if analysisType == "" {
+		input, err := prompt(ctx, "Select analysis type (blame/log): ", "a subcommand (blame or log)")
+		if err != nil {
+			return err
+		}
+		analysisType = strings.ToLower(input)
+		if analysisType != "blame" && analysisType != "log" {
+			return usageErrorf("invalid analysis type. Must be 'blame' or 'log'")
+		}
 	}
 
-	// Display pull requests and get selection
-	fmt.Println(repo.FormatPullRequestList(prs))
-	fmt.Print("Select a pull request (number): ")
-	input, err = reader.ReadString('\n')
+	repoClient, done, err := connect(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read input: %v", err)
+		return err
 	}
+	defer done()
 
-	selection, err = strconv.Atoi(strings.TrimSpace(input))
-	if err != nil || selection < 1 || selection > len(prs) {
-		return fmt.Errorf("invalid selection")
-	}
+	// Select the repository, from --repo or interactively
+	if repoName == "" {
+		repos, err := repoClient.ListRepositories(ctx)
+		if err != nil {
+			return err
+		}
 
-	selectedPR := prs[selection-1]
-	fmt.Printf("\nSelected pull request: #%d - %s\n", selectedPR.Number, selectedPR.Title)
-	fmt.Printf("URL: %s\n", selectedPR.URL)
+		fmt.Fprintln(status(), repo.FormatRepoList(repos))
+		selection, err := promptSelection(ctx, "Select a repository (number): ", "--repo", len(repos))
+		if err != nil {
+			return err
+		}
 
-	// Display changed files
-	fmt.Println(repo.FormatChangedFiles(selectedPR.ChangedFiles))
+		selectedRepo := repos[selection-1]
+		if repoRef, err = repo.ParseRepoRef(repo.ProviderType(provider), selectedRepo.FullName); err != nil {
+			return err
+		}
+		fmt.Fprintf(status(), "\nSelected repository: %s\n", selectedRepo.FullName)
+		fmt.Fprintf(status(), "URL: %s\n", selectedRepo.URL)
+	}
 
 	// Run the selected analysis
 	switch analysisType {
 	case "blame":
+		// Select the pull request, from --head or --pr or interactively.
+		// Local branches are named, as their numbers shift when branches are
+		// created or deleted.
+		localClient, local := repoClient.(*repo.LocalClient)
+		if (headBranch != "" || baseBranch != "") && !local {
+			return usageErrorf("--head and --base are only taken by the local provider")
+		}
+		var selectedPR *repo.PullRequest
+		if headBranch != "" {
+			selectedPR, err = localClient.CompareRefs(ctx, repoRef, baseBranch, headBranch)
+			if err != nil {
+				return err
+			}
+		} else if prNumber > 0 {
+			selectedPR, err = repoClient.GetPullRequest(ctx, repoRef, prNumber)
+			if err != nil {
+				return err
+			}
+		} else {
+			progressCtx, clearProgress := showProgress(ctx)
+			prs, err := repoClient.ListPullRequests(progressCtx, repoRef)
+			clearProgress()
+			if err != nil {
+				return err
+			}
+			if len(prs) == 0 {
+				fmt.Fprintln(status(), "\nNo open pull requests found.")
+				return nil
+			}
+
+			fmt.Fprintln(status(), repo.FormatPullRequestList(prs))
+			selection, err := promptSelection(ctx, "Select a pull request (number): ", "--pr", len(prs))
+			if err != nil {
+				return err
+			}
+			selectedPR = &prs[selection-1]
+		}
+		if selectedPR.Number > 0 {
+			fmt.Fprintf(status(), "\nSelected pull request: #%d - %s\n", selectedPR.Number, selectedPR.Title)
+		} else {
+			fmt.Fprintf(status(), "\nSelected branch: %s, against %s\n", selectedPR.HeadRef, selectedPR.BaseRef)
+		}
+		fmt.Fprintf(status(), "URL: %s\n", selectedPR.URL)
+
+		// Display changed files, narrowed down by --files
+		files := repo.FilterFiles(selectedPR.ChangedFiles, filePatterns)
+		fmt.Fprintln(status(), repo.FormatChangedFiles(files))
+
 		// Get blame information
-		blameInfo, err := repoClient.GetBlameInfo(selectedRepo.FullName, selectedPR.Number, selectedPR.ChangedFiles)
+		progressCtx, clearProgress := showProgress(ctx)
+		var blameInfo *repo.BlameResult
+		if local {
+			blameInfo, err = localClient.BlamePullRequest(progressCtx, repoRef, selectedPR, files, window)
+		} else {
+			blameInfo, err = repoClient.GetBlameInfo(progressCtx, repoRef, selectedPR.Number, files, window)
+		}
+		clearProgress()
 		if err != nil {
 			return err
 		}
+		if len(blameInfo.Skipped) > 0 {
+			fmt.Fprintf(status(), "\nNote: skipped %d files missing at %s:\n", len(blameInfo.Skipped), blameInfo.Ref)
+			for _, file := range blameInfo.Skipped {
+				fmt.Fprintf(status(), "- %s\n", file)
+			}
+		}
 
 		// Display blame information
-		fmt.Println(repo.FormatBlameInfo(blameInfo))
+		return render.Write(os.Stdout, output, render.BlameDocument(repoRef.String(), selectedPR, blameInfo))
 
 	case "log":
-		// Get commit history using GitHub API
-		ctx := context.Background()
-		owner, repoName, err := splitRepoFullName(selectedRepo.FullName)
-		if err != nil {
-			return fmt.Errorf("failed to parse repository name: %v", err)
+		// --pr only checks that the pull request exists, so scripts can pass
+		// blame and log the same flags
+		if prNumber > 0 {
+			if _, err := repoClient.GetPullRequest(ctx, repoRef, prNumber); err != nil {
+				return err
+			}
 		}
 
-		// Get all commits for the repository
-		commits, err := repoClient.(*repo.GitHubClient).GetCommits(ctx, owner, repoName, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		// Get commit history from the provider, narrowed down by --files
+		progressCtx, clearProgress := showProgress(ctx)
+		commits, err := repoClient.GetCommitHistory(progressCtx, repoRef, window)
+		clearProgress()
 		if err != nil {
 			return fmt.Errorf("failed to get commits: %v", err)
 		}
+		commits = repo.FilterCommits(commits, filePatterns)
+
+		// Format the commits as a code-maat log and parse them back into entries
+		entries, err := maat.Parse(strings.NewReader(repo.FormatCodeMaatLog(commits)))
+		if err != nil {
+			return fmt.Errorf("failed to parse commit log: %v", err)
+		}
 
-		// Create a temporary directory for the log file
-		tempDir, err := os.MkdirTemp("", "git-log-*")
+		summary, err := maat.Run("summary", entries, maat.Options{})
 		if err != nil {
-			return fmt.Errorf("failed to create temporary directory: %v", err)
+			return err
+		}
+		result, err := maat.Run(analysisName, entries, maat.Options{})
+		if err != nil {
+			return err
 		}
-		defer os.RemoveAll(tempDir)
 
-		// Format the commits in the required format for code-maat
-		var logContent strings.Builder
-		for _, commit := range commits {
-			// Get the commit details to get the changed files
-			commitDetails, err := repoClient.(*repo.GitHubClient).GetCommitDetails(ctx, owner, repoName, commit.GetSHA())
+		// Display the analysis results
+		return render.Write(os.Stdout, output, render.AnalysisDocument(repoRef.String(), analysisName, summary, result))
+	}
+
+	return nil
+}
+
+// connect asks for any missing provider and token, and returns a client for
+// the provider. done prints notes about the client's requests, such as the
+// remaining rate limit, and should be called once the client is no longer used.
+func connect(ctx context.Context) (repo.RepositoryClient, func(), error) {
+	// Get provider if not specified
+	if provider == "" {
+		input, err := prompt(ctx, "Select provider (github/gitlab/bitbucket/gitea/azure/local): ", "--provider")
+		if err != nil {
+			return nil, nil, err
+		}
+		provider = strings.ToLower(input)
+	}
+	if !repo.ProviderType(provider).IsValid() {
+		return nil, nil, usageErrorf("unsupported provider: %s", provider)
+	}
+
+	// The flag takes precedence over the environment and the config file
+	if baseURL != "" {
+		if err := auth.ValidateBaseURL(baseURL); err != nil {
+			return nil, nil, usageErrorf("--base-url: %v", err)
+		}
+	} else if provider != "local" {
+		config, err := auth.LoadConfig(configPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		baseURL = config.BaseURL(provider)
+	}
+	host := auth.ProviderHost(provider, baseURL)
+
+	// A repository given as a URL must be on the instance used
+	if repoName != "" {
+		var err error
+		if repoRef, err = repo.ParseRepoRef(repo.ProviderType(provider), repoName); err != nil {
+			return nil, nil, usageErrorf("--repo: %v", err)
+		}
+		if repoRef.Host != "" && repoRef.Host != host {
+			return nil, nil, usageErrorf("--repo is on %s, not %s; pass --base-url to use another instance", repoRef.Host, host)
+		}
+	}
+
+	// Get token if not specified; local repositories need none
+	if token == "" && provider != "local" {
+		// Try to get token from environment first
+		token = auth.GetTokenFromEnv(provider)
+
+		// If not in environment, try to get from cache, where tokens are kept
+		// by host
+		if token == "" {
+			cachedToken, err := auth.GetCachedToken(provider, host)
 			if err != nil {
-				return fmt.Errorf("failed to get commit details: %v", err)
+				fmt.Fprintf(status(), "Warning: Failed to load cached token: %v\n", err)
 			}
+			token = cachedToken
+		}
 
-			// Write the commit header
-			logContent.WriteString(fmt.Sprintf("--%s--%s--%s\n",
-				commit.GetSHA()[:7],
-				commit.GetCommit().GetCommitter().GetDate().Format("2006-01-02"),
-				commit.GetCommit().GetCommitter().GetName()))
-
-			// Write the changed files
-			for _, file := range commitDetails.Files {
-				logContent.WriteString(fmt.Sprintf("%d\t%d\t%s\n",
-					file.GetAdditions(),
-					file.GetDeletions(),
-					file.GetFilename()))
+		// If still no token, prompt user
+		if token == "" {
+			input, err := prompt(ctx, fmt.Sprintf("Enter %s personal access token for %s: ", provider, host), "--token")
+			if err != nil {
+				return nil, nil, err
 			}
-		}
+			token = input
 
-		// Write the log content to a file
-		logFile := filepath.Join(tempDir, "logfile.log")
-		if err := os.WriteFile(logFile, []byte(logContent.String()), 0644); err != nil {
-			return fmt.Errorf("failed to write log file: %v", err)
+			// Save the token to cache
+			if err := auth.SaveToken(provider, host, token); err != nil {
+				fmt.Fprintf(status(), "Warning: Failed to save token to cache: %v\n", err)
+			}
 		}
+	}
 
-		// Print the log file content for debugging
-		fmt.Println("\nLog file content:")
-		fmt.Println(logContent.String())
+	store, err := openCache()
+	if err != nil {
+		fmt.Fprintf(status(), "Warning: Failed to open cache: %v\n", err)
+	}
+	authOptions := []auth.Option{auth.WithMaxResetWait(waitForReset), auth.WithCache(store)}
 
-		// Check if code-maat jar exists
-		jarPath := "code-maat-1.0.4-standalone.jar"
-		if _, err := os.Stat(jarPath); os.IsNotExist(err) {
-			return fmt.Errorf("code-maat jar file not found. Please download it from https://github.com/adamtornhill/code-maat/releases and place it in the current directory")
+	// Create repository client based on provider
+	var repoClient repo.RepositoryClient
+	var authProvider auth.AuthProvider
+	switch provider {
+	case "github":
+		githubAuth := auth.NewGitHubAuth(baseURL, token, authOptions...)
+		if err := githubAuth.Authenticate(ctx); err != nil {
+			return nil, nil, err
 		}
-
-		// First try a simpler analysis
-		fmt.Println("\nTrying simple analysis first...")
-		simpleCmd := exec.Command("java", "-jar", jarPath, "-l", logFile, "-c", "git2", "-a", "summary")
-		var stderr bytes.Buffer
-		simpleCmd.Stderr = &stderr
-		simpleOutput, err := simpleCmd.Output()
-		if err != nil {
-			fmt.Printf("Simple analysis failed: %v\nError output: %s\n", err, stderr.String())
-		} else {
-			fmt.Println("Simple analysis succeeded:")
-			fmt.Println(string(simpleOutput))
+		authProvider = githubAuth
+		repoClient = repo.NewGitHubClient(githubAuth.GetClient().(*github.Client))
+	case "gitlab":
+		gitlabAuth := auth.NewGitLabAuth(baseURL, token, authOptions...)
+		if err := gitlabAuth.Authenticate(ctx); err != nil {
+			return nil, nil, err
 		}
+		authProvider = gitlabAuth
+		repoClient = repo.NewGitLabClient(gitlabAuth.GetClient().(*gitlab.Client))
+	case "bitbucket":
+		bitbucketAuth := auth.NewBitbucketAuth(baseURL, token, authOptions...)
+		if err := bitbucketAuth.Authenticate(ctx); err != nil {
+			return nil, nil, err
+		}
+		authProvider = bitbucketAuth
+		repoClient = repo.NewBitbucketClient(bitbucketAuth.GetClient().(*http.Client), bitbucketAuth.BaseURL(), bitbucketAuth.Cloud())
+	case "gitea":
+		giteaAuth := auth.NewGiteaAuth(baseURL, token, authOptions...)
+		if err := giteaAuth.Authenticate(ctx); err != nil {
+			return nil, nil, err
+		}
+		authProvider = giteaAuth
+		repoClient = repo.NewGiteaClient(giteaAuth.GetClient().(*http.Client), giteaAuth.BaseURL())
+	case "azure":
+		// Without --repo, the repositories of AZURE_DEVOPS_ORG are listed
+		organization := repoRef.Owner()
+		if organization == "" {
+			organization = auth.GetAzureOrganizationFromEnv()
+		}
+		azureAuth := auth.NewAzureAuth(baseURL, organization, token, authOptions...)
+		if err := azureAuth.Authenticate(ctx); err != nil {
+			return nil, nil, err
+		}
+		authProvider = azureAuth
+		repoClient = repo.NewAzureClient(azureAuth.GetClient().(*http.Client), azureAuth.BaseURL(), azureAuth.Organization())
+	case "local":
+		repoClient = repo.NewLocalClient(localPath)
+	}
 
-		// Now try the fragmentation analysis
-		fmt.Println("\nTrying fragmentation analysis...")
-		codeMaatCmd := exec.Command("java", "-jar", jarPath, "-l", logFile, "-c", "git2", "-a", "fragmentation")
-		codeMaatCmd.Stderr = &stderr
-		codeMaatOutput, err := codeMaatCmd.Output()
-		if err != nil {
-			return fmt.Errorf("failed to run code-maat: %v\nError output: %s", err, stderr.String())
+	if provider != "local" {
+		fmt.Fprintf(status(), "Successfully authenticated with %s at %s\n", provider, host)
+	}
+	repoClient.SetMaxItems(maxItems)
+	if concurrent, ok := repoClient.(repo.ConcurrentClient); ok {
+		concurrent.SetConcurrency(concurrency)
+	}
+	if caching, ok := repoClient.(repo.CachingClient); ok {
+		caching.SetCache(store)
+	}
+
+	done := func() {
+		printTruncated(repoClient)
+		if authProvider != nil {
+			printQuota(authProvider)
 		}
+	}
+	return repoClient, done, nil
+}
 
-		// Display the analysis results
-		fmt.Println("\nCode Maat Analysis Results:")
-		fmt.Println(string(codeMaatOutput))
+// promptSelection asks for a number between 1 and n
+func promptSelection(ctx context.Context, message, flag string, n int) (int, error) {
+	input, err := prompt(ctx, message, flag)
+	if err != nil {
+		return 0, err
 	}
+	selection, err := strconv.Atoi(input)
+	if err != nil || selection < 1 || selection > n {
+		return 0, usageErrorf("invalid selection")
+	}
+	return selection, nil
+}
 
-	return nil
+// printTruncated warns about lists that were cut short by --max-items
+func printTruncated(repoClient repo.RepositoryClient) {
+	truncated := repoClient.Truncated()
+	if len(truncated) == 0 {
+		return
+	}
+	fmt.Fprintln(status(), "\nWarning: some results were truncated; raise --max-items to fetch more:")
+	for _, what := range truncated {
+		fmt.Fprintf(status(), "- %s\n", what)
+	}
+}
+
+// printQuota shows the API rate limit left after the analysis, when the provider reports one
+func printQuota(authProvider auth.AuthProvider) {
+	quota, ok := authProvider.Quota()
+	if !ok {
+		return
+	}
+	fmt.Fprintf(status(), "\nAPI rate limit: %d/%d requests remaining, resets at %s\n",
+		quota.Remaining, quota.Limit, quota.Reset.Local().Format(time.Kitchen))
 }
 
 func executeRoot(cmd *cobra.Command, args []string) error {
-	return runAnalysis("")
+	return runAnalysis(cmd.Context(), "")
 }
 
 var rootCmd = &cobra.Command{
 	Use:   "repo-analyzer",
-	Short: "A tool to analyze GitHub and GitLab repositories",
-	Long:  `A CLI tool that allows authentication to GitHub or GitLab and repository analysis.`,
+	Short: "A tool to analyze GitHub, GitLab and local git repositories",
+	Long:  `A CLI tool that allows authentication to GitHub or GitLab, or reads a local git repository, for repository analysis.`,
 	RunE:  executeRoot,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		format, err := render.ParseFormat(outputName)
+		if err != nil {
+			return usageErrorf("%v", err)
+		}
+		output = format
+		return nil
+	},
+	// main reports errors itself, with an exit code that tells scripts what went wrong
+	SilenceErrors: true,
+	SilenceUsage:  true,
 }
@@ -1,25 +1,125 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/andrewweb/hackday/pkg/access"
+	"github.com/andrewweb/hackday/pkg/auth"
+	"github.com/andrewweb/hackday/pkg/cache"
 	"github.com/andrewweb/hackday/pkg/server"
+	"github.com/andrewweb/hackday/pkg/workspace"
 	"github.com/spf13/cobra"
 )
 
 var (
-	port int
+	port         int
+	mcp          bool
+	workers      int
+	queueDepth   int
+	jobRetention time.Duration
+
+	workspaceDir   string
+	workspaceQuota string
+
+	credentialsFile   string
+	allowInlineTokens bool
+	authConfig        string
+	localRoot         string
+	allowedBaseURLs   []string
 )
 
 func init() {
 	serverCmd.Flags().IntVarP(&port, "port", "P", 8080, "Port to listen on")
+	serverCmd.Flags().IntVar(&workers, "workers", 4, "Number of jobs to run at once")
+	serverCmd.Flags().IntVar(&queueDepth, "queue-depth", 64, "Number of jobs that may wait for a worker")
+	serverCmd.Flags().DurationVar(&jobRetention, "job-retention", time.Hour, "How long to keep the results of finished jobs")
+	serverCmd.Flags().StringVar(&workspaceDir, "workspace-dir", "", "Directory to mirror repositories in for git-log (defaults to mirrors in the cache directory)")
+	serverCmd.Flags().StringVar(&workspaceQuota, "workspace-quota", "0", "Disk space the mirrors may take up, such as 10G, before the least recently used are removed (0 for no limit)")
+	serverCmd.Flags().StringVar(&credentialsFile, "credentials", "", "JSON file of named credentials that requests may reference instead of sending tokens")
+	serverCmd.Flags().BoolVar(&allowInlineTokens, "allow-inline-tokens", true, "Accept tokens sent in requests; when false, requests must reference a credential")
+	serverCmd.Flags().StringVar(&authConfig, "auth-config", "", "JSON file of API keys, HMAC secrets and JWT settings that callers must authenticate with")
+	serverCmd.Flags().StringSliceVar(&allowedBaseURLs, "allow-base-url", nil, "Root URL of an instance requests may name as their baseUrl, besides the configured ones; repeatable")
+	serverCmd.Flags().StringVar(&localRoot, "local-root", "", "Directory of repositories the local provider may analyse; without it, the local provider is turned away")
+	serverCmd.Flags().BoolVar(&mcp, "mcp", false, "Speak the Model Context Protocol over stdin and stdout instead of listening on a port")
 	rootCmd.AddCommand(serverCmd)
 }
 
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Start the HTTP server",
-	Long:  `Start the HTTP server that accepts JSON messages`,
+	Long: `Start the HTTP server that accepts JSON messages. The server also speaks the
+Model Context Protocol at /mcp, or over stdin and stdout with --mcp.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		s := server.NewServer(port)
-		return s.Start()
+		if workers < 1 {
+			return usageErrorf("--workers must be at least 1")
+		}
+		if queueDepth < 0 {
+			return usageErrorf("--queue-depth must not be negative")
+		}
+		quota, err := workspace.ParseSize(workspaceQuota)
+		if err != nil {
+			return usageErrorf("--workspace-quota: %v", err)
+		}
+		dir := workspaceDir
+		if dir == "" {
+			cacheRoot, err := cache.DefaultDir()
+			if err != nil {
+				return err
+			}
+			dir = filepath.Join(cacheRoot, "mirrors")
+		}
+
+		// Credentials come from the environment and, optionally, a file
+		credentials := auth.NewCredentialStore()
+		if err := credentials.LoadEnv(os.Environ()); err != nil {
+			return err
+		}
+		if credentialsFile != "" {
+			if err := credentials.LoadCredentials(credentialsFile); err != nil {
+				return err
+			}
+		}
+
+		// Requests without a base URL go to the instances configured for
+		// their provider
+		config, err := auth.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+
+		for _, baseURL := range allowedBaseURLs {
+			if err := auth.ValidateBaseURL(baseURL); err != nil {
+				return usageErrorf("--allow-base-url: %v", err)
+			}
+		}
+
+		opts := []server.Option{
+			server.WithWorkers(workers),
+			server.WithQueueDepth(queueDepth),
+			server.WithJobRetention(jobRetention),
+			server.WithWorkspace(workspace.New(dir, quota)),
+			server.WithCredentials(credentials),
+			server.WithConfig(config),
+			server.WithAllowedBaseURLs(allowedBaseURLs),
+			server.WithInlineTokens(allowInlineTokens),
+		}
+		if localRoot != "" {
+			opts = append(opts, server.WithLocalRoot(localRoot))
+		}
+		if authConfig != "" {
+			authenticator, err := access.LoadConfig(authConfig)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, server.WithAuthenticator(authenticator))
+		}
+
+		s := server.NewServer(port, opts...)
+		if mcp {
+			return s.ServeMCP(cmd.Context(), os.Stdin, os.Stdout)
+		}
+		return s.Start(cmd.Context())
 	},
 }
@@ -5,6 +5,7 @@ go 1.24.2
 require (
 	github.com/google/go-github/v45 v45.2.0
 	github.com/spf13/cobra v1.9.1
+	github.com/spf13/pflag v1.0.6
 	github.com/xanzy/go-gitlab v0.115.0
 	golang.org/x/oauth2 v0.29.0
 )
@@ -14,7 +15,6 @@ require (
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
 	github.com/hashicorp/go-retryablehttp v0.7.7 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.6 // indirect
 	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5 // indirect
 	golang.org/x/time v0.3.0 // indirect
 )
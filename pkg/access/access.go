@@ -0,0 +1,106 @@
+// Package access authenticates the callers of the HTTP server and decides
+// which analyses, repositories and credentials each may use.
+package access
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+)
+
+// ErrNoCredentials is returned by an Authenticator when a request carries none
+// of the credentials it checks, so another may be tried
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator identifies the caller of a request
+type Authenticator interface {
+	// Authenticate returns the caller of r, whose body has already been read
+	// into body
+	Authenticate(r *http.Request, body []byte) (*Principal, error)
+}
+
+// Principal is an authenticated caller
+type Principal struct {
+	Name  string
+	Scope Scope
+}
+
+// Scope restricts what a caller may run. Empty lists allow everything.
+type Scope struct {
+	// Analyses lists the messages the caller may send (git-blame, git-log,
+	// pr-overlap), or single code-maat analyses as git-log:<analysis>
+	Analyses []string `json:"analyses,omitempty"`
+	// Repositories lists glob patterns, such as myorg/*, of the repositories
+	// the caller may analyse
+	Repositories []string `json:"repositories,omitempty"`
+	// Credentials lists glob patterns, such as ci-*, of the names of the
+	// credentials held by the server that the caller may use
+	Credentials []string `json:"credentials,omitempty"`
+}
+
+// AllowsAnalysis reports whether the scope allows a message, where analysis is
+// the code-maat analysis of a git-log message
+func (s Scope) AllowsAnalysis(name, analysis string) bool {
+	if len(s.Analyses) == 0 {
+		return true
+	}
+	for _, allowed := range s.Analyses {
+		if allowed == name || (name == "git-log" && allowed == "git-log:"+analysis) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsRepository reports whether the scope allows a repository
+func (s Scope) AllowsRepository(repository string) bool {
+	return matchesAny(s.Repositories, repository)
+}
+
+// AllowsCredential reports whether the scope allows a credential held by the
+// server, given by name. Requests that name none are allowed.
+func (s Scope) AllowsCredential(name string) bool {
+	return name == "" || matchesAny(s.Credentials, name)
+}
+
+// matchesAny reports whether name matches one of the glob patterns, or there
+// are none
+func matchesAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Chain tries each Authenticator in turn, until one finds its credentials in
+// the request
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request, body []byte) (*Principal, error) {
+	for _, a := range c {
+		principal, err := a.Authenticate(r, body)
+		if !errors.Is(err, ErrNoCredentials) {
+			return principal, err
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the caller of a request
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the caller carried by ctx, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok
+}
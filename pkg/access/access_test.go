@@ -0,0 +1,139 @@
+package access
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestScope(t *testing.T) {
+	scope := Scope{
+		Analyses:     []string{"git-blame", "git-log:coupling"},
+		Repositories: []string{"myorg/*", "other/repo"},
+		Credentials:  []string{"ci-*"},
+	}
+
+	tests := []struct {
+		name         string
+		message      string
+		analysis     string
+		repository   string
+		credential   string
+		analysisOK   bool
+		repoOK       bool
+		credentialOK bool
+	}{
+		{"allowed", "git-blame", "fragmentation", "myorg/api", "ci-github", true, true, true},
+		{"single analysis", "git-log", "coupling", "other/repo", "", true, true, true},
+		{"other analysis", "git-log", "authors", "myorg/api", "", false, true, true},
+		{"other repository", "git-blame", "", "someone/repo", "", true, false, true},
+		{"glob does not cross slashes", "git-blame", "", "myorg/api/sub", "", true, false, true},
+		{"other credential", "git-blame", "", "myorg/api", "release-github", true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scope.AllowsAnalysis(tt.message, tt.analysis); got != tt.analysisOK {
+				t.Errorf("Expected AllowsAnalysis %v, got %v", tt.analysisOK, got)
+			}
+			if got := scope.AllowsRepository(tt.repository); got != tt.repoOK {
+				t.Errorf("Expected AllowsRepository %v, got %v", tt.repoOK, got)
+			}
+			if got := scope.AllowsCredential(tt.credential); got != tt.credentialOK {
+				t.Errorf("Expected AllowsCredential %v, got %v", tt.credentialOK, got)
+			}
+		})
+	}
+
+	if !(Scope{}).AllowsAnalysis("git-log", "authors") || !(Scope{}).AllowsRepository("any/repo") || !(Scope{}).AllowsCredential("any") {
+		t.Error("Expected an empty scope to allow everything")
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	a, err := NewAPIKeys([]APIKey{{Name: "ci", Key: "secret", Scope: Scope{Analyses: []string{"git-blame"}}}})
+	if err != nil {
+		t.Fatalf("NewAPIKeys failed: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/messages", nil)
+	if _, err := a.Authenticate(req, nil); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Expected ErrNoCredentials, got %v", err)
+	}
+
+	req.Header.Set(APIKeyHeader, "wrong")
+	if _, err := a.Authenticate(req, nil); err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Expected an invalid key error, got %v", err)
+	}
+
+	req.Header.Set(APIKeyHeader, "secret")
+	principal, err := a.Authenticate(req, nil)
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if principal.Name != "ci" || len(principal.Scope.Analyses) != 1 {
+		t.Errorf("Unexpected principal: %+v", principal)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a, err := NewHMAC([]HMACKey{{Name: "hook", Secret: "shared"}})
+	if err != nil {
+		t.Fatalf("NewHMAC failed: %v", err)
+	}
+	a.now = func() time.Time { return now }
+
+	body := []byte(`{"name":"git-blame"}`)
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		secret    string
+		signed    []byte
+		wantErr   bool
+	}{
+		{"valid", now, "shared", body, false},
+		{"wrong secret", now, "other", body, true},
+		{"tampered body", now, "shared", []byte(`{"name":"git-log"}`), true},
+		{"too old", now.Add(-10 * time.Minute), "shared", body, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timestamp := strconv.FormatInt(tt.timestamp.Unix(), 10)
+			req := httptest.NewRequest("POST", "/messages?output=json", nil)
+			req.Header.Set(SignatureKeyHeader, "hook")
+			req.Header.Set(SignatureTimestampHeader, timestamp)
+			req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(Sign([]byte(tt.secret), timestamp, "POST", "/messages?output=json", tt.signed)))
+
+			principal, err := a.Authenticate(req, body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && principal.Name != "hook" {
+				t.Errorf("Expected principal hook, got %s", principal.Name)
+			}
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	keys, _ := NewAPIKeys([]APIKey{{Name: "ci", Key: "secret"}})
+	hooks, _ := NewHMAC([]HMACKey{{Name: "hook", Secret: "shared"}})
+	chain := Chain{keys, hooks}
+
+	req := httptest.NewRequest("POST", "/messages", nil)
+	if _, err := chain.Authenticate(req, nil); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Expected ErrNoCredentials, got %v", err)
+	}
+
+	// The second authenticator rejects credentials it recognizes
+	req.Header.Set(SignatureKeyHeader, "unknown")
+	if _, err := chain.Authenticate(req, nil); err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Expected an unknown key error, got %v", err)
+	}
+}
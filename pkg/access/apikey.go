@@ -0,0 +1,73 @@
+package access
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// APIKeyHeader is the header API keys are sent in
+const APIKeyHeader = "X-API-Key"
+
+// APIKey is a static key and what its holder may do
+type APIKey struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+	// KeyEnv names an environment variable holding the key
+	KeyEnv string `json:"keyEnv,omitempty"`
+	Scope
+}
+
+// APIKeys authenticates requests by the key in their X-API-Key header
+type APIKeys struct {
+	// Keys are looked up by hash, so the lookup takes as long whichever
+	// key is sent
+	keys map[[sha256.Size]byte]*Principal
+}
+
+// NewAPIKeys returns an Authenticator accepting keys
+func NewAPIKeys(keys []APIKey) (*APIKeys, error) {
+	a := &APIKeys{keys: map[[sha256.Size]byte]*Principal{}}
+	for _, key := range keys {
+		secret, err := secretValue(key.Name, key.Key, key.KeyEnv)
+		if err != nil {
+			return nil, fmt.Errorf("API key %v", err)
+		}
+		hash := sha256.Sum256([]byte(secret))
+		if _, ok := a.keys[hash]; ok {
+			return nil, fmt.Errorf("API key %q is the same as another", key.Name)
+		}
+		a.keys[hash] = &Principal{Name: key.Name, Scope: key.Scope}
+	}
+	return a, nil
+}
+
+func (a *APIKeys) Authenticate(r *http.Request, body []byte) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	principal, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, fmt.Errorf("invalid API key")
+	}
+	return principal, nil
+}
+
+// secretValue returns a secret given inline or by environment variable
+func secretValue(name, value, env string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("has no name")
+	}
+	if env != "" {
+		value = os.Getenv(env)
+		if value == "" {
+			return "", fmt.Errorf("%q: environment variable %s is not set", name, env)
+		}
+	}
+	if value == "" {
+		return "", fmt.Errorf("%q has no secret", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,59 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Config lists the ways callers may authenticate. Any combination may be
+// used; a request is checked by the first whose credentials it carries.
+type Config struct {
+	APIKeys []APIKey   `json:"apiKeys,omitempty"`
+	HMAC    []HMACKey  `json:"hmac,omitempty"`
+	JWT     *JWTConfig `json:"jwt,omitempty"`
+}
+
+// LoadConfig reads a Config from a JSON file and returns its Authenticator
+func LoadConfig(path string) (Authenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth config: %v", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse auth config: %v", err)
+	}
+	return cfg.Authenticator()
+}
+
+// Authenticator returns an Authenticator accepting the configured credentials
+func (c Config) Authenticator() (Authenticator, error) {
+	var chain Chain
+	if len(c.APIKeys) > 0 {
+		a, err := NewAPIKeys(c.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+	if len(c.HMAC) > 0 {
+		a, err := NewHMAC(c.HMAC)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+	if c.JWT != nil {
+		a, err := NewJWT(*c.JWT)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("auth config allows no way to authenticate")
+	}
+	return chain, nil
+}
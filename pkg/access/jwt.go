@@ -0,0 +1,288 @@
+package access
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// JWTConfig configures the validation of JWT bearer tokens
+type JWTConfig struct {
+	// JWKS is the path of a JSON Web Key Set file holding the signing keys
+	JWKS string `json:"jwks"`
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer   string `json:"issuer,omitempty"`
+	Audience string `json:"audience,omitempty"`
+}
+
+// JWT authenticates requests by a bearer token signed with one of the keys of
+// a JWKS. The sub claim names the caller, the scope claim (space-separated)
+// lists the analyses it may run, and the repositories and credentials claims
+// list repository and credential patterns; each is unrestricted when absent.
+type JWT struct {
+	keys     map[string]jwk
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+type jwk struct {
+	key crypto.PublicKey
+	alg string
+}
+
+// leeway allows for clock differences when checking exp and nbf
+const leeway = time.Minute
+
+// NewJWT returns an Authenticator validating tokens against the keys in the
+// configured JWKS file
+func NewJWT(cfg JWTConfig) (*JWT, error) {
+	data, err := os.ReadFile(cfg.JWKS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %v", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	return &JWT{keys: keys, issuer: cfg.Issuer, audience: cfg.Audience, now: time.Now}, nil
+}
+
+// parseJWKS reads the RSA, EC and Ed25519 public keys of a JWKS, by key ID
+func parseJWKS(data []byte) (map[string]jwk, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %v", err)
+	}
+
+	keys := map[string]jwk{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key crypto.PublicKey
+		switch k.Kty {
+		case "RSA":
+			n, errN := decodeBigInt(k.N)
+			e, errE := decodeBigInt(k.E)
+			if errN != nil || errE != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("invalid RSA key %q in JWKS", k.Kid)
+			}
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("unsupported curve %q in JWKS", k.Crv)
+			}
+			x, errX := decodeBigInt(k.X)
+			y, errY := decodeBigInt(k.Y)
+			if errX != nil || errY != nil || !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("invalid EC key %q in JWKS", k.Kid)
+			}
+			key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		case "OKP":
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("invalid OKP key %q in JWKS", k.Kid)
+			}
+			key = ed25519.PublicKey(x)
+		default:
+			continue
+		}
+		keys[k.Kid] = jwk{key: key, alg: k.Alg}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS has no signing keys")
+	}
+	return keys, nil
+}
+
+func (a *JWT) Authenticate(r *http.Request, body []byte) (*Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+
+	key, ok := a.keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key")
+	}
+	// The key decides the algorithm; a token cannot choose a weaker one
+	if key.alg != "" && key.alg != header.Alg {
+		return nil, fmt.Errorf("token algorithm does not match its key")
+	}
+	if err := verify(key.key, header.Alg, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims struct {
+		Subject      string   `json:"sub"`
+		Issuer       string   `json:"iss"`
+		Audience     audience `json:"aud"`
+		Expiry       *int64   `json:"exp"`
+		NotBefore    *int64   `json:"nbf"`
+		Scope        string   `json:"scope"`
+		Repositories []string `json:"repositories"`
+		Credentials  []string `json:"credentials"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+
+	now := a.now()
+	if claims.Expiry == nil || now.After(time.Unix(*claims.Expiry, 0).Add(leeway)) {
+		return nil, fmt.Errorf("token has expired")
+	}
+	if claims.NotBefore != nil && now.Add(leeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return nil, fmt.Errorf("token is not valid yet")
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return nil, fmt.Errorf("token has the wrong issuer")
+	}
+	if a.audience != "" && !claims.Audience.contains(a.audience) {
+		return nil, fmt.Errorf("token has the wrong audience")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("token has no subject")
+	}
+
+	return &Principal{
+		Name:  claims.Subject,
+		Scope: Scope{Analyses: strings.Fields(claims.Scope), Repositories: claims.Repositories, Credentials: claims.Credentials},
+	}, nil
+}
+
+// verify checks the signature of a token's signing input
+func verify(key crypto.PublicKey, alg, input string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write([]byte(input))
+		digest = h.Sum(nil)
+	}
+
+	valid := false
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			valid = rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil
+		case "PS":
+			valid = rsa.VerifyPSS(k, hash, digest, signature, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] == "ES" && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			valid = ecdsa.Verify(k, digest, r, s)
+		}
+	case ed25519.PublicKey:
+		valid = alg == "EdDSA" && ed25519.Verify(k, []byte(input), signature)
+	}
+	if !valid {
+		return fmt.Errorf("invalid token signature")
+	}
+	return nil
+}
+
+// audience is the aud claim, which is either a string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,136 @@
+package access
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+// signToken encodes claims as a JWT signed with key
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+
+	var signature []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(input))
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(input))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	default:
+		digest := sha256.Sum256([]byte(input))
+		signature, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return input + "." + b64.EncodeToString(signature)
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "use": "sig",
+			"n": b64.EncodeToString(rsaKey.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256",
+			"x": b64.EncodeToString(ecKey.X.Bytes()), "y": b64.EncodeToString(ecKey.Y.Bytes())},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64.EncodeToString(edPublic)},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0644); err != nil {
+		t.Fatalf("Failed to write JWKS: %v", err)
+	}
+
+	a, err := NewJWT(JWTConfig{JWKS: path, Issuer: "https://issuer.example.com", Audience: "repo-analyzer"})
+	if err != nil {
+		t.Fatalf("NewJWT failed: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	a.now = func() time.Time { return now }
+
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":          "ci-bot",
+			"iss":          "https://issuer.example.com",
+			"aud":          []string{"repo-analyzer"},
+			"exp":          now.Add(time.Hour).Unix(),
+			"scope":        "git-blame git-log:coupling",
+			"repositories": []string{"myorg/*"},
+			"credentials":  []string{"ci-*"},
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"RS256", signToken(t, "RS256", "rsa", rsaKey, claims(nil)), false},
+		{"ES256", signToken(t, "ES256", "ec", ecKey, claims(nil)), false},
+		{"EdDSA", signToken(t, "EdDSA", "ed", edKey, claims(nil)), false},
+		{"single audience", signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"aud": "repo-analyzer"})), false},
+		{"algorithm other than the key's", signToken(t, "PS256", "rsa", rsaKey, claims(nil)), true},
+		{"signed by another key", signToken(t, "ES256", "rsa", ecKey, claims(nil)), true},
+		{"unknown key", signToken(t, "RS256", "other", rsaKey, claims(nil)), true},
+		{"expired", signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})), true},
+		{"no expiry", signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": nil})), true},
+		{"not yet valid", signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), true},
+		{"wrong issuer", signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"iss": "other"})), true},
+		{"wrong audience", signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"aud": "other"})), true},
+		{"malformed", "not.a-token", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/messages", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			principal, err := a.Authenticate(req, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			expected := Scope{Analyses: []string{"git-blame", "git-log:coupling"}, Repositories: []string{"myorg/*"}, Credentials: []string{"ci-*"}}
+			if principal.Name != "ci-bot" || !reflect.DeepEqual(principal.Scope, expected) {
+				t.Errorf("Unexpected principal: %+v", principal)
+			}
+		})
+	}
+}
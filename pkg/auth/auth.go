@@ -1,48 +1,190 @@
 package auth
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/andrewweb/hackday/pkg/cache"
 	"github.com/google/go-github/v45/github"
 	"github.com/xanzy/go-gitlab"
 	"golang.org/x/oauth2"
 )
 
 type AuthProvider interface {
-	Authenticate() error
+	// Authenticate creates the provider's client and verifies the token,
+	// giving up when ctx is done
+	Authenticate(ctx context.Context) error
 	GetClient() interface{}
+	// Quota returns the rate limit last reported by the provider
+	Quota() (Quota, bool)
+}
+
+// Option configures an AuthProvider
+type Option func(*RetryTransport)
+
+// WithMaxResetWait lets the provider's HTTP transport sleep up to max for an
+// exhausted rate limit quota to reset, instead of failing the request
+func WithMaxResetWait(max time.Duration) Option {
+	return func(t *RetryTransport) {
+		t.MaxResetWait = max
+	}
+}
+
+// WithCache makes GET requests conditional on the responses kept in store, so
+// unchanged lists are served from disk without using up the rate limit
+func WithCache(store *cache.Store) Option {
+	return func(t *RetryTransport) {
+		if store != nil {
+			t.Base = cache.NewTransport(t.Base, store)
+		}
+	}
+}
+
+func newTransport(opts []Option) *RetryTransport {
+	transport := NewRetryTransport(nil)
+	for _, opt := range opts {
+		opt(transport)
+	}
+	return transport
 }
 
 type GitHubAuth struct {
-	client *github.Client
-	token  string
+	client    *github.Client
+	baseURL   string
+	token     string
+	transport *RetryTransport
 }
 
 type GitLabAuth struct {
-	client *gitlab.Client
-	token  string
+	client    *gitlab.Client
+	baseURL   string
+	token     string
+	transport *RetryTransport
+}
+
+// BitbucketCloudURL is the API root of Bitbucket Cloud. Bitbucket Server and
+// Data Center are addressed by the URL of the instance instead.
+const BitbucketCloudURL = "https://api.bitbucket.org/2.0"
+
+// BitbucketAuth authenticates with Bitbucket Cloud or Bitbucket Server. A
+// token of the form username:app-password is sent as basic auth, anything
+// else as an HTTP access token.
+type BitbucketAuth struct {
+	client    *http.Client
+	baseURL   string
+	token     string
+	transport *RetryTransport
+}
+
+// GiteaURL is the public Gitea service. Self-hosted Gitea and Forgejo
+// instances are addressed by their own URL instead.
+const GiteaURL = "https://gitea.com"
+
+// GiteaAuth authenticates with a Gitea or Forgejo instance using an access token
+type GiteaAuth struct {
+	client    *http.Client
+	baseURL   string
+	token     string
+	transport *RetryTransport
 }
 
-func NewGitHubAuth(token string) *GitHubAuth {
+// AzureURL is the Azure DevOps service, under which organizations are.
+// Azure DevOps Server is addressed by the URL of the instance instead, with
+// collections in place of organizations.
+const AzureURL = "https://dev.azure.com"
+
+// AzureAuth authenticates with an Azure DevOps organization using a personal
+// access token
+type AzureAuth struct {
+	client       *http.Client
+	baseURL      string
+	organization string
+	token        string
+	transport    *RetryTransport
+}
+
+// NewGitHubAuth returns an AuthProvider for the GitHub Enterprise Server at
+// baseURL, or github.com when baseURL is empty
+func NewGitHubAuth(baseURL, token string, opts ...Option) *GitHubAuth {
 	return &GitHubAuth{
-		token: token,
+		baseURL:   baseURL,
+		token:     token,
+		transport: newTransport(opts),
 	}
 }
 
-func NewGitLabAuth(token string) *GitLabAuth {
+// NewGitLabAuth returns an AuthProvider for the self-managed GitLab at
+// baseURL, or gitlab.com when baseURL is empty
+func NewGitLabAuth(baseURL, token string, opts ...Option) *GitLabAuth {
 	return &GitLabAuth{
-		token: token,
+		baseURL:   baseURL,
+		token:     token,
+		transport: newTransport(opts),
+	}
+}
+
+// NewBitbucketAuth returns an AuthProvider for the Bitbucket API at baseURL,
+// or Bitbucket Cloud when baseURL is empty
+func NewBitbucketAuth(baseURL, token string, opts ...Option) *BitbucketAuth {
+	if baseURL == "" {
+		baseURL = BitbucketCloudURL
+	}
+	return &BitbucketAuth{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		token:     token,
+		transport: newTransport(opts),
 	}
 }
 
-func (g *GitHubAuth) Authenticate() error {
-	ctx := oauth2.NoContext
+// NewGiteaAuth returns an AuthProvider for the Gitea instance at baseURL, or
+// gitea.com when baseURL is empty
+func NewGiteaAuth(baseURL, token string, opts ...Option) *GiteaAuth {
+	if baseURL == "" {
+		baseURL = GiteaURL
+	}
+	return &GiteaAuth{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		token:     token,
+		transport: newTransport(opts),
+	}
+}
+
+// NewAzureAuth returns an AuthProvider for an organization of the Azure
+// DevOps instance at baseURL, or dev.azure.com when baseURL is empty
+func NewAzureAuth(baseURL, organization, token string, opts ...Option) *AzureAuth {
+	if baseURL == "" {
+		baseURL = AzureURL
+	}
+	return &AzureAuth{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		organization: organization,
+		token:        token,
+		transport:    newTransport(opts),
+	}
+}
+
+func (g *GitHubAuth) Authenticate(ctx context.Context) error {
+	// The oauth2 client sends its requests through the retrying transport
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: g.token},
 	)
-	tc := oauth2.NewClient(ctx, ts)
-	g.client = github.NewClient(tc)
+	tc := oauth2.NewClient(context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: g.transport}), ts)
+	if g.baseURL == "" {
+		g.client = github.NewClient(tc)
+	} else {
+		// Uploads are not used, so they share the API root
+		client, err := github.NewEnterpriseClient(g.baseURL, g.baseURL, tc)
+		if err != nil {
+			return fmt.Errorf("failed to create GitHub Enterprise client: %v", err)
+		}
+		g.client = client
+	}
 
 	// Verify the token works
 	_, _, err := g.client.Users.Get(ctx, "")
@@ -57,15 +199,27 @@ func (g *GitHubAuth) GetClient() interface{} {
 	return g.client
 }
 
-func (g *GitLabAuth) Authenticate() error {
-	client, err := gitlab.NewClient(g.token)
+func (g *GitHubAuth) Quota() (Quota, bool) {
+	return g.transport.Quota()
+}
+
+func (g *GitLabAuth) Authenticate(ctx context.Context) error {
+	// Retries are handled by our transport rather than the client's own policy
+	options := []gitlab.ClientOptionFunc{
+		gitlab.WithHTTPClient(&http.Client{Transport: g.transport}),
+		gitlab.WithoutRetries(),
+	}
+	if g.baseURL != "" {
+		options = append(options, gitlab.WithBaseURL(g.baseURL))
+	}
+	client, err := gitlab.NewClient(g.token, options...)
 	if err != nil {
 		return fmt.Errorf("failed to create GitLab client: %v", err)
 	}
 	g.client = client
 
 	// Verify the token works
-	_, _, err = g.client.Users.CurrentUser()
+	_, _, err = g.client.Users.CurrentUser(gitlab.WithContext(ctx))
 	if err != nil {
 		return fmt.Errorf("failed to authenticate with GitLab: %v", err)
 	}
@@ -77,13 +231,201 @@ func (g *GitLabAuth) GetClient() interface{} {
 	return g.client
 }
 
+func (g *GitLabAuth) Quota() (Quota, bool) {
+	return g.transport.Quota()
+}
+
+func (b *BitbucketAuth) Authenticate(ctx context.Context) error {
+	authorization := "Bearer " + b.token
+	if username, secret := SplitBitbucketToken(b.token); username != "" {
+		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+secret))
+	}
+	b.client = &http.Client{Transport: &authTransport{base: b.transport, authorization: authorization}}
+
+	// Verify the token works. Access tokens belong to a repository or
+	// project rather than a user, so list repositories instead of asking who
+	// the user is.
+	verifyURL := b.baseURL + "/rest/api/1.0/repos?limit=1"
+	if b.Cloud() {
+		verifyURL = b.baseURL + "/repositories?role=member&pagelen=1"
+	}
+	resp, err := get(ctx, b.client, verifyURL)
+	if err != nil {
+		return fmt.Errorf("failed to authenticate with Bitbucket: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to authenticate with Bitbucket: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// GetClient returns an *http.Client that authenticates its requests
+func (b *BitbucketAuth) GetClient() interface{} {
+	return b.client
+}
+
+func (b *BitbucketAuth) Quota() (Quota, bool) {
+	return b.transport.Quota()
+}
+
+// BaseURL returns the API root requests are sent to: the /2.0 API of
+// Bitbucket Cloud, or the root of a Bitbucket Server instance
+func (b *BitbucketAuth) BaseURL() string {
+	return b.baseURL
+}
+
+// Cloud reports whether the base URL is a Bitbucket Cloud API root, which
+// unlike Bitbucket Server serves its API under /2.0
+func (b *BitbucketAuth) Cloud() bool {
+	return strings.HasSuffix(b.baseURL, "/2.0")
+}
+
+// SplitBitbucketToken splits an app password token (username:app-password)
+// into its parts. Access tokens have no username.
+func SplitBitbucketToken(token string) (username, secret string) {
+	if username, secret, ok := strings.Cut(token, ":"); ok {
+		return username, secret
+	}
+	return "", token
+}
+
+func (g *GiteaAuth) Authenticate(ctx context.Context) error {
+	g.client = &http.Client{Transport: &authTransport{base: g.transport, authorization: "token " + g.token}}
+
+	// Verify the token works
+	resp, err := get(ctx, g.client, g.baseURL+"/api/v1/user")
+	if err != nil {
+		return fmt.Errorf("failed to authenticate with Gitea: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to authenticate with Gitea: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// GetClient returns an *http.Client that authenticates its requests
+func (g *GiteaAuth) GetClient() interface{} {
+	return g.client
+}
+
+func (g *GiteaAuth) Quota() (Quota, bool) {
+	return g.transport.Quota()
+}
+
+// BaseURL returns the root of the Gitea instance
+func (g *GiteaAuth) BaseURL() string {
+	return g.baseURL
+}
+
+func (a *AzureAuth) Authenticate(ctx context.Context) error {
+	if a.organization == "" {
+		return fmt.Errorf("failed to authenticate with Azure DevOps: no organization is set")
+	}
+	// Personal access tokens are sent as the password of an empty username
+	authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(":"+a.token))
+	a.client = &http.Client{Transport: &authTransport{base: a.transport, authorization: authorization}}
+
+	// Verify the token works. A rejected token may be answered with a sign-in
+	// page and 203 Non-Authoritative Information rather than 401.
+	resp, err := get(ctx, a.client, a.baseURL+"/"+url.PathEscape(a.organization)+"/_apis/connectionData")
+	if err != nil {
+		return fmt.Errorf("failed to authenticate with Azure DevOps: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to authenticate with Azure DevOps: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// GetClient returns an *http.Client that authenticates its requests
+func (a *AzureAuth) GetClient() interface{} {
+	return a.client
+}
+
+func (a *AzureAuth) Quota() (Quota, bool) {
+	return a.transport.Quota()
+}
+
+// BaseURL returns the root of the Azure DevOps instance, under which
+// organizations are
+func (a *AzureAuth) BaseURL() string {
+	return a.baseURL
+}
+
+// Organization returns the organization the token is verified against
+func (a *AzureAuth) Organization() string {
+	return a.organization
+}
+
+// authTransport sets the Authorization header of each request, for providers
+// whose API is called without a client library
+type authTransport struct {
+	base          http.RoundTripper
+	authorization string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", t.authorization)
+	return t.base.RoundTrip(req)
+}
+
+// get sends a GET request that is cancelled with ctx
+func get(ctx context.Context, client *http.Client, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
 func GetTokenFromEnv(provider string) string {
 	switch provider {
 	case "github":
 		return os.Getenv("GITHUB_TOKEN")
 	case "gitlab":
 		return os.Getenv("GITLAB_TOKEN")
+	case "bitbucket":
+		return os.Getenv("BITBUCKET_TOKEN")
+	case "gitea":
+		return os.Getenv("GITEA_TOKEN")
+	case "azure":
+		return os.Getenv("AZURE_DEVOPS_TOKEN")
 	default:
 		return ""
 	}
 }
+
+// GetBaseURLFromEnv returns the API URL set for a provider in the
+// environment, or "" for the provider's public service: GITHUB_URL for GitHub
+// Enterprise Server, GITLAB_URL for a self-managed GitLab, BITBUCKET_URL for a
+// Bitbucket Server instance, GITEA_URL for a self-hosted Gitea and
+// AZURE_DEVOPS_URL for Azure DevOps Server
+func GetBaseURLFromEnv(provider string) string {
+	switch provider {
+	case "github":
+		return os.Getenv("GITHUB_URL")
+	case "gitlab":
+		return os.Getenv("GITLAB_URL")
+	case "bitbucket":
+		return os.Getenv("BITBUCKET_URL")
+	case "gitea":
+		return os.Getenv("GITEA_URL")
+	case "azure":
+		return os.Getenv("AZURE_DEVOPS_URL")
+	default:
+		return ""
+	}
+}
+
+// GetAzureOrganizationFromEnv returns the Azure DevOps organization set in
+// AZURE_DEVOPS_ORG, whose repositories are listed when none is named
+func GetAzureOrganizationFromEnv() string {
+	return os.Getenv("AZURE_DEVOPS_ORG")
+}
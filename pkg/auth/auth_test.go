@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBitbucketAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		verifyPath string
+		basic      bool
+	}{
+		{name: "cloud app password", path: "/2.0", token: "alice:secret", verifyPath: "/2.0/repositories", basic: true},
+		{name: "cloud access token", path: "/2.0", token: "secret", verifyPath: "/2.0/repositories"},
+		{name: "server access token", path: "", token: "secret", verifyPath: "/rest/api/1.0/repos"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.verifyPath {
+					t.Errorf("Expected a request to %s, got %s", tt.verifyPath, r.URL.Path)
+				}
+				username, password, ok := r.BasicAuth()
+				switch {
+				case tt.basic && (!ok || username != "alice" || password != "secret"):
+					t.Errorf("Expected basic auth for alice, got %q", r.Header.Get("Authorization"))
+				case !tt.basic && r.Header.Get("Authorization") != "Bearer secret":
+					t.Errorf("Expected a bearer token, got %q", r.Header.Get("Authorization"))
+				}
+				w.Write([]byte(`{"values": []}`))
+			}))
+			defer server.Close()
+
+			bitbucketAuth := NewBitbucketAuth(server.URL+tt.path+"/", tt.token)
+			if err := bitbucketAuth.Authenticate(context.Background()); err != nil {
+				t.Fatalf("Authenticate failed: %v", err)
+			}
+			if cloud := tt.path != ""; bitbucketAuth.Cloud() != cloud {
+				t.Errorf("Expected Cloud() to be %v", cloud)
+			}
+		})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	if err := NewBitbucketAuth(server.URL, "wrong").Authenticate(context.Background()); err == nil {
+		t.Error("Expected a rejected token to fail authentication")
+	}
+}
+
+func TestGiteaAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/user" || r.Header.Get("Authorization") != "token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"login": "alice"}`))
+	}))
+	defer server.Close()
+
+	if err := NewGiteaAuth(server.URL+"/", "secret").Authenticate(context.Background()); err != nil {
+		t.Errorf("Authenticate failed: %v", err)
+	}
+	if err := NewGiteaAuth(server.URL, "wrong").Authenticate(context.Background()); err == nil {
+		t.Error("Expected a rejected token to fail authentication")
+	}
+}
+
+func TestAzureAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/acme/_apis/connectionData" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// Azure DevOps answers rejected tokens with a sign-in page
+		if r.Header.Get("Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(":secret")) {
+			w.WriteHeader(http.StatusNonAuthoritativeInfo)
+			return
+		}
+		w.Write([]byte(`{"authenticatedUser": {}}`))
+	}))
+	defer server.Close()
+
+	if err := NewAzureAuth(server.URL+"/", "acme", "secret").Authenticate(context.Background()); err != nil {
+		t.Errorf("Authenticate failed: %v", err)
+	}
+	if err := NewAzureAuth(server.URL, "acme", "wrong").Authenticate(context.Background()); err == nil {
+		t.Error("Expected a rejected token to fail authentication")
+	}
+	if err := NewAzureAuth(server.URL, "", "secret").Authenticate(context.Background()); err == nil {
+		t.Error("Expected authentication without an organization to fail")
+	}
+}
+
+func TestEnterpriseAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/user":
+			if r.Header.Get("Authorization") == "Bearer secret" {
+				w.Write([]byte(`{"login": "alice"}`))
+				return
+			}
+		case "/api/v4/user":
+			if r.Header.Get("Private-Token") == "secret" {
+				w.Write([]byte(`{"username": "alice"}`))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	if err := NewGitHubAuth(server.URL, "secret").Authenticate(context.Background()); err != nil {
+		t.Errorf("GitHub Enterprise authentication failed: %v", err)
+	}
+	if err := NewGitLabAuth(server.URL, "secret").Authenticate(context.Background()); err != nil {
+		t.Errorf("Self-managed GitLab authentication failed: %v", err)
+	}
+	if err := NewGitLabAuth(server.URL, "wrong").Authenticate(context.Background()); err == nil {
+		t.Error("Expected a rejected token to fail authentication")
+	}
+}
+
+func TestAuthenticate_Cancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	providers := map[string]AuthProvider{
+		"github":    NewGitHubAuth(server.URL, "secret"),
+		"gitlab":    NewGitLabAuth(server.URL, "secret"),
+		"bitbucket": NewBitbucketAuth(server.URL, "secret"),
+		"gitea":     NewGiteaAuth(server.URL, "secret"),
+		"azure":     NewAzureAuth(server.URL, "acme", "secret"),
+	}
+	for name, provider := range providers {
+		if err := provider.Authenticate(ctx); err == nil {
+			t.Errorf("Expected %s authentication to fail once the context is cancelled", name)
+		}
+	}
+}
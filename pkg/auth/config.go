@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// publicHosts are the hosts of the providers' public services
+var publicHosts = map[string]string{
+	"github":    "github.com",
+	"gitlab":    "gitlab.com",
+	"bitbucket": "bitbucket.org",
+	"gitea":     "gitea.com",
+	"azure":     "dev.azure.com",
+}
+
+// Config is the configuration of the providers, of the form
+// {"providers": {"gitlab": {"baseUrl": "https://gitlab.example.com"}}}
+type Config struct {
+	Providers map[string]ProviderConfig `json:"providers"`
+}
+
+// ProviderConfig is the configuration of one provider
+type ProviderConfig struct {
+	// BaseURL is the root of a self-hosted instance, such as GitHub
+	// Enterprise Server or a self-managed GitLab
+	BaseURL string `json:"baseUrl,omitempty"`
+}
+
+func getConfigPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %v", err)
+	}
+	return filepath.Join(homeDir, ".repo-analyzer.json"), nil
+}
+
+// LoadConfig reads the configuration at path, or at ~/.repo-analyzer.json
+// when path is empty, which need not exist
+func LoadConfig(path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		var err error
+		if path, err = getConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+	for provider, entry := range config.Providers {
+		if _, ok := publicHosts[provider]; !ok {
+			return nil, fmt.Errorf("config: unsupported provider: %q", provider)
+		}
+		if entry.BaseURL != "" {
+			if err := ValidateBaseURL(entry.BaseURL); err != nil {
+				return nil, fmt.Errorf("config: %s: %v", provider, err)
+			}
+		}
+	}
+
+	return &config, nil
+}
+
+// BaseURL returns the root of the instance a provider is used at: the one
+// set in the environment, else the one configured, else "" for the
+// provider's public service
+func (c *Config) BaseURL(provider string) string {
+	if baseURL := GetBaseURLFromEnv(provider); baseURL != "" {
+		return baseURL
+	}
+	if c == nil {
+		return ""
+	}
+	return c.Providers[provider].BaseURL
+}
+
+// ValidateBaseURL checks that a base URL is an absolute http or https URL
+func ValidateBaseURL(baseURL string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid base URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid base URL %q: expected an http or https URL", baseURL)
+	}
+	return nil
+}
+
+// ProviderHost returns the host a provider is used at: the host of baseURL,
+// or the provider's public host when baseURL is empty
+func ProviderHost(provider, baseURL string) string {
+	if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+		return strings.ToLower(u.Host)
+	}
+	return publicHosts[provider]
+}
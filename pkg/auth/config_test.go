@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "base URLs", data: `{"providers": {"gitlab": {"baseUrl": "https://gitlab.example.com"}, "github": {}}}`},
+		{name: "unknown provider", data: `{"providers": {"svn": {"baseUrl": "https://svn.example.com"}}}`, wantErr: true},
+		{name: "relative base URL", data: `{"providers": {"gitlab": {"baseUrl": "gitlab.example.com"}}}`, wantErr: true},
+		{name: "invalid JSON", data: `{"providers": [`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+			config, err := LoadConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && config.BaseURL("gitlab") != "https://gitlab.example.com" {
+				t.Errorf("Expected the configured GitLab URL, got %q", config.BaseURL("gitlab"))
+			}
+		})
+	}
+
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing config file")
+	}
+	t.Setenv("HOME", t.TempDir())
+	if config, err := LoadConfig(""); err != nil || config.BaseURL("github") != "" {
+		t.Errorf("Expected an empty config without a config file, got %+v, %v", config, err)
+	}
+}
+
+func TestConfig_BaseURL(t *testing.T) {
+	config := &Config{Providers: map[string]ProviderConfig{"github": {BaseURL: "https://ghe.example.com"}}}
+	if got := config.BaseURL("github"); got != "https://ghe.example.com" {
+		t.Errorf("Expected the configured URL, got %q", got)
+	}
+
+	// The environment takes precedence over the config file
+	t.Setenv("GITHUB_URL", "https://ghe.internal")
+	if got := config.BaseURL("github"); got != "https://ghe.internal" {
+		t.Errorf("Expected the URL set in the environment, got %q", got)
+	}
+	if got := ProviderHost("github", config.BaseURL("github")); got != "ghe.internal" {
+		t.Errorf("Expected host ghe.internal, got %q", got)
+	}
+	if got := ProviderHost("gitlab", ""); got != "gitlab.com" {
+		t.Errorf("Expected host gitlab.com, got %q", got)
+	}
+}
+
+func TestTokenCacheHosts(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if err := SaveToken("gitlab", "gitlab.com", "public"); err != nil {
+		t.Fatalf("SaveToken failed: %v", err)
+	}
+	if err := SaveToken("gitlab", "gitlab.example.com", "on-prem"); err != nil {
+		t.Fatalf("SaveToken failed: %v", err)
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"gitlab.com", "public"},
+		{"gitlab.example.com", "on-prem"},
+		{"gitlab.other.com", ""},
+	}
+	for _, tt := range tests {
+		token, err := GetCachedToken("gitlab", tt.host)
+		if err != nil {
+			t.Fatalf("GetCachedToken failed: %v", err)
+		}
+		if token != tt.want {
+			t.Errorf("Expected token %q for %s, got %q", tt.want, tt.host, token)
+		}
+	}
+
+	cache, err := LoadTokens()
+	if err != nil {
+		t.Fatalf("LoadTokens failed: %v", err)
+	}
+	if cache.GitLabToken != "public" {
+		t.Errorf("Expected the gitlab.com token in gitlab_token, got %q", cache.GitLabToken)
+	}
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// CredentialEnvPrefix starts the environment variables that define
+// credentials, such as REPO_ANALYZER_CREDENTIAL_CI_TOKEN
+const CredentialEnvPrefix = "REPO_ANALYZER_CREDENTIAL_"
+
+// Credential is a token the server holds on behalf of its clients, which
+// requests reference by name
+type Credential struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	// Host is the provider host the token is for (the host the server is
+	// configured to use for the provider when empty)
+	Host  string `json:"host,omitempty"`
+	Token string `json:"token,omitempty"`
+	// TokenEnv names an environment variable holding the token, so the
+	// file itself need not contain secrets
+	TokenEnv string `json:"tokenEnv,omitempty"`
+}
+
+// CredentialStore holds named credentials
+type CredentialStore struct {
+	credentials map[string]Credential
+}
+
+// NewCredentialStore returns an empty store
+func NewCredentialStore() *CredentialStore {
+	return &CredentialStore{credentials: map[string]Credential{}}
+}
+
+// LoadCredentials adds the credentials of a JSON file of the form
+// {"credentials": [{"name": ..., "provider": ..., "token": ...}]}
+func (s *CredentialStore) LoadCredentials(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials file: %v", err)
+	}
+
+	var file struct {
+		Credentials []Credential `json:"credentials"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse credentials file: %v", err)
+	}
+
+	for _, cred := range file.Credentials {
+		if cred.TokenEnv != "" {
+			cred.Token = os.Getenv(cred.TokenEnv)
+			if cred.Token == "" {
+				return fmt.Errorf("credential %q: environment variable %s is not set", cred.Name, cred.TokenEnv)
+			}
+		}
+		if err := s.Add(cred); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadEnv adds the credentials defined by environment variables of the form
+// REPO_ANALYZER_CREDENTIAL_<NAME>_PROVIDER, _TOKEN and optionally _HOST. The
+// name is lower-cased, with underscores turned into dashes.
+func (s *CredentialStore) LoadEnv(environ []string) error {
+	found := map[string]*Credential{}
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, CredentialEnvPrefix) {
+			continue
+		}
+		key = strings.TrimPrefix(key, CredentialEnvPrefix)
+
+		var name, field string
+		for _, suffix := range []string{"_PROVIDER", "_TOKEN", "_HOST"} {
+			if strings.HasSuffix(key, suffix) {
+				name, field = strings.TrimSuffix(key, suffix), suffix
+				break
+			}
+		}
+		if name == "" {
+			continue
+		}
+		name = strings.ReplaceAll(strings.ToLower(name), "_", "-")
+
+		cred, ok := found[name]
+		if !ok {
+			cred = &Credential{Name: name}
+			found[name] = cred
+		}
+		switch field {
+		case "_PROVIDER":
+			cred.Provider = value
+		case "_TOKEN":
+			cred.Token = value
+		case "_HOST":
+			cred.Host = value
+		}
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := s.Add(*found[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add adds a credential, failing if its name is taken or it is incomplete
+func (s *CredentialStore) Add(cred Credential) error {
+	if cred.Name == "" {
+		return fmt.Errorf("credential has no name")
+	}
+	if _, ok := s.credentials[cred.Name]; ok {
+		return fmt.Errorf("credential %q is defined twice", cred.Name)
+	}
+	switch cred.Provider {
+	case "github", "gitlab", "bitbucket", "gitea", "azure":
+	default:
+		return fmt.Errorf("credential %q: unsupported provider: %q", cred.Name, cred.Provider)
+	}
+	if cred.Token == "" {
+		return fmt.Errorf("credential %q has no token", cred.Name)
+	}
+	cred.TokenEnv = ""
+	s.credentials[cred.Name] = cred
+	return nil
+}
+
+// Get returns the credential with a name
+func (s *CredentialStore) Get(name string) (Credential, bool) {
+	cred, ok := s.credentials[name]
+	return cred, ok
+}
+
+// Names returns the names of the credentials, sorted
+func (s *CredentialStore) Names() []string {
+	names := make([]string, 0, len(s.credentials))
+	for name := range s.credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCredentialStore_LoadCredentials(t *testing.T) {
+	t.Setenv("CI_GITLAB_TOKEN", "from-env")
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	data := `{"credentials": [
+		{"name": "ci-github", "provider": "github", "token": "secret"},
+		{"name": "ci-gitlab", "provider": "gitlab", "host": "gitlab.com", "tokenEnv": "CI_GITLAB_TOKEN"}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("Failed to write credentials: %v", err)
+	}
+
+	store := NewCredentialStore()
+	if err := store.LoadCredentials(path); err != nil {
+		t.Fatalf("LoadCredentials failed: %v", err)
+	}
+
+	if names := store.Names(); !reflect.DeepEqual(names, []string{"ci-github", "ci-gitlab"}) {
+		t.Errorf("Expected both credentials, got %v", names)
+	}
+	cred, ok := store.Get("ci-gitlab")
+	if !ok || cred.Token != "from-env" || cred.Host != "gitlab.com" {
+		t.Errorf("Expected the token from the environment, got %+v", cred)
+	}
+	if _, ok := store.Get("missing"); ok {
+		t.Error("Expected no credential named missing")
+	}
+}
+
+func TestCredentialStore_LoadEnv(t *testing.T) {
+	store := NewCredentialStore()
+	err := store.LoadEnv([]string{
+		"REPO_ANALYZER_CREDENTIAL_CI_BOT_PROVIDER=github",
+		"REPO_ANALYZER_CREDENTIAL_CI_BOT_TOKEN=secret",
+		"REPO_ANALYZER_CREDENTIAL_CI_BOT_HOST=github.com",
+		"GITHUB_TOKEN=ignored",
+	})
+	if err != nil {
+		t.Fatalf("LoadEnv failed: %v", err)
+	}
+
+	expected := Credential{Name: "ci-bot", Provider: "github", Host: "github.com", Token: "secret"}
+	if cred, ok := store.Get("ci-bot"); !ok || cred != expected {
+		t.Errorf("Expected %+v, got %+v", expected, cred)
+	}
+}
+
+func TestCredentialStore_Add(t *testing.T) {
+	tests := []struct {
+		name    string
+		cred    Credential
+		wantErr bool
+	}{
+		{"valid", Credential{Name: "a", Provider: "github", Token: "t"}, false},
+		{"duplicate", Credential{Name: "a", Provider: "gitlab", Token: "t"}, true},
+		{"no name", Credential{Provider: "github", Token: "t"}, true},
+		{"no token", Credential{Name: "b", Provider: "github"}, true},
+		{"unsupported provider", Credential{Name: "c", Provider: "local", Token: "t"}, true},
+	}
+
+	store := NewCredentialStore()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := store.Add(tt.cred); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
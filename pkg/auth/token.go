@@ -7,9 +7,15 @@ import (
 	"path/filepath"
 )
 
+// TokenCache holds the tokens of the providers' public services by
+// provider, and those of self-hosted instances by host
 type TokenCache struct {
-	GitHubToken string `json:"github_token"`
-	GitLabToken string `json:"gitlab_token"`
+	GitHubToken    string            `json:"github_token"`
+	GitLabToken    string            `json:"gitlab_token"`
+	BitbucketToken string            `json:"bitbucket_token,omitempty"`
+	GiteaToken     string            `json:"gitea_token,omitempty"`
+	AzureToken     string            `json:"azure_token,omitempty"`
+	Hosts          map[string]string `json:"hosts,omitempty"`
 }
 
 func getCachePath() (string, error) {
@@ -63,35 +69,62 @@ func SaveTokens(cache *TokenCache) error {
 	return nil
 }
 
-func GetCachedToken(provider string) (string, error) {
+// GetCachedToken returns the token cached for a provider at a host, or ""
+// when there is none
+func GetCachedToken(provider, host string) (string, error) {
 	cache, err := LoadTokens()
 	if err != nil {
 		return "", err
 	}
 
+	if host != publicHosts[provider] {
+		return cache.Hosts[host], nil
+	}
 	switch provider {
 	case "github":
 		return cache.GitHubToken, nil
 	case "gitlab":
 		return cache.GitLabToken, nil
+	case "bitbucket":
+		return cache.BitbucketToken, nil
+	case "gitea":
+		return cache.GiteaToken, nil
+	case "azure":
+		return cache.AzureToken, nil
 	default:
 		return "", fmt.Errorf("unsupported provider: %s", provider)
 	}
 }
 
-func SaveToken(provider, token string) error {
+// SaveToken caches the token of a provider at a host, keeping the tokens of
+// other hosts
+func SaveToken(provider, host, token string) error {
 	cache, err := LoadTokens()
 	if err != nil {
 		return err
 	}
 
+	if _, ok := publicHosts[provider]; !ok {
+		return fmt.Errorf("unsupported provider: %s", provider)
+	}
+	if host != publicHosts[provider] {
+		if cache.Hosts == nil {
+			cache.Hosts = map[string]string{}
+		}
+		cache.Hosts[host] = token
+		return SaveTokens(cache)
+	}
 	switch provider {
 	case "github":
 		cache.GitHubToken = token
 	case "gitlab":
 		cache.GitLabToken = token
-	default:
-		return fmt.Errorf("unsupported provider: %s", provider)
+	case "bitbucket":
+		cache.BitbucketToken = token
+	case "gitea":
+		cache.GiteaToken = token
+	case "azure":
+		cache.AzureToken = token
 	}
 
 	return SaveTokens(cache)
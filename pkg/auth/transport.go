@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Quota is the rate limit most recently reported by a provider
+type Quota struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// RetryTransport retries requests that failed transiently or hit a rate
+// limit, backing off with jitter between attempts. It also records the quota
+// headers of every response so callers can report the remaining quota.
+type RetryTransport struct {
+	Base       http.RoundTripper
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// MaxResetWait is the longest the transport sleeps for an exhausted quota
+	// to reset. Zero means such responses are returned to the caller instead.
+	MaxResetWait time.Duration
+
+	mu       sync.Mutex
+	quota    Quota
+	hasQuota bool
+}
+
+// NewRetryTransport returns a RetryTransport around base with default settings.
+// A nil base uses http.DefaultTransport.
+func NewRetryTransport(base http.RoundTripper) *RetryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RetryTransport{
+		Base:       base,
+		MaxRetries: 5,
+		BaseDelay:  500 * time.Millisecond,
+		MaxDelay:   30 * time.Second,
+	}
+}
+
+// Quota returns the last quota reported by the provider, and false when no
+// response carried rate limit headers yet
+func (t *RetryTransport) Quota() (Quota, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.quota, t.hasQuota
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.Base.RoundTrip(req)
+		if resp != nil {
+			t.recordQuota(resp.Header)
+		}
+
+		delay, retry := t.retryDelay(req.Context(), resp, err, attempt)
+		// A body that cannot be replayed rules out a second attempt
+		if !retry || attempt >= t.MaxRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+
+		if req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryDelay decides whether an attempt should be retried and how long to wait first
+func (t *RetryTransport) retryDelay(ctx context.Context, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if err != nil {
+		// Network errors are transient, but a cancelled request is not
+		return t.backoff(attempt), ctx.Err() == nil
+	}
+
+	if delay, ok := retryAfter(resp.Header); ok && isRateLimited(resp) {
+		return delay, delay <= t.MaxDelay || delay <= t.MaxResetWait
+	}
+
+	switch {
+	case isRateLimited(resp):
+		// Primary rate limits only lift when the quota resets
+		quota, ok := parseQuota(resp.Header)
+		if ok && quota.Remaining == 0 {
+			wait := time.Until(quota.Reset)
+			if t.MaxResetWait <= 0 || wait > t.MaxResetWait {
+				return 0, false
+			}
+			return wait, true
+		}
+		// Secondary rate limits without guidance call for a cautious backoff
+		return t.backoff(attempt + 2), true
+	case resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable,
+		resp.StatusCode == http.StatusGatewayTimeout:
+		return t.backoff(attempt), true
+	}
+
+	return 0, false
+}
+
+// backoff returns a random delay up to an exponentially growing ceiling
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	ceiling := t.BaseDelay << attempt
+	if ceiling <= 0 || ceiling > t.MaxDelay {
+		ceiling = t.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func (t *RetryTransport) recordQuota(header http.Header) {
+	quota, ok := parseQuota(header)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.quota = quota
+	t.hasQuota = true
+}
+
+// isRateLimited reports whether a response was rejected by a primary or
+// secondary rate limit. GitHub signals both with 403 as well as 429.
+func isRateLimited(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		if resp.Header.Get("Retry-After") != "" {
+			return true
+		}
+		quota, ok := parseQuota(resp.Header)
+		return ok && quota.Remaining == 0
+	}
+	return false
+}
+
+// parseQuota reads GitHub's X-RateLimit-* or GitLab's RateLimit-* headers
+func parseQuota(header http.Header) (Quota, bool) {
+	for _, prefix := range []string{"X-RateLimit-", "RateLimit-"} {
+		remaining, err := strconv.Atoi(header.Get(prefix + "Remaining"))
+		if err != nil {
+			continue
+		}
+		reset, err := strconv.ParseInt(header.Get(prefix+"Reset"), 10, 64)
+		if err != nil {
+			continue
+		}
+		limit, _ := strconv.Atoi(header.Get(prefix + "Limit"))
+		return Quota{Limit: limit, Remaining: remaining, Reset: time.Unix(reset, 0)}, true
+	}
+	return Quota{}, false
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date
+func retryAfter(header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name     string
+		failures int
+		status   int
+		header   map[string]string
+		want     int
+		calls    int
+	}{
+		{name: "success", failures: 0, want: http.StatusOK, calls: 1},
+		{name: "too many requests with retry-after", failures: 2, status: http.StatusTooManyRequests,
+			header: map[string]string{"Retry-After": "0"}, want: http.StatusOK, calls: 3},
+		{name: "secondary rate limit", failures: 1, status: http.StatusForbidden,
+			header: map[string]string{"Retry-After": "0"}, want: http.StatusOK, calls: 2},
+		{name: "service unavailable", failures: 1, status: http.StatusServiceUnavailable,
+			want: http.StatusOK, calls: 2},
+		{name: "plain forbidden", failures: 1, status: http.StatusForbidden,
+			want: http.StatusForbidden, calls: 1},
+		{name: "quota exhausted", failures: 1, status: http.StatusForbidden,
+			header: map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset},
+			want:   http.StatusForbidden, calls: 1},
+		{name: "retries exhausted", failures: 10, status: http.StatusBadGateway,
+			want: http.StatusBadGateway, calls: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if body := readAll(t, r); body != "payload" {
+					t.Errorf("Expected body %q, got %q", "payload", body)
+				}
+				if calls <= tt.failures {
+					for k, v := range tt.header {
+						w.Header().Set(k, v)
+					}
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			transport := NewRetryTransport(nil)
+			transport.MaxRetries = 2
+			transport.BaseDelay = time.Millisecond
+			transport.MaxDelay = time.Millisecond
+			client := &http.Client{Transport: transport}
+
+			resp, err := client.Post(server.URL, "text/plain", strings.NewReader("payload"))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, resp.StatusCode)
+			}
+			if calls != tt.calls {
+				t.Errorf("Expected %d calls, got %d", tt.calls, calls)
+			}
+		})
+	}
+}
+
+func TestRetryTransport_Quota(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("RateLimit-Limit", "2000")
+		w.Header().Set("RateLimit-Remaining", "1999")
+		w.Header().Set("RateLimit-Reset", "1700000000")
+	}))
+	defer server.Close()
+
+	transport := NewRetryTransport(nil)
+	if _, ok := transport.Quota(); ok {
+		t.Errorf("Expected no quota before the first request")
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	quota, ok := transport.Quota()
+	if !ok {
+		t.Fatalf("Expected a quota after the request")
+	}
+	want := Quota{Limit: 2000, Remaining: 1999, Reset: time.Unix(1700000000, 0)}
+	if quota.Limit != want.Limit || quota.Remaining != want.Remaining || !quota.Reset.Equal(want.Reset) {
+		t.Errorf("Expected %+v, got %+v", want, quota)
+	}
+}
+
+func readAll(t *testing.T, r *http.Request) string {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Errorf("failed to read body: %v", err)
+	}
+	return string(body)
+}
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Store keeps JSON encoded values on disk, addressed by a hash of their key.
+// It is meant for data that never changes once fetched, such as the details
+// of a commit, so entries are never invalidated, only pruned. A nil Store is
+// valid and caches nothing.
+type Store struct {
+	dir string
+}
+
+// Stats describes the contents of a Store
+type Stats struct {
+	Dir     string
+	Entries int
+	Bytes   int64
+	Oldest  time.Time
+	Newest  time.Time
+}
+
+// PruneResult describes the entries removed by Prune
+type PruneResult struct {
+	Entries int
+	Bytes   int64
+}
+
+// DefaultDir returns the per-user cache directory, usually ~/.cache/repo-analyzer
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %v", err)
+	}
+	return filepath.Join(dir, "repo-analyzer"), nil
+}
+
+// Open returns a Store that keeps its entries in dir, creating it if needed
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the directory holding the entries
+func (s *Store) Dir() string {
+	if s == nil {
+		return ""
+	}
+	return s.dir
+}
+
+// Get decodes the value stored under key into v and reports whether it was found
+func (s *Store) Get(key string, v interface{}) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+
+	path := s.path(key)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read cache entry: %v", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to parse cache entry: %v", err)
+	}
+
+	// Prune removes the entries that have gone unused the longest
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return true, nil
+}
+
+// Put stores v under key, replacing any previous value
+func (s *Store) Put(key string, v interface{}) error {
+	if s == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %v", err)
+	}
+
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial entry
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+	return nil
+}
+
+// Stats walks the store and summarises its entries
+func (s *Store) Stats() (Stats, error) {
+	stats := Stats{Dir: s.Dir()}
+	err := s.walk(func(path string, info fs.FileInfo) error {
+		stats.Entries++
+		stats.Bytes += info.Size()
+		if stats.Oldest.IsZero() || info.ModTime().Before(stats.Oldest) {
+			stats.Oldest = info.ModTime()
+		}
+		if info.ModTime().After(stats.Newest) {
+			stats.Newest = info.ModTime()
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// Prune removes the entries that have not been used for longer than age.
+// An age of zero empties the store.
+func (s *Store) Prune(age time.Duration) (PruneResult, error) {
+	var result PruneResult
+	cutoff := time.Now().Add(-age)
+	err := s.walk(func(path string, info fs.FileInfo) error {
+		if age > 0 && info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove cache entry: %v", err)
+		}
+		result.Entries++
+		result.Bytes += info.Size()
+		return nil
+	})
+	return result, err
+}
+
+// walk calls fn for every entry in the store
+func (s *Store) walk(fn func(path string, info fs.FileInfo) error) error {
+	if s == nil {
+		return nil
+	}
+	return filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return fmt.Errorf("failed to read cache directory: %v", err)
+		}
+		if d.IsDir() || filepath.Base(path)[0] == '.' {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// The entry was removed while walking
+			return nil
+		}
+		return fn(path, info)
+	})
+}
+
+// path returns the file for key, fanned out over subdirectories by hash prefix
+func (s *Store) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(s.dir, name[:2], name)
+}
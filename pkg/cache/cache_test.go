@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStore_GetPut(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	var got []string
+	if found, err := store.Get("missing", &got); err != nil || found {
+		t.Errorf("Expected a miss, got found=%v err=%v", found, err)
+	}
+
+	want := []string{"a", "b"}
+	if err := store.Put("key", want); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	found, err := store.Get("key", &got)
+	if err != nil || !found {
+		t.Fatalf("Expected a hit, got found=%v err=%v", found, err)
+	}
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestStore_Nil(t *testing.T) {
+	var store *Store
+	if err := store.Put("key", 1); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	var got int
+	if found, _ := store.Get("key", &got); found {
+		t.Errorf("Expected a nil store to cache nothing")
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	store.Put("old", "x")
+	store.Put("new", "y")
+
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(store.path("old"), old, old); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	result, err := store.Prune(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if result.Entries != 1 {
+		t.Errorf("Expected 1 entry removed, got %d", result.Entries)
+	}
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Entries != 1 {
+		t.Errorf("Expected 1 entry left, got %d", stats.Entries)
+	}
+	var got string
+	if found, _ := store.Get("new", &got); !found || got != "y" {
+		t.Errorf("Expected the recent entry to survive, got %q", got)
+	}
+}
+
+func TestTransport_NotModified(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(calls))
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, "payload")
+	}))
+	defer server.Close()
+
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	client := &http.Client{Transport: NewTransport(nil, store)}
+
+	for i := 1; i <= 2; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200 on request %d, got %d", i, resp.StatusCode)
+		}
+		if string(body) != "payload" {
+			t.Errorf("Expected body %q on request %d, got %q", "payload", i, body)
+		}
+		// Fresh headers win over the cached ones
+		if got := resp.Header.Get("X-RateLimit-Remaining"); got != strconv.Itoa(i) {
+			t.Errorf("Expected rate limit header %d on request %d, got %s", i, i, got)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 calls, got %d", calls)
+	}
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// response is a cached GET response along with the validator that lets the
+// provider confirm it is still current
+type response struct {
+	ETag   string      `json:"etag"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// Transport makes GET requests conditional on the ETag of the last response
+// it saw for the same URL. When the provider answers 304 Not Modified, the
+// stored response is replayed; GitHub does not count such requests against
+// the rate limit.
+type Transport struct {
+	Base  http.RoundTripper
+	Store *Store
+}
+
+// NewTransport returns a Transport around base that keeps responses in store.
+// A nil base uses http.DefaultTransport.
+func NewTransport(base http.RoundTripper, store *Store) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, Store: store}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Store == nil || req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return t.Base.RoundTrip(req)
+	}
+
+	key := responseKey(req)
+	var stored response
+	found, err := t.Store.Get(key, &stored)
+	if err != nil || stored.ETag == "" {
+		// An unreadable entry is treated like a missing one and overwritten
+		found = false
+	}
+
+	if found && req.Header.Get("If-None-Match") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", stored.ETag)
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && found:
+		resp.Body.Close()
+		// Headers of the fresh response, such as the rate limit, take precedence
+		header := stored.Header.Clone()
+		for name, values := range resp.Header {
+			header[name] = values
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", stored.Status, http.StatusText(stored.Status)),
+			StatusCode:    stored.Status,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(stored.Body)),
+			ContentLength: int64(len(stored.Body)),
+			Request:       req,
+		}, nil
+
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		// A response that cannot be cached is still a valid response
+		t.Store.Put(key, response{
+			ETag:   resp.Header.Get("ETag"),
+			Status: resp.StatusCode,
+			Header: resp.Header,
+			Body:   body,
+		})
+	}
+
+	return resp, nil
+}
+
+// responseKey identifies a response by URL and credentials, since different
+// tokens may be allowed to see different results
+func responseKey(req *http.Request) string {
+	credentials := sha256.Sum256([]byte(req.Header.Get("Authorization") + "\x00" + req.Header.Get("Private-Token")))
+	return "http:" + req.URL.String() + ":" + hex.EncodeToString(credentials[:])
+}
@@ -0,0 +1,402 @@
+package maat
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// revisionSets groups the distinct entities changed by each revision,
+// preserving the order revisions first appear in the log
+func revisionSets(entries []Entry) ([]string, map[string][]string) {
+	var order []string
+	sets := make(map[string][]string)
+	seen := make(map[[2]string]bool)
+	for _, e := range entries {
+		if _, ok := sets[e.Revision]; !ok {
+			order = append(order, e.Revision)
+			sets[e.Revision] = nil
+		}
+		key := [2]string{e.Revision, e.Entity}
+		if !seen[key] {
+			seen[key] = true
+			sets[e.Revision] = append(sets[e.Revision], e.Entity)
+		}
+	}
+	return order, sets
+}
+
+// entityRevisions counts the distinct revisions that touched each entity
+func entityRevisions(entries []Entry) map[string]int {
+	_, sets := revisionSets(entries)
+	revs := make(map[string]int)
+	for _, entities := range sets {
+		for _, entity := range entities {
+			revs[entity]++
+		}
+	}
+	return revs
+}
+
+// authorRevisions counts the distinct revisions per entity and author
+func authorRevisions(entries []Entry) map[string]map[string]int {
+	seen := make(map[[3]string]bool)
+	counts := make(map[string]map[string]int)
+	for _, e := range entries {
+		key := [3]string{e.Entity, e.Author, e.Revision}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if counts[e.Entity] == nil {
+			counts[e.Entity] = make(map[string]int)
+		}
+		counts[e.Entity][e.Author]++
+	}
+	return counts
+}
+
+func summary(entries []Entry, opts Options) *Table {
+	revisions := make(map[string]bool)
+	entities := make(map[string]bool)
+	authors := make(map[string]bool)
+	for _, e := range entries {
+		revisions[e.Revision] = true
+		entities[e.Entity] = true
+		authors[e.Author] = true
+	}
+
+	return &Table{
+		Columns: []string{"statistic", "value"},
+		Rows: [][]interface{}{
+			{"number-of-commits", len(revisions)},
+			{"number-of-entities", len(entities)},
+			{"number-of-entities-changed", len(entries)},
+			{"number-of-authors", len(authors)},
+		},
+	}
+}
+
+func revisions(entries []Entry, opts Options) *Table {
+	revs := entityRevisions(entries)
+
+	var entities []string
+	for entity := range revs {
+		entities = append(entities, entity)
+	}
+	sort.Slice(entities, func(i, j int) bool {
+		if revs[entities[i]] != revs[entities[j]] {
+			return revs[entities[i]] > revs[entities[j]]
+		}
+		return entities[i] < entities[j]
+	})
+
+	table := &Table{Columns: []string{"entity", "n-revs"}}
+	for _, entity := range entities {
+		table.Rows = append(table.Rows, []interface{}{entity, revs[entity]})
+	}
+	return table
+}
+
+func authors(entries []Entry, opts Options) *Table {
+	revs := entityRevisions(entries)
+	byAuthor := authorRevisions(entries)
+
+	var entities []string
+	for entity := range revs {
+		entities = append(entities, entity)
+	}
+	sort.Slice(entities, func(i, j int) bool {
+		a, b := entities[i], entities[j]
+		if len(byAuthor[a]) != len(byAuthor[b]) {
+			return len(byAuthor[a]) > len(byAuthor[b])
+		}
+		if revs[a] != revs[b] {
+			return revs[a] > revs[b]
+		}
+		return a < b
+	})
+
+	table := &Table{Columns: []string{"entity", "n-authors", "n-revs"}}
+	for _, entity := range entities {
+		table.Rows = append(table.Rows, []interface{}{entity, len(byAuthor[entity]), revs[entity]})
+	}
+	return table
+}
+
+func coupling(entries []Entry, opts Options) *Table {
+	order, sets := revisionSets(entries)
+	revs := entityRevisions(entries)
+
+	shared := make(map[[2]string]int)
+	for _, revision := range order {
+		entities := sets[revision]
+		// Large change sets such as reformatting commits carry no coupling signal
+		if len(entities) > opts.MaxChangesetSize {
+			continue
+		}
+		sorted := append([]string(nil), entities...)
+		sort.Strings(sorted)
+		for i := 0; i < len(sorted); i++ {
+			for j := i + 1; j < len(sorted); j++ {
+				shared[[2]string{sorted[i], sorted[j]}]++
+			}
+		}
+	}
+
+	type pair struct {
+		entity, coupled string
+		degree, avgRevs int
+	}
+	var pairs []pair
+	for key, count := range shared {
+		avgRevs := int(math.Ceil(float64(revs[key[0]]+revs[key[1]]) / 2))
+		degree := int(float64(count) / float64(avgRevs) * 100)
+		if avgRevs < opts.MinRevs || count < opts.MinSharedRevs {
+			continue
+		}
+		if degree < opts.MinCoupling || degree > opts.MaxCoupling {
+			continue
+		}
+		pairs = append(pairs, pair{key[0], key[1], degree, avgRevs})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].degree != pairs[j].degree {
+			return pairs[i].degree > pairs[j].degree
+		}
+		if pairs[i].entity != pairs[j].entity {
+			return pairs[i].entity < pairs[j].entity
+		}
+		return pairs[i].coupled < pairs[j].coupled
+	})
+
+	table := &Table{Columns: []string{"entity", "coupled", "degree", "average-revs"}}
+	for _, p := range pairs {
+		table.Rows = append(table.Rows, []interface{}{p.entity, p.coupled, p.degree, p.avgRevs})
+	}
+	return table
+}
+
+func sumOfCoupling(entries []Entry, opts Options) *Table {
+	order, sets := revisionSets(entries)
+
+	soc := make(map[string]int)
+	for _, revision := range order {
+		entities := sets[revision]
+		if len(entities) > opts.MaxChangesetSize {
+			continue
+		}
+		for _, entity := range entities {
+			soc[entity] += len(entities) - 1
+		}
+	}
+
+	var entities []string
+	for entity := range soc {
+		entities = append(entities, entity)
+	}
+	sort.Slice(entities, func(i, j int) bool {
+		if soc[entities[i]] != soc[entities[j]] {
+			return soc[entities[i]] > soc[entities[j]]
+		}
+		return entities[i] < entities[j]
+	})
+
+	table := &Table{Columns: []string{"entity", "soc"}}
+	for _, entity := range entities {
+		table.Rows = append(table.Rows, []interface{}{entity, soc[entity]})
+	}
+	return table
+}
+
+type churn struct {
+	added, deleted int
+}
+
+// authorChurnByEntity sums the lines each author added and deleted per entity
+func authorChurnByEntity(entries []Entry) map[string]map[string]churn {
+	result := make(map[string]map[string]churn)
+	for _, e := range entries {
+		if result[e.Entity] == nil {
+			result[e.Entity] = make(map[string]churn)
+		}
+		c := result[e.Entity][e.Author]
+		c.added += e.Added
+		c.deleted += e.Deleted
+		result[e.Entity][e.Author] = c
+	}
+	return result
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func entityOwnership(entries []Entry, opts Options) *Table {
+	churnByEntity := authorChurnByEntity(entries)
+
+	table := &Table{Columns: []string{"entity", "author", "author-added", "author-deleted"}}
+	for _, entity := range sortedKeys(churnByEntity) {
+		for _, author := range sortedKeys(churnByEntity[entity]) {
+			c := churnByEntity[entity][author]
+			table.Rows = append(table.Rows, []interface{}{entity, author, c.added, c.deleted})
+		}
+	}
+	return table
+}
+
+func mainDev(entries []Entry, opts Options) *Table {
+	churnByEntity := authorChurnByEntity(entries)
+
+	table := &Table{Columns: []string{"entity", "main-dev", "added", "total-added", "ownership"}}
+	for _, entity := range sortedKeys(churnByEntity) {
+		var mainAuthor string
+		var mainAdded, totalAdded int
+		for _, author := range sortedKeys(churnByEntity[entity]) {
+			added := churnByEntity[entity][author].added
+			totalAdded += added
+			if mainAuthor == "" || added > mainAdded {
+				mainAuthor, mainAdded = author, added
+			}
+		}
+
+		var ownership float64
+		if totalAdded > 0 {
+			ownership = round2(float64(mainAdded) / float64(totalAdded))
+		}
+		table.Rows = append(table.Rows, []interface{}{entity, mainAuthor, mainAdded, totalAdded, ownership})
+	}
+	return table
+}
+
+func fragmentation(entries []Entry, opts Options) *Table {
+	revs := entityRevisions(entries)
+	byAuthor := authorRevisions(entries)
+
+	fractal := make(map[string]float64)
+	for entity, total := range revs {
+		sum := 0.0
+		for _, n := range byAuthor[entity] {
+			share := float64(n) / float64(total)
+			sum += share * share
+		}
+		fractal[entity] = round2(1 - sum)
+	}
+
+	entities := sortedKeys(revs)
+	sort.SliceStable(entities, func(i, j int) bool {
+		return fractal[entities[i]] > fractal[entities[j]]
+	})
+
+	table := &Table{Columns: []string{"entity", "fractal-value", "total-revs"}}
+	for _, entity := range entities {
+		table.Rows = append(table.Rows, []interface{}{entity, fractal[entity], revs[entity]})
+	}
+	return table
+}
+
+func age(entries []Entry, opts Options) *Table {
+	latest := make(map[string]int)
+	for _, e := range entries {
+		months := monthsBetween(e.Date, opts.Now)
+		if current, ok := latest[e.Entity]; !ok || months < current {
+			latest[e.Entity] = months
+		}
+	}
+
+	entities := sortedKeys(latest)
+	sort.SliceStable(entities, func(i, j int) bool {
+		return latest[entities[i]] < latest[entities[j]]
+	})
+
+	table := &Table{Columns: []string{"entity", "age-months"}}
+	for _, entity := range entities {
+		table.Rows = append(table.Rows, []interface{}{entity, latest[entity]})
+	}
+	return table
+}
+
+// monthsBetween counts the whole calendar months from one time to another
+func monthsBetween(from, to time.Time) int {
+	months := (to.Year()-from.Year())*12 + int(to.Month()-from.Month())
+	if to.Day() < from.Day() {
+		months--
+	}
+	if months < 0 {
+		return 0
+	}
+	return months
+}
+
+func absChurn(entries []Entry, opts Options) *Table {
+	type day struct {
+		churn
+		commits map[string]bool
+	}
+	days := make(map[string]*day)
+	for _, e := range entries {
+		key := e.Date.Format("2006-01-02")
+		d := days[key]
+		if d == nil {
+			d = &day{commits: make(map[string]bool)}
+			days[key] = d
+		}
+		d.added += e.Added
+		d.deleted += e.Deleted
+		d.commits[e.Revision] = true
+	}
+
+	table := &Table{Columns: []string{"date", "added", "deleted", "commits"}}
+	for _, key := range sortedKeys(days) {
+		d := days[key]
+		table.Rows = append(table.Rows, []interface{}{key, d.added, d.deleted, len(d.commits)})
+	}
+	return table
+}
+
+func authorChurn(entries []Entry, opts Options) *Table {
+	type author struct {
+		churn
+		commits map[string]bool
+	}
+	authors := make(map[string]*author)
+	for _, e := range entries {
+		a := authors[e.Author]
+		if a == nil {
+			a = &author{commits: make(map[string]bool)}
+			authors[e.Author] = a
+		}
+		a.added += e.Added
+		a.deleted += e.Deleted
+		a.commits[e.Revision] = true
+	}
+
+	table := &Table{Columns: []string{"author", "added", "deleted", "commits"}}
+	for _, name := range sortedKeys(authors) {
+		a := authors[name]
+		table.Rows = append(table.Rows, []interface{}{name, a.added, a.deleted, len(a.commits)})
+	}
+	return table
+}
+
+func entityEffort(entries []Entry, opts Options) *Table {
+	revs := entityRevisions(entries)
+	byAuthor := authorRevisions(entries)
+
+	table := &Table{Columns: []string{"entity", "author", "author-revs", "total-revs"}}
+	for _, entity := range sortedKeys(byAuthor) {
+		authors := sortedKeys(byAuthor[entity])
+		sort.SliceStable(authors, func(i, j int) bool {
+			return byAuthor[entity][authors[i]] > byAuthor[entity][authors[j]]
+		})
+		for _, author := range authors {
+			table.Rows = append(table.Rows, []interface{}{entity, author, byAuthor[entity][author], revs[entity]})
+		}
+	}
+	return table
+}
@@ -0,0 +1,220 @@
+// Package maat implements the code-maat change analyses on top of logs in the
+// git2 format produced by
+//
+//	git log --all --numstat --date=short --pretty=format:'--%h--%ad--%aN' --no-renames
+package maat
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a single file change within a commit
+type Entry struct {
+	Revision string
+	Date     time.Time
+	Author   string
+	Entity   string
+	Added    int
+	Deleted  int
+}
+
+// Options tunes the analyses. The zero value is replaced by the code-maat defaults.
+type Options struct {
+	MinRevs          int
+	MinSharedRevs    int
+	MinCoupling      int
+	MaxCoupling      int
+	MaxChangesetSize int
+	// Now is the reference time for the age analysis
+	Now time.Time
+}
+
+// DefaultOptions returns the option values code-maat uses when none are given
+func DefaultOptions() Options {
+	return Options{
+		MinRevs:          5,
+		MinSharedRevs:    5,
+		MinCoupling:      30,
+		MaxCoupling:      100,
+		MaxChangesetSize: 30,
+	}
+}
+
+func (o Options) withDefaults() Options {
+	defaults := DefaultOptions()
+	if o.MinRevs == 0 {
+		o.MinRevs = defaults.MinRevs
+	}
+	if o.MinSharedRevs == 0 {
+		o.MinSharedRevs = defaults.MinSharedRevs
+	}
+	if o.MinCoupling == 0 {
+		o.MinCoupling = defaults.MinCoupling
+	}
+	if o.MaxCoupling == 0 {
+		o.MaxCoupling = defaults.MaxCoupling
+	}
+	if o.MaxChangesetSize == 0 {
+		o.MaxChangesetSize = defaults.MaxChangesetSize
+	}
+	if o.Now.IsZero() {
+		o.Now = time.Now()
+	}
+	return o
+}
+
+// Table is the tabular result of an analysis. Cells hold string, int or
+// float64 values so they can be rendered as CSV or typed JSON.
+type Table struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// WriteCSV writes the table in the same CSV layout code-maat produces
+func (t *Table) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(t.Columns); err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = FormatCell(cell)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// CSV returns the table rendered as CSV
+func (t *Table) CSV() string {
+	var sb strings.Builder
+	t.WriteCSV(&sb)
+	return sb.String()
+}
+
+// FormatCell renders a cell of a table as text, writing floats without
+// trailing zeros
+func FormatCell(cell interface{}) string {
+	switch v := cell.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type analysis func(entries []Entry, opts Options) *Table
+
+var analyses = map[string]analysis{
+	"summary":          summary,
+	"revisions":        revisions,
+	"authors":          authors,
+	"coupling":         coupling,
+	"soc":              sumOfCoupling,
+	"entity-ownership": entityOwnership,
+	"main-dev":         mainDev,
+	"fragmentation":    fragmentation,
+	"age":              age,
+	"abs-churn":        absChurn,
+	"author-churn":     authorChurn,
+	"entity-effort":    entityEffort,
+}
+
+// Analyses returns the names of all supported analyses
+func Analyses() []string {
+	var names []string
+	for name := range analyses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsValid reports whether name is a supported analysis
+func IsValid(name string) bool {
+	_, ok := analyses[name]
+	return ok
+}
+
+// Run executes the named analysis over the log entries
+func Run(name string, entries []Entry, opts Options) (*Table, error) {
+	fn, ok := analyses[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported analysis: %s. Must be one of: %s", name, strings.Join(Analyses(), ", "))
+	}
+	return fn(entries, opts.withDefaults()), nil
+}
+
+// Parse reads a git2 formatted log
+func Parse(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var revision, author string
+	var date time.Time
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "--") {
+			// Commit header: --<revision>--<date>--<author>
+			parts := strings.SplitN(line[2:], "--", 3)
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("line %d: invalid commit header: %q", lineNumber, line)
+			}
+			parsed, err := time.Parse("2006-01-02", parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid commit date %q: %v", lineNumber, parts[1], err)
+			}
+			revision, date, author = parts[0], parsed, parts[2]
+			continue
+		}
+
+		if revision == "" {
+			return nil, fmt.Errorf("line %d: file change before any commit header", lineNumber)
+		}
+
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: invalid numstat line: %q", lineNumber, line)
+		}
+
+		// Binary files are reported as "-" and count as no line changes
+		added, _ := strconv.Atoi(fields[0])
+		deleted, _ := strconv.Atoi(fields[1])
+		entries = append(entries, Entry{
+			Revision: revision,
+			Date:     date,
+			Author:   author,
+			Entity:   fields[2],
+			Added:    added,
+			Deleted:  deleted,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read log: %v", err)
+	}
+
+	return entries, nil
+}
+
+// round2 rounds to two decimals as code-maat does for ratios
+func round2(v float64) float64 {
+	return float64(int64(v*100+0.5)) / 100
+}
@@ -0,0 +1,218 @@
+package maat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testLog = `--a1--2024-01-10--alice
+10	2	src/a.go
+5	0	src/b.go
+
+--a2--2024-02-15--bob
+3	1	src/a.go
+-	-	assets/logo.png
+
+--a3--2024-03-01--alice
+1	1	src/a.go
+2	2	src/b.go
+`
+
+func parseTestLog(t *testing.T) []Entry {
+	t.Helper()
+	entries, err := Parse(strings.NewReader(testLog))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return entries
+}
+
+func TestParse(t *testing.T) {
+	entries := parseTestLog(t)
+	if len(entries) != 6 {
+		t.Fatalf("Expected 6 entries, got %d", len(entries))
+	}
+
+	want := Entry{
+		Revision: "a2",
+		Date:     time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC),
+		Author:   "bob",
+		Entity:   "assets/logo.png",
+	}
+	if entries[3] != want {
+		t.Errorf("Expected binary entry %+v, got %+v", want, entries[3])
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []string{
+		"1\t2\tsrc/a.go\n",
+		"--a1--not-a-date--alice\n",
+		"--a1--2024-01-10--alice\nnot numstat\n",
+	}
+
+	for _, input := range tests {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("Expected error parsing %q", input)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	entries := parseTestLog(t)
+	now := time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		analysis string
+		opts     Options
+		columns  []string
+		rows     [][]interface{}
+	}{
+		{
+			analysis: "summary",
+			columns:  []string{"statistic", "value"},
+			rows: [][]interface{}{
+				{"number-of-commits", 3},
+				{"number-of-entities", 3},
+				{"number-of-entities-changed", 6},
+				{"number-of-authors", 2},
+			},
+		},
+		{
+			analysis: "revisions",
+			columns:  []string{"entity", "n-revs"},
+			rows: [][]interface{}{
+				{"src/a.go", 3},
+				{"src/b.go", 2},
+				{"assets/logo.png", 1},
+			},
+		},
+		{
+			analysis: "authors",
+			columns:  []string{"entity", "n-authors", "n-revs"},
+			rows: [][]interface{}{
+				{"src/a.go", 2, 3},
+				{"src/b.go", 1, 2},
+				{"assets/logo.png", 1, 1},
+			},
+		},
+		{
+			analysis: "coupling",
+			opts:     Options{MinRevs: 1, MinSharedRevs: 1},
+			columns:  []string{"entity", "coupled", "degree", "average-revs"},
+			rows: [][]interface{}{
+				{"src/a.go", "src/b.go", 66, 3},
+				{"assets/logo.png", "src/a.go", 50, 2},
+			},
+		},
+		{
+			analysis: "soc",
+			columns:  []string{"entity", "soc"},
+			rows: [][]interface{}{
+				{"src/a.go", 3},
+				{"src/b.go", 2},
+				{"assets/logo.png", 1},
+			},
+		},
+		{
+			analysis: "entity-ownership",
+			columns:  []string{"entity", "author", "author-added", "author-deleted"},
+			rows: [][]interface{}{
+				{"assets/logo.png", "bob", 0, 0},
+				{"src/a.go", "alice", 11, 3},
+				{"src/a.go", "bob", 3, 1},
+				{"src/b.go", "alice", 7, 2},
+			},
+		},
+		{
+			analysis: "main-dev",
+			columns:  []string{"entity", "main-dev", "added", "total-added", "ownership"},
+			rows: [][]interface{}{
+				{"assets/logo.png", "bob", 0, 0, 0.0},
+				{"src/a.go", "alice", 11, 14, 0.79},
+				{"src/b.go", "alice", 7, 7, 1.0},
+			},
+		},
+		{
+			analysis: "fragmentation",
+			columns:  []string{"entity", "fractal-value", "total-revs"},
+			rows: [][]interface{}{
+				{"src/a.go", 0.44, 3},
+				{"assets/logo.png", 0.0, 1},
+				{"src/b.go", 0.0, 2},
+			},
+		},
+		{
+			analysis: "age",
+			opts:     Options{Now: now},
+			columns:  []string{"entity", "age-months"},
+			rows: [][]interface{}{
+				{"src/a.go", 3},
+				{"src/b.go", 3},
+				{"assets/logo.png", 4},
+			},
+		},
+		{
+			analysis: "abs-churn",
+			columns:  []string{"date", "added", "deleted", "commits"},
+			rows: [][]interface{}{
+				{"2024-01-10", 15, 2, 1},
+				{"2024-02-15", 3, 1, 1},
+				{"2024-03-01", 3, 3, 1},
+			},
+		},
+		{
+			analysis: "author-churn",
+			columns:  []string{"author", "added", "deleted", "commits"},
+			rows: [][]interface{}{
+				{"alice", 18, 5, 2},
+				{"bob", 3, 1, 1},
+			},
+		},
+		{
+			analysis: "entity-effort",
+			columns:  []string{"entity", "author", "author-revs", "total-revs"},
+			rows: [][]interface{}{
+				{"assets/logo.png", "bob", 1, 1},
+				{"src/a.go", "alice", 2, 3},
+				{"src/a.go", "bob", 1, 3},
+				{"src/b.go", "alice", 2, 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.analysis, func(t *testing.T) {
+			table, err := Run(tt.analysis, entries, tt.opts)
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if !reflect.DeepEqual(table.Columns, tt.columns) {
+				t.Errorf("Expected columns %v, got %v", tt.columns, table.Columns)
+			}
+			if !reflect.DeepEqual(table.Rows, tt.rows) {
+				t.Errorf("Expected rows %v, got %v", tt.rows, table.Rows)
+			}
+		})
+	}
+}
+
+func TestRun_UnknownAnalysis(t *testing.T) {
+	if _, err := Run("unknown", nil, Options{}); err == nil {
+		t.Error("Expected error for unknown analysis")
+	}
+}
+
+func TestTable_CSV(t *testing.T) {
+	table, err := Run("fragmentation", parseTestLog(t), Options{})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	want := "entity,fractal-value,total-revs\nsrc/a.go,0.44,3\nassets/logo.png,0,1\nsrc/b.go,0,2\n"
+	if got := table.CSV(); got != want {
+		t.Errorf("Expected CSV:\n%s\ngot:\n%s", want, got)
+	}
+}
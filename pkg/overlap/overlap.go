@@ -0,0 +1,125 @@
+// Package overlap cross-references the open pull requests of a repository to
+// find those likely to conflict, so merges can be sequenced before they do.
+package overlap
+
+import (
+	"sort"
+
+	"github.com/andrewweb/hackday/pkg/repo"
+)
+
+// Pair is two pull requests that change some of the same files
+type Pair struct {
+	A     repo.PullRequest
+	B     repo.PullRequest
+	Files []File
+	// Lines is the number of base lines both pull requests' hunks cover,
+	// over the files whose diffs are known for both
+	Lines int
+}
+
+// File is a file both pull requests of a Pair change
+type File struct {
+	Path string
+	// Compared reports whether both diffs were available, so the hunks could
+	// be compared
+	Compared bool
+	// Ranges are the base lines the hunks of both pull requests cover
+	Ranges []repo.LineRange
+}
+
+// Conflicting returns the files whose hunks overlap
+func (p Pair) Conflicting() []File {
+	var files []File
+	for _, f := range p.Files {
+		if len(f.Ranges) > 0 {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Find returns the pairs of pull requests that change the same files, most
+// overlapping first: by lines covered by both, then by files changed by both
+func Find(prs []repo.PullRequest) []Pair {
+	var pairs []Pair
+	for i := range prs {
+		for j := i + 1; j < len(prs); j++ {
+			if pair, ok := compare(prs[i], prs[j]); ok {
+				pairs = append(pairs, pair)
+			}
+		}
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].Lines != pairs[j].Lines {
+			return pairs[i].Lines > pairs[j].Lines
+		}
+		if len(pairs[i].Files) != len(pairs[j].Files) {
+			return len(pairs[i].Files) > len(pairs[j].Files)
+		}
+		if pairs[i].A.Number != pairs[j].A.Number {
+			return pairs[i].A.Number < pairs[j].A.Number
+		}
+		return pairs[i].B.Number < pairs[j].B.Number
+	})
+	return pairs
+}
+
+// compare finds the files two pull requests both change, ordered by path
+func compare(a, b repo.PullRequest) (Pair, bool) {
+	changed := make(map[string]bool, len(b.ChangedFiles))
+	for _, file := range b.ChangedFiles {
+		changed[file] = true
+	}
+
+	pair := Pair{A: a, B: b}
+	for _, path := range a.ChangedFiles {
+		if !changed[path] {
+			continue
+		}
+		file := File{Path: path}
+		hunksA, okA := a.Hunks[path]
+		hunksB, okB := b.Hunks[path]
+		if okA && okB {
+			file.Compared = true
+			file.Ranges = intersect(hunksA, hunksB)
+			for _, r := range file.Ranges {
+				pair.Lines += r.End - r.Start + 1
+			}
+		}
+		pair.Files = append(pair.Files, file)
+	}
+
+	sort.Slice(pair.Files, func(i, j int) bool {
+		return pair.Files[i].Path < pair.Files[j].Path
+	})
+	return pair, len(pair.Files) > 0
+}
+
+// intersect returns the lines covered by both sets of hunks, merged into
+// disjoint ranges
+func intersect(a, b []repo.LineRange) []repo.LineRange {
+	var result []repo.LineRange
+	for _, ra := range a {
+		for _, rb := range b {
+			if !ra.Overlaps(rb) {
+				continue
+			}
+			result = append(result, repo.LineRange{Start: max(ra.Start, rb.Start), End: min(ra.End, rb.End)})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Start < result[j].Start
+	})
+	var merged []repo.LineRange
+	for _, r := range result {
+		if n := len(merged); n > 0 && r.Start <= merged[n-1].End+1 {
+			merged[n-1].End = max(merged[n-1].End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
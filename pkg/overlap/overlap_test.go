@@ -0,0 +1,96 @@
+package overlap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/andrewweb/hackday/pkg/repo"
+)
+
+func TestFind(t *testing.T) {
+	prs := []repo.PullRequest{
+		{
+			Number:       1,
+			ChangedFiles: []string{"a.go", "b.go", "c.go"},
+			Hunks: map[string][]repo.LineRange{
+				"a.go": {{Start: 1, End: 10}, {Start: 40, End: 50}},
+				"b.go": {{Start: 5, End: 5}},
+			},
+		},
+		{
+			Number:       2,
+			ChangedFiles: []string{"a.go", "b.go"},
+			Hunks: map[string][]repo.LineRange{
+				"a.go": {{Start: 8, End: 20}, {Start: 45, End: 60}},
+				"b.go": {{Start: 100, End: 110}},
+			},
+		},
+		{
+			// No diffs, so only the shared file is known
+			Number:       3,
+			ChangedFiles: []string{"c.go"},
+		},
+		{
+			Number:       4,
+			ChangedFiles: []string{"d.go"},
+		},
+	}
+
+	pairs := Find(prs)
+	if len(pairs) != 2 {
+		t.Fatalf("Expected 2 pairs, got %d", len(pairs))
+	}
+
+	first := pairs[0]
+	if first.A.Number != 1 || first.B.Number != 2 {
+		t.Fatalf("Expected #1 and #2 first, got #%d and #%d", first.A.Number, first.B.Number)
+	}
+	// Lines 8-10 and 45-50 are covered by both
+	if first.Lines != 9 {
+		t.Errorf("Expected 9 overlapping lines, got %d", first.Lines)
+	}
+	expected := []File{
+		{Path: "a.go", Compared: true, Ranges: []repo.LineRange{{Start: 8, End: 10}, {Start: 45, End: 50}}},
+		{Path: "b.go", Compared: true},
+	}
+	if !reflect.DeepEqual(first.Files, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, first.Files)
+	}
+	if conflicting := first.Conflicting(); len(conflicting) != 1 || conflicting[0].Path != "a.go" {
+		t.Errorf("Expected a.go to conflict, got %+v", conflicting)
+	}
+
+	second := pairs[1]
+	if second.A.Number != 1 || second.B.Number != 3 || second.Lines != 0 {
+		t.Errorf("Expected #1 and #3 without overlapping lines, got %+v", second)
+	}
+	if len(second.Files) != 1 || second.Files[0].Compared {
+		t.Errorf("Expected c.go to be shared but not compared, got %+v", second.Files)
+	}
+}
+
+// lines returns the range of lines start to end
+func lines(start, end int) repo.LineRange {
+	return repo.LineRange{Start: start, End: end}
+}
+
+func TestIntersect(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     []repo.LineRange
+		expected []repo.LineRange
+	}{
+		{"disjoint", []repo.LineRange{lines(1, 5)}, []repo.LineRange{lines(6, 9)}, nil},
+		{"contained", []repo.LineRange{lines(1, 20)}, []repo.LineRange{lines(5, 8)}, []repo.LineRange{lines(5, 8)}},
+		{"adjacent results merge", []repo.LineRange{lines(1, 5), lines(6, 10)}, []repo.LineRange{lines(3, 8)}, []repo.LineRange{lines(3, 8)}},
+		{"insertion point", []repo.LineRange{lines(7, 7)}, []repo.LineRange{lines(7, 7)}, []repo.LineRange{lines(7, 7)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := intersect(tt.a, tt.b); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
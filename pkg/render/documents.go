@@ -0,0 +1,379 @@
+package render
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrewweb/hackday/pkg/cache"
+	"github.com/andrewweb/hackday/pkg/maat"
+	"github.com/andrewweb/hackday/pkg/overlap"
+	"github.com/andrewweb/hackday/pkg/repo"
+)
+
+// Document kinds
+const (
+	KindRepositories = "repositories"
+	KindPullRequests = "pull-requests"
+	KindBlame        = "blame"
+	KindAnalysis     = "analysis"
+	KindCacheStats   = "cache-stats"
+	KindCachePrune   = "cache-prune"
+	KindOverlap      = "overlap"
+)
+
+// Repository is the schema of a repository
+type Repository struct {
+	Name     string `json:"name"`
+	FullName string `json:"fullName"`
+	URL      string `json:"url"`
+	Provider string `json:"provider"`
+}
+
+// PullRequest is the schema of a pull request
+type PullRequest struct {
+	Number       int      `json:"number"`
+	Title        string   `json:"title"`
+	State        string   `json:"state"`
+	URL          string   `json:"url"`
+	Provider     string   `json:"provider"`
+	BaseRef      string   `json:"baseRef"`
+	HeadRef      string   `json:"headRef"`
+	HeadSHA      string   `json:"headSha,omitempty"`
+	ChangedFiles []string `json:"changedFiles"`
+}
+
+// AuthorLines is the number of lines an author owns
+type AuthorLines struct {
+	Author string `json:"author"`
+	Lines  int    `json:"lines"`
+}
+
+// BlameRange is a block of lines last changed by one commit
+type BlameRange struct {
+	StartLine int        `json:"startLine"`
+	EndLine   int        `json:"endLine"`
+	Author    string     `json:"author"`
+	Commit    string     `json:"commit"`
+	Date      *time.Time `json:"date,omitempty"`
+}
+
+// FileBlame is the blame of one file
+type FileBlame struct {
+	Path    string        `json:"path"`
+	Authors []AuthorLines `json:"authors"`
+	Ranges  []BlameRange  `json:"ranges"`
+}
+
+// Blame is the schema of a blame analysis
+type Blame struct {
+	Repository  string        `json:"repository"`
+	PullRequest *PullRequest  `json:"pullRequest,omitempty"`
+	Ref         string        `json:"ref"`
+	Authors     []AuthorLines `json:"authors"`
+	Files       []FileBlame   `json:"files"`
+	// Skipped lists the files missing at Ref, which were not blamed
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// Analysis is the schema of a code-maat analysis. Rows are objects keyed by
+// column, and Columns gives the column order.
+type Analysis struct {
+	Repository string                   `json:"repository"`
+	Analysis   string                   `json:"analysis"`
+	Summary    map[string]interface{}   `json:"summary"`
+	Columns    []string                 `json:"columns"`
+	Rows       []map[string]interface{} `json:"rows"`
+}
+
+// CacheStats is the schema of the cache statistics
+type CacheStats struct {
+	Dir     string     `json:"dir"`
+	Entries int        `json:"entries"`
+	Bytes   int64      `json:"bytes"`
+	Oldest  *time.Time `json:"oldest,omitempty"`
+	Newest  *time.Time `json:"newest,omitempty"`
+}
+
+// CachePrune is the schema of a cache prune
+type CachePrune struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// LineRange is a block of lines, End inclusive
+type LineRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// OverlapFile is a file two pull requests both change. Ranges are the base
+// lines both diffs touch, and Compared is false when a diff was unavailable.
+type OverlapFile struct {
+	Path     string      `json:"path"`
+	Compared bool        `json:"compared"`
+	Ranges   []LineRange `json:"ranges"`
+}
+
+// OverlapPair is two pull requests that change the same files
+type OverlapPair struct {
+	PullRequests     [2]OverlapPullRequest `json:"pullRequests"`
+	SharedFiles      int                   `json:"sharedFiles"`
+	ConflictingFiles int                   `json:"conflictingFiles"`
+	OverlappingLines int                   `json:"overlappingLines"`
+	Files            []OverlapFile         `json:"files"`
+}
+
+// OverlapPullRequest identifies a pull request of an overlapping pair
+type OverlapPullRequest struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	HeadRef string `json:"headRef"`
+	URL     string `json:"url"`
+}
+
+// Overlap is the schema of an overlap analysis, most overlapping pair first
+type Overlap struct {
+	Repository   string        `json:"repository"`
+	PullRequests int           `json:"pullRequests"`
+	Pairs        []OverlapPair `json:"pairs"`
+}
+
+// RepositoriesDocument describes a list of repositories
+func RepositoriesDocument(repos []repo.Repository) *Document {
+	data := make([]Repository, 0, len(repos))
+	table := DataTable{Title: "Repositories", Columns: []string{"full_name", "provider", "url"}}
+	for _, r := range repos {
+		data = append(data, Repository{Name: r.Name, FullName: r.FullName, URL: r.URL, Provider: r.Provider})
+		table.Rows = append(table.Rows, []string{r.FullName, r.Provider, r.URL})
+	}
+	return &Document{Kind: KindRepositories, Data: data, Tables: []DataTable{table}}
+}
+
+// PullRequestsDocument describes a list of pull requests
+func PullRequestsDocument(prs []repo.PullRequest) *Document {
+	data := make([]PullRequest, 0, len(prs))
+	table := DataTable{Title: "Pull Requests", Columns: []string{"number", "title", "base", "head", "changed_files", "url"}}
+	for _, pr := range prs {
+		data = append(data, pullRequest(&pr))
+		table.Rows = append(table.Rows, []string{
+			strconv.Itoa(pr.Number), pr.Title, pr.BaseRef, pr.HeadRef, strconv.Itoa(len(pr.ChangedFiles)), pr.URL,
+		})
+	}
+	return &Document{Kind: KindPullRequests, Data: data, Tables: []DataTable{table}}
+}
+
+func pullRequest(pr *repo.PullRequest) PullRequest {
+	files := pr.ChangedFiles
+	if files == nil {
+		files = []string{}
+	}
+	return PullRequest{
+		Number:       pr.Number,
+		Title:        pr.Title,
+		State:        pr.State,
+		URL:          pr.URL,
+		Provider:     pr.Provider,
+		BaseRef:      pr.BaseRef,
+		HeadRef:      pr.HeadRef,
+		HeadSHA:      pr.HeadSHA,
+		ChangedFiles: files,
+	}
+}
+
+// BlameDocument describes the blame of a pull request's files. pr may be nil.
+func BlameDocument(repository string, pr *repo.PullRequest, result *repo.BlameResult) *Document {
+	data := Blame{
+		Repository: repository,
+		Ref:        result.Ref,
+		Authors:    authorLines(result.Authors),
+		Files:      []FileBlame{},
+		Skipped:    result.Skipped,
+	}
+	if pr != nil {
+		converted := pullRequest(pr)
+		data.PullRequest = &converted
+	}
+
+	authors := DataTable{Title: "Authors", Columns: []string{"author", "lines"}}
+	for _, author := range data.Authors {
+		authors.Rows = append(authors.Rows, []string{author.Author, strconv.Itoa(author.Lines)})
+	}
+	ranges := DataTable{Title: "Lines", Columns: []string{"file", "start_line", "end_line", "author", "commit"}}
+
+	for _, file := range result.Files {
+		fileBlame := FileBlame{Path: file.Path, Authors: []AuthorLines{}, Ranges: []BlameRange{}}
+		for author, lines := range file.Authors {
+			fileBlame.Authors = append(fileBlame.Authors, AuthorLines{author, lines})
+		}
+		sortAuthorLines(fileBlame.Authors)
+
+		for _, rng := range file.Ranges {
+			converted := BlameRange{
+				StartLine: rng.StartLine,
+				EndLine:   rng.EndLine,
+				Author:    rng.Author,
+				Commit:    rng.Commit,
+			}
+			if !rng.Date.IsZero() {
+				date := rng.Date.UTC()
+				converted.Date = &date
+			}
+			fileBlame.Ranges = append(fileBlame.Ranges, converted)
+			ranges.Rows = append(ranges.Rows, []string{
+				file.Path, strconv.Itoa(rng.StartLine), strconv.Itoa(rng.EndLine), rng.Author, shortSHA(rng.Commit),
+			})
+		}
+		data.Files = append(data.Files, fileBlame)
+	}
+
+	return &Document{Kind: KindBlame, Data: data, Tables: []DataTable{authors, ranges}}
+}
+
+// AnalysisDocument describes the result of a code-maat analysis along with
+// the summary of the log it ran on. summary may be nil.
+func AnalysisDocument(repository, name string, summary, result *maat.Table) *Document {
+	data := Analysis{
+		Repository: repository,
+		Analysis:   name,
+		Summary:    map[string]interface{}{},
+		Columns:    result.Columns,
+		Rows:       []map[string]interface{}{},
+	}
+
+	var tables []DataTable
+	if summary != nil {
+		// The summary is a statistic,value table
+		for _, row := range summary.Rows {
+			if len(row) == 2 {
+				data.Summary[maat.FormatCell(row[0])] = row[1]
+			}
+		}
+		tables = append(tables, dataTable("Summary", summary))
+	}
+
+	for _, row := range result.Rows {
+		object := make(map[string]interface{}, len(row))
+		for i, cell := range row {
+			if i < len(result.Columns) {
+				object[result.Columns[i]] = cell
+			}
+		}
+		data.Rows = append(data.Rows, object)
+	}
+	tables = append(tables, dataTable(name, result))
+
+	return &Document{Kind: KindAnalysis, Data: data, Tables: tables}
+}
+
+// CacheStatsDocument describes the contents of the cache
+func CacheStatsDocument(stats cache.Stats) *Document {
+	data := CacheStats{Dir: stats.Dir, Entries: stats.Entries, Bytes: stats.Bytes}
+	table := DataTable{Title: "Cache", Columns: []string{"statistic", "value"}}
+	table.Rows = append(table.Rows,
+		[]string{"dir", stats.Dir},
+		[]string{"entries", strconv.Itoa(stats.Entries)},
+		[]string{"bytes", strconv.FormatInt(stats.Bytes, 10)})
+	if stats.Entries > 0 {
+		oldest, newest := stats.Oldest.UTC(), stats.Newest.UTC()
+		data.Oldest, data.Newest = &oldest, &newest
+		table.Rows = append(table.Rows,
+			[]string{"oldest", oldest.Format(time.RFC3339)},
+			[]string{"newest", newest.Format(time.RFC3339)})
+	}
+	return &Document{Kind: KindCacheStats, Data: data, Tables: []DataTable{table}}
+}
+
+// CachePruneDocument describes the entries removed from the cache
+func CachePruneDocument(result cache.PruneResult) *Document {
+	table := DataTable{Title: "Removed", Columns: []string{"entries", "bytes"}}
+	table.Rows = [][]string{{strconv.Itoa(result.Entries), strconv.FormatInt(result.Bytes, 10)}}
+	return &Document{
+		Kind:   KindCachePrune,
+		Data:   CachePrune{Entries: result.Entries, Bytes: result.Bytes},
+		Tables: []DataTable{table},
+	}
+}
+
+// OverlapDocument describes the pull requests of a repository that change the
+// same files, out of the open pull requests examined
+func OverlapDocument(repository string, examined int, pairs []overlap.Pair) *Document {
+	data := Overlap{Repository: repository, PullRequests: examined, Pairs: []OverlapPair{}}
+	table := DataTable{
+		Title:   "Overlapping Pull Requests",
+		Columns: []string{"pr_a", "pr_b", "shared_files", "conflicting_files", "overlapping_lines", "files"},
+	}
+
+	for _, pair := range pairs {
+		converted := OverlapPair{
+			PullRequests: [2]OverlapPullRequest{
+				{Number: pair.A.Number, Title: pair.A.Title, HeadRef: pair.A.HeadRef, URL: pair.A.URL},
+				{Number: pair.B.Number, Title: pair.B.Title, HeadRef: pair.B.HeadRef, URL: pair.B.URL},
+			},
+			SharedFiles:      len(pair.Files),
+			ConflictingFiles: len(pair.Conflicting()),
+			OverlappingLines: pair.Lines,
+			Files:            []OverlapFile{},
+		}
+		var paths []string
+		for _, file := range pair.Files {
+			ranges := []LineRange{}
+			for _, r := range file.Ranges {
+				ranges = append(ranges, LineRange{Start: r.Start, End: r.End})
+			}
+			converted.Files = append(converted.Files, OverlapFile{Path: file.Path, Compared: file.Compared, Ranges: ranges})
+			paths = append(paths, file.Path)
+		}
+		data.Pairs = append(data.Pairs, converted)
+
+		table.Rows = append(table.Rows, []string{
+			"#" + strconv.Itoa(pair.A.Number),
+			"#" + strconv.Itoa(pair.B.Number),
+			strconv.Itoa(converted.SharedFiles),
+			strconv.Itoa(converted.ConflictingFiles),
+			strconv.Itoa(pair.Lines),
+			strings.Join(paths, ", "),
+		})
+	}
+
+	return &Document{Kind: KindOverlap, Data: data, Tables: []DataTable{table}}
+}
+
+// authorLines sorts blame totals by lines owned, most first
+func authorLines(authors map[string]repo.BlameInfo) []AuthorLines {
+	result := []AuthorLines{}
+	for _, info := range authors {
+		result = append(result, AuthorLines{info.User, info.Lines})
+	}
+	sortAuthorLines(result)
+	return result
+}
+
+func sortAuthorLines(authors []AuthorLines) {
+	sort.Slice(authors, func(i, j int) bool {
+		if authors[i].Lines != authors[j].Lines {
+			return authors[i].Lines > authors[j].Lines
+		}
+		return authors[i].Author < authors[j].Author
+	})
+}
+
+func dataTable(title string, table *maat.Table) DataTable {
+	result := DataTable{Title: title, Columns: table.Columns}
+	for _, row := range table.Rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = maat.FormatCell(cell)
+		}
+		result.Rows = append(result.Rows, cells)
+	}
+	return result
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
@@ -0,0 +1,192 @@
+package render
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// SchemaVersion is the version of the documents' JSON and YAML schema. It is
+// bumped whenever a field is removed or changes meaning; new fields may be
+// added without a bump.
+const SchemaVersion = 1
+
+// Format is an output format for documents
+type Format string
+
+const (
+	Table    Format = "table"
+	JSON     Format = "json"
+	CSV      Format = "csv"
+	Markdown Format = "markdown"
+	YAML     Format = "yaml"
+)
+
+// Formats returns the names of the supported formats
+func Formats() []string {
+	return []string{string(Table), string(JSON), string(CSV), string(Markdown), string(YAML)}
+}
+
+// ParseFormat parses a format name, accepting md and yml as aliases
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "", "table":
+		return Table, nil
+	case "json":
+		return JSON, nil
+	case "csv":
+		return CSV, nil
+	case "markdown", "md":
+		return Markdown, nil
+	case "yaml", "yml":
+		return YAML, nil
+	}
+	return "", fmt.Errorf("invalid output format %q. Must be one of: %s", name, strings.Join(Formats(), ", "))
+}
+
+// ContentType returns the MIME type of a format, for HTTP responses
+func (f Format) ContentType() string {
+	switch f {
+	case JSON:
+		return "application/json"
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case Markdown:
+		return "text/markdown; charset=utf-8"
+	case YAML:
+		return "application/yaml"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Document is the result of a command. Data is the structured form written
+// as JSON or YAML; Tables are the tabular views written as CSV, Markdown and
+// plain text tables.
+type Document struct {
+	Kind   string
+	Data   interface{}
+	Tables []DataTable
+}
+
+// DataTable is a titled table of text cells
+type DataTable struct {
+	Title   string
+	Columns []string
+	Rows    [][]string
+}
+
+// envelope is the top level of a JSON or YAML document
+type envelope struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	Kind          string      `json:"kind"`
+	Data          interface{} `json:"data"`
+}
+
+// Write renders doc to w in the given format
+func Write(w io.Writer, format Format, doc *Document) error {
+	switch format {
+	case JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(envelope{SchemaVersion, doc.Kind, doc.Data})
+	case YAML:
+		return writeYAML(w, envelope{SchemaVersion, doc.Kind, doc.Data})
+	case CSV:
+		return writeCSV(w, doc.Tables)
+	case Markdown:
+		return writeMarkdown(w, doc.Tables)
+	case Table:
+		return writeTables(w, doc.Tables)
+	}
+	return fmt.Errorf("unsupported output format: %s", format)
+}
+
+// writeCSV writes each table with a header row, separating tables with a blank line
+func writeCSV(w io.Writer, tables []DataTable) error {
+	for i, table := range tables {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		writer := csv.NewWriter(w)
+		writer.Write(table.Columns)
+		writer.WriteAll(table.Rows)
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeMarkdown(w io.Writer, tables []DataTable) error {
+	var sb strings.Builder
+	for i, table := range tables {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		if table.Title != "" {
+			sb.WriteString("### " + table.Title + "\n\n")
+		}
+		if len(table.Rows) == 0 {
+			sb.WriteString("_None_\n")
+			continue
+		}
+
+		sb.WriteString(markdownRow(table.Columns))
+		separators := make([]string, len(table.Columns))
+		for j := range separators {
+			separators[j] = "---"
+		}
+		sb.WriteString(markdownRow(separators))
+		for _, row := range table.Rows {
+			sb.WriteString(markdownRow(row))
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func markdownRow(cells []string) string {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		cell = strings.ReplaceAll(cell, "|", `\|`)
+		escaped[i] = strings.ReplaceAll(cell, "\n", " ")
+	}
+	return "| " + strings.Join(escaped, " | ") + " |\n"
+}
+
+// writeTables writes aligned plain text tables for reading in a terminal
+func writeTables(w io.Writer, tables []DataTable) error {
+	for i, table := range tables {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if table.Title != "" {
+			fmt.Fprintf(w, "%s:\n", table.Title)
+		}
+		if len(table.Rows) == 0 {
+			fmt.Fprintln(w, "(none)")
+			continue
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		header := make([]string, len(table.Columns))
+		for j, column := range table.Columns {
+			header[j] = strings.ToUpper(column)
+		}
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, row := range table.Rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
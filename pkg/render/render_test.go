@@ -0,0 +1,199 @@
+package render
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/andrewweb/hackday/pkg/maat"
+	"github.com/andrewweb/hackday/pkg/overlap"
+	"github.com/andrewweb/hackday/pkg/repo"
+)
+
+func testDocument() *Document {
+	summary := &maat.Table{Columns: []string{"statistic", "value"}, Rows: [][]interface{}{{"number-of-commits", 2}}}
+	result := &maat.Table{
+		Columns: []string{"entity", "n-revs"},
+		Rows:    [][]interface{}{{"a|b.go", 2}, {"yes", 1}},
+	}
+	return AnalysisDocument("owner/repo", "revisions", summary, result)
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Format
+		wantErr bool
+	}{
+		{name: "", want: Table},
+		{name: "JSON", want: JSON},
+		{name: "md", want: Markdown},
+		{name: "yml", want: YAML},
+		{name: "xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFormat(%q) error = %v; want error %v", tt.name, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("ParseFormat(%q) = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWrite_JSON(t *testing.T) {
+	var sb strings.Builder
+	if err := Write(&sb, JSON, testDocument()); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	var doc struct {
+		SchemaVersion int      `json:"schemaVersion"`
+		Kind          string   `json:"kind"`
+		Data          Analysis `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(sb.String()), &doc); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, sb.String())
+	}
+	if doc.SchemaVersion != SchemaVersion || doc.Kind != KindAnalysis {
+		t.Errorf("Unexpected envelope: version %d, kind %q", doc.SchemaVersion, doc.Kind)
+	}
+	if len(doc.Data.Rows) != 2 || doc.Data.Rows[0]["entity"] != "a|b.go" {
+		t.Errorf("Unexpected rows: %v", doc.Data.Rows)
+	}
+	if doc.Data.Summary["number-of-commits"] != float64(2) {
+		t.Errorf("Unexpected summary: %v", doc.Data.Summary)
+	}
+}
+
+func TestWrite_YAML(t *testing.T) {
+	var sb strings.Builder
+	if err := Write(&sb, YAML, testDocument()); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Keys keep their schema order, and strings YAML would read as
+	// booleans are quoted
+	want := `schemaVersion: 1
+kind: analysis
+data:
+  repository: owner/repo
+  analysis: revisions
+  summary:
+    number-of-commits: 2
+  columns:
+    - entity
+    - n-revs
+  rows:
+    - entity: "a|b.go"
+      n-revs: 2
+    - entity: "yes"
+      n-revs: 1
+`
+	if sb.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, sb.String())
+	}
+}
+
+func TestYAMLString(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"main.go", "main.go"},
+		{"v1.2.3", "v1.2.3"},
+		{"12", `"12"`},
+		{"1_000", `"1_000"`},
+		{"3.14", `"3.14"`},
+		{"1e3", `"1e3"`},
+		{".5", `".5"`},
+		{"0o17", `"0o17"`},
+		{"0x1F", `"0x1F"`},
+		{"0b101", `"0b101"`},
+		{"1:30", `"1:30"`},
+		{".inf", `".inf"`},
+		{"+.INF", `"+.INF"`},
+		{".nan", `".nan"`},
+		{"2024-01-01", `"2024-01-01"`},
+		{"null", `"null"`},
+		{"", `""`},
+	}
+
+	for _, tt := range tests {
+		if got := yamlString(tt.value); got != tt.expected {
+			t.Errorf("Expected %s for %q, got %s", tt.expected, tt.value, got)
+		}
+	}
+}
+
+func TestWrite_Tables(t *testing.T) {
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{CSV, "statistic,value\nnumber-of-commits,2\n\nentity,n-revs\na|b.go,2\nyes,1\n"},
+		{Markdown, "### Summary\n\n| statistic | value |\n| --- | --- |\n| number-of-commits | 2 |\n\n" +
+			"### revisions\n\n| entity | n-revs |\n| --- | --- |\n| a\\|b.go | 2 |\n| yes | 1 |\n"},
+	}
+
+	for _, tt := range tests {
+		var sb strings.Builder
+		if err := Write(&sb, tt.format, testDocument()); err != nil {
+			t.Fatalf("Write(%s) failed: %v", tt.format, err)
+		}
+		if sb.String() != tt.want {
+			t.Errorf("Write(%s): expected:\n%s\ngot:\n%s", tt.format, tt.want, sb.String())
+		}
+	}
+}
+
+func TestBlameDocument(t *testing.T) {
+	result := &repo.BlameResult{
+		Ref: "abc",
+		Authors: map[string]repo.BlameInfo{
+			"alice": {User: "alice", Lines: 1},
+			"bob":   {User: "bob", Lines: 3},
+		},
+		Files: []repo.FileBlame{{
+			Path:    "a.go",
+			Ranges:  []repo.BlameRange{{StartLine: 1, EndLine: 3, Author: "bob", Commit: "0123456789"}},
+			Authors: map[string]int{"bob": 3},
+		}},
+	}
+
+	doc := BlameDocument("owner/repo", nil, result)
+	data := doc.Data.(Blame)
+	if len(data.Authors) != 2 || data.Authors[0].Author != "bob" {
+		t.Errorf("Expected authors sorted by lines, got %+v", data.Authors)
+	}
+	if got := doc.Tables[1].Rows[0][4]; got != "0123456" {
+		t.Errorf("Expected a short commit, got %q", got)
+	}
+}
+
+func TestOverlapDocument(t *testing.T) {
+	pairs := []overlap.Pair{{
+		A: repo.PullRequest{Number: 1},
+		B: repo.PullRequest{Number: 2},
+		Files: []overlap.File{
+			{Path: "a.go", Compared: true, Ranges: []repo.LineRange{{Start: 3, End: 5}}},
+			{Path: "b.go"},
+		},
+		Lines: 3,
+	}}
+
+	doc := OverlapDocument("owner/repo", 4, pairs)
+	data := doc.Data.(Overlap)
+	if data.PullRequests != 4 || len(data.Pairs) != 1 {
+		t.Fatalf("Expected 1 pair of 4 pull requests, got %+v", data)
+	}
+	if got := data.Pairs[0]; got.SharedFiles != 2 || got.ConflictingFiles != 1 || got.OverlappingLines != 3 {
+		t.Errorf("Expected 2 shared, 1 conflicting file and 3 lines, got %+v", got)
+	}
+	expected := []string{"#1", "#2", "2", "1", "3", "a.go, b.go"}
+	if got := doc.Tables[0].Rows[0]; strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected row %v, got %v", expected, got)
+	}
+}
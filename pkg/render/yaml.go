@@ -0,0 +1,176 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// writeYAML writes v as YAML. It goes through v's JSON encoding, so field
+// names and omitted fields match the JSON output exactly, and object keys keep
+// their JSON order.
+func writeYAML(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeOrdered(decoder)
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	writeYAMLValue(&sb, value, 0)
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+// yamlField is an object member; objects are kept as slices to preserve order
+type yamlField struct {
+	key   string
+	value interface{}
+}
+
+// decodeOrdered decodes the next JSON value, representing objects as
+// []yamlField and arrays as []interface{}
+func decodeOrdered(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		fields := []yamlField{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, yamlField{key.(string), value})
+		}
+		_, err := decoder.Token()
+		return fields, err
+	case json.Delim('['):
+		items := []interface{}{}
+		for decoder.More() {
+			item, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err := decoder.Token()
+		return items, err
+	}
+	return token, nil
+}
+
+// writeYAMLValue writes a value in block style. Scalars are written inline by
+// the caller's key or dash; collections start on the next line.
+func writeYAMLValue(sb *strings.Builder, value interface{}, indent int) {
+	pad := strings.Repeat("  ", indent)
+
+	switch v := value.(type) {
+	case []yamlField:
+		for _, field := range v {
+			sb.WriteString(pad + yamlString(field.key) + ":")
+			writeYAMLChild(sb, field.value, indent)
+		}
+	case []interface{}:
+		for _, item := range v {
+			sb.WriteString(pad + "-")
+			if fields, ok := item.([]yamlField); ok && len(fields) > 0 {
+				// Start the first key of an object on the dash's line
+				var nested strings.Builder
+				writeYAMLValue(&nested, fields, indent+1)
+				sb.WriteString(" " + strings.TrimPrefix(nested.String(), pad+"  "))
+				continue
+			}
+			writeYAMLChild(sb, item, indent)
+		}
+	default:
+		sb.WriteString(pad + yamlScalar(v) + "\n")
+	}
+}
+
+// writeYAMLChild writes the value after a key or dash
+func writeYAMLChild(sb *strings.Builder, value interface{}, indent int) {
+	switch v := value.(type) {
+	case []yamlField:
+		if len(v) == 0 {
+			sb.WriteString(" {}\n")
+			return
+		}
+		sb.WriteString("\n")
+		writeYAMLValue(sb, v, indent+1)
+	case []interface{}:
+		if len(v) == 0 {
+			sb.WriteString(" []\n")
+			return
+		}
+		sb.WriteString("\n")
+		writeYAMLValue(sb, v, indent+1)
+	default:
+		sb.WriteString(" " + yamlScalar(v) + "\n")
+	}
+}
+
+func yamlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		return yamlString(v)
+	}
+	return yamlString(fmt.Sprint(value))
+}
+
+// yamlString writes s plainly when YAML would read it back as the same
+// string, and as a double-quoted (JSON compatible) string otherwise
+func yamlString(s string) string {
+	if s == "" || strings.TrimSpace(s) != s || strings.ContainsAny(s, "\"'\\\n\t#{}[],&*!|>%@`") ||
+		strings.Contains(s, ": ") || strings.HasSuffix(s, ":") || strings.HasPrefix(s, "-") ||
+		strings.HasPrefix(s, "?") {
+		return strconv.Quote(s)
+	}
+	switch strings.ToLower(s) {
+	case "null", "~", "true", "false", "yes", "no", "on", "off", "y", "n":
+		return strconv.Quote(s)
+	}
+	// Numbers and dates would be read back as other types
+	if _, err := strconv.ParseFloat(s, 64); err == nil || yamlNumber.MatchString(s) || yamlDate.MatchString(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+var yamlDate = regexp.MustCompile(`^\d{4}-\d{1,2}-\d{1,2}`)
+
+// yamlNumber matches the integers and floats of the YAML 1.1 and 1.2 core
+// schemas, which ParseFloat does not all accept: underscores, 0b, 0o and 0x
+// prefixes, sexagesimal numbers, .inf and .nan
+var yamlNumber = regexp.MustCompile(`^(?:` +
+	`[-+]?(?:[0-9][0-9_]*(?:\.[0-9_]*)?|\.[0-9_]+)(?:[eE][-+]?[0-9]+)?` +
+	`|[-+]?0b[01_]+` +
+	`|[-+]?0o[0-7_]+` +
+	`|[-+]?0x[0-9a-fA-F_]+` +
+	`|[-+]?[0-9][0-9_]*(?::[0-5]?[0-9])+(?:\.[0-9_]*)?` +
+	`|[-+]?\.(?:inf|Inf|INF)` +
+	`|\.(?:nan|NaN|NAN)` +
+	`)$`)
@@ -0,0 +1,366 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureAPIVersion is the Azure DevOps REST API version requests ask for
+const azureAPIVersion = "7.1"
+
+// azurePageSize is the number of items requested per page
+const azurePageSize = 100
+
+// AzureClient implements RepositoryClient for Azure DevOps Repos, where
+// repositories are named organization/project/repo. Azure DevOps reports no
+// blame and no per-file line counts, so GetBlameInfo fails and commits list
+// the files they changed without line counts.
+type AzureClient struct {
+	pager
+	fetcher
+	commitCache
+	api          restAPI
+	organization string
+}
+
+// NewAzureClient returns a client for Azure DevOps at baseURL (the root that
+// organizations are under), which sends its requests through httpClient.
+// ListRepositories lists the repositories of organization.
+func NewAzureClient(httpClient *http.Client, baseURL, organization string) *AzureClient {
+	api := restAPI{client: httpClient, baseURL: strings.TrimSuffix(baseURL, "/")}
+	return &AzureClient{pager: newPager(), fetcher: newFetcher(), api: api, organization: organization}
+}
+
+type azureRepository struct {
+	Name      string `json:"name"`
+	WebURL    string `json:"webUrl"`
+	RemoteURL string `json:"remoteUrl"`
+	Project   struct {
+		Name string `json:"name"`
+	} `json:"project"`
+}
+
+type azurePullRequest struct {
+	PullRequestID         int    `json:"pullRequestId"`
+	Title                 string `json:"title"`
+	Status                string `json:"status"`
+	SourceRefName         string `json:"sourceRefName"`
+	TargetRefName         string `json:"targetRefName"`
+	LastMergeSourceCommit struct {
+		CommitID string `json:"commitId"`
+	} `json:"lastMergeSourceCommit"`
+}
+
+type azureIteration struct {
+	ID int `json:"id"`
+}
+
+// azureChange is a change to one item. OriginalPath is the previous path of
+// renamed files, which change types such as "edit, rename" include.
+type azureChange struct {
+	ChangeType string `json:"changeType"`
+	Item       struct {
+		Path     string `json:"path"`
+		IsFolder bool   `json:"isFolder"`
+	} `json:"item"`
+	OriginalPath string `json:"originalPath"`
+}
+
+type azureCommit struct {
+	CommitID string `json:"commitId"`
+	Author   struct {
+		Name  string    `json:"name"`
+		Email string    `json:"email"`
+		Date  time.Time `json:"date"`
+	} `json:"author"`
+}
+
+// listAzure collects a list paged with $top and $skip, from a response that
+// holds the page under key
+func listAzure[T any](ctx context.Context, c *AzureClient, what, path, key string, query url.Values) ([]T, error) {
+	return paginate(&c.pager, what, func(skip int) ([]T, int, error) {
+		pageQuery := url.Values{"api-version": {azureAPIVersion}, "$top": {strconv.Itoa(azurePageSize)}}
+		for name, values := range query {
+			pageQuery[name] = values
+		}
+		if skip > 0 {
+			pageQuery.Set("$skip", strconv.Itoa(skip))
+		}
+
+		var resp map[string]json.RawMessage
+		if err := c.api.getJSON(ctx, c.api.url(path, pageQuery), &resp); err != nil {
+			return nil, 0, err
+		}
+		var values []T
+		if raw, ok := resp[key]; ok {
+			if err := json.Unmarshal(raw, &values); err != nil {
+				return nil, 0, fmt.Errorf("failed to decode %s: %v", key, err)
+			}
+		}
+		if len(values) < azurePageSize {
+			return values, 0, nil
+		}
+		return values, skip + len(values), nil
+	})
+}
+
+// repoPath returns the API path of a repository, below which its resources
+// are, and the path of its web page
+func (c *AzureClient) repoPath(repoRef RepoRef) (string, string, error) {
+	parts, err := repoRef.segments("organization/project/repo")
+	if err != nil {
+		return "", "", err
+	}
+	organization, project, repo := parts[0], parts[1], parts[2]
+	return apiPath(organization, project, "_apis", "git", "repositories", repo),
+		c.api.baseURL + apiPath(organization, project, "_git", repo), nil
+}
+
+func (c *AzureClient) ListRepositories(ctx context.Context) ([]Repository, error) {
+	if c.organization == "" {
+		return nil, fmt.Errorf("failed to list Azure DevOps repositories: no organization is set")
+	}
+
+	var resp struct {
+		Value []azureRepository `json:"value"`
+	}
+	query := url.Values{"api-version": {azureAPIVersion}}
+	if err := c.api.getJSON(ctx, c.api.url(apiPath(c.organization, "_apis", "git", "repositories"), query), &resp); err != nil {
+		return nil, fmt.Errorf("failed to list Azure DevOps repositories: %v", err)
+	}
+
+	var result []Repository
+	for _, repo := range resp.Value {
+		result = append(result, Repository{
+			Name:     repo.Name,
+			FullName: c.organization + "/" + repo.Project.Name + "/" + repo.Name,
+			URL:      repo.WebURL,
+			Provider: "azure",
+		})
+	}
+
+	return result, nil
+}
+
+func (c *AzureClient) ListPullRequests(ctx context.Context, repoRef RepoRef) ([]PullRequest, error) {
+	repoPath, webURL, err := c.repoPath(repoRef)
+	if err != nil {
+		return nil, err
+	}
+
+	prs, err := listAzure[azurePullRequest](ctx, c, "pull requests in "+repoRef.String(), repoPath+"/pullrequests", "value",
+		url.Values{"searchCriteria.status": {"active"}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pull requests: %v", err)
+	}
+
+	return convertPullRequests(ctx, prs, func(pr azurePullRequest) (*PullRequest, error) {
+		return c.convertPullRequest(ctx, repoPath, webURL, pr)
+	})
+}
+
+func (c *AzureClient) GetPullRequest(ctx context.Context, repoRef RepoRef, number int) (*PullRequest, error) {
+	repoPath, webURL, err := c.repoPath(repoRef)
+	if err != nil {
+		return nil, err
+	}
+
+	var pr azurePullRequest
+	query := url.Values{"api-version": {azureAPIVersion}}
+	err = c.api.getJSON(ctx, c.api.url(repoPath+"/pullrequests/"+strconv.Itoa(number), query), &pr)
+	if isNotFound(err) {
+		return nil, pullRequestNotFound(number)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pull request: %v", err)
+	}
+	return c.convertPullRequest(ctx, repoPath, webURL, pr)
+}
+
+func (c *AzureClient) CloneURL(ctx context.Context, repoRef RepoRef) (string, error) {
+	repoPath, _, err := c.repoPath(repoRef)
+	if err != nil {
+		return "", err
+	}
+
+	var repo azureRepository
+	if err := c.api.getJSON(ctx, c.api.url(repoPath, url.Values{"api-version": {azureAPIVersion}}), &repo); err != nil {
+		return "", fmt.Errorf("failed to get repository: %v", err)
+	}
+
+	// The remote URL carries the organization as a username
+	u, err := url.Parse(repo.RemoteURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid clone URL: %v", err)
+	}
+	u.User = nil
+	return u.String(), nil
+}
+
+// convertPullRequest converts an Azure DevOps pull request, fetching the
+// files its latest iteration changes
+func (c *AzureClient) convertPullRequest(ctx context.Context, repoPath, webURL string, pr azurePullRequest) (*PullRequest, error) {
+	changes, err := c.listPullRequestChanges(ctx, repoPath, pr.PullRequestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %v", err)
+	}
+
+	var changedFiles []string
+	for _, change := range changes {
+		changedFiles = append(changedFiles, azurePath(change.Item.Path))
+	}
+
+	return &PullRequest{
+		Number:       pr.PullRequestID,
+		Title:        pr.Title,
+		State:        pr.Status,
+		URL:          fmt.Sprintf("%s/pullrequest/%d", webURL, pr.PullRequestID),
+		Provider:     "azure",
+		BaseRef:      strings.TrimPrefix(pr.TargetRefName, "refs/heads/"),
+		HeadRef:      strings.TrimPrefix(pr.SourceRefName, "refs/heads/"),
+		HeadSHA:      pr.LastMergeSourceCommit.CommitID,
+		ChangedFiles: changedFiles,
+	}, nil
+}
+
+// listPullRequestChanges returns the files changed by the latest iteration
+// of a pull request (each push makes an iteration), compared to its target
+func (c *AzureClient) listPullRequestChanges(ctx context.Context, repoPath string, number int) ([]azureChange, error) {
+	prPath := fmt.Sprintf("%s/pullrequests/%d", repoPath, number)
+	var iterations struct {
+		Value []azureIteration `json:"value"`
+	}
+	if err := c.api.getJSON(ctx, c.api.url(prPath+"/iterations", url.Values{"api-version": {azureAPIVersion}}), &iterations); err != nil {
+		return nil, err
+	}
+	latest := 0
+	for _, iteration := range iterations.Value {
+		latest = max(latest, iteration.ID)
+	}
+	if latest == 0 {
+		return nil, nil
+	}
+
+	changes, err := paginate(&c.pager, fmt.Sprintf("files in pull request #%d", number), func(skip int) ([]azureChange, int, error) {
+		query := url.Values{"api-version": {azureAPIVersion}, "$compareTo": {"0"}, "$top": {strconv.Itoa(azurePageSize)}}
+		if skip > 0 {
+			query.Set("$skip", strconv.Itoa(skip))
+		}
+		var resp struct {
+			ChangeEntries []azureChange `json:"changeEntries"`
+			NextSkip      int           `json:"nextSkip"`
+		}
+		if err := c.api.getJSON(ctx, c.api.url(fmt.Sprintf("%s/iterations/%d/changes", prPath, latest), query), &resp); err != nil {
+			return nil, 0, err
+		}
+		return resp.ChangeEntries, resp.NextSkip, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return azureFiles(changes), nil
+}
+
+// GetBlameInfo fails, as the Azure DevOps API has no blame endpoint
+func (c *AzureClient) GetBlameInfo(ctx context.Context, repoRef RepoRef, prNumber int, files []string, window Window) (*BlameResult, error) {
+	return nil, fmt.Errorf("blame is not supported by Azure DevOps")
+}
+
+func (c *AzureClient) GetCommitHistory(ctx context.Context, repoRef RepoRef, window Window) ([]Commit, error) {
+	repoPath, _, err := c.repoPath(repoRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a ref, the commits of the default branch are listed
+	query := url.Values{}
+	if window.Ref != "" {
+		query.Set("searchCriteria.itemVersion.version", window.Ref)
+	}
+	if !window.Since.IsZero() {
+		query.Set("searchCriteria.fromDate", window.Since.Format(time.RFC3339))
+	}
+	if !window.Until.IsZero() {
+		query.Set("searchCriteria.toDate", window.Until.Format(time.RFC3339))
+	}
+	commits, err := listAzure[azureCommit](ctx, c, "commits in "+repoRef.String(), repoPath+"/commits", "value", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits: %v", err)
+	}
+
+	var shas []string
+	for _, commit := range commits {
+		shas = append(shas, commit.CommitID)
+	}
+	progress := newProgressCounter(ctx, PhaseFetchCommits, len(shas))
+	allChanges, err := fetchAll(ctx, &c.fetcher, shas, func(ctx context.Context, sha string) ([]FileChange, error) {
+		changes, err := c.getCommitChanges(ctx, repoPath, sha)
+		if err == nil {
+			progress.add()
+		}
+		return changes, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit changes: %v", err)
+	}
+
+	var result []Commit
+	for i, commit := range commits {
+		result = append(result, Commit{
+			SHA:    commit.CommitID,
+			Author: commit.Author.Name,
+			Email:  commit.Author.Email,
+			Date:   commit.Author.Date,
+			Files:  allChanges[i],
+		})
+	}
+
+	return result, nil
+}
+
+// getCommitChanges returns the files a commit changed, from the cache when it
+// holds them
+func (c *AzureClient) getCommitChanges(ctx context.Context, repoPath, sha string) ([]FileChange, error) {
+	key := fmt.Sprintf("azure:%s%s/commit/%s/changes", c.api.host(), repoPath, sha)
+	return cached(&c.commitCache, key, func() ([]FileChange, error) {
+		changes, err := listAzure[azureChange](ctx, c, "files in commit "+sha, repoPath+"/commits/"+url.PathEscape(sha)+"/changes", "changes", nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var files []FileChange
+		for _, change := range azureFiles(changes) {
+			file := FileChange{Path: azurePath(change.Item.Path)}
+			if strings.Contains(change.ChangeType, "rename") && change.OriginalPath != "" {
+				file.OldPath = azurePath(change.OriginalPath)
+			}
+			files = append(files, file)
+		}
+		return files, nil
+	}, func(files []FileChange) bool {
+		return c.below(len(files))
+	})
+}
+
+// azureFiles drops the changes to folders, which Azure DevOps lists too
+func azureFiles(changes []azureChange) []azureChange {
+	var files []azureChange
+	for _, change := range changes {
+		if !change.Item.IsFolder {
+			files = append(files, change)
+		}
+	}
+	return files
+}
+
+// azurePath converts an Azure DevOps item path, which is absolute, to a path
+// relative to the repository root
+func azurePath(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAzureClient(t *testing.T) {
+	const repoPath = "/acme/web/_apis/git/repositories/app"
+	responses := map[string]string{
+		"/acme/_apis/git/repositories": `{"count": 1, "value": [{"name": "app", "webUrl": "https://dev.azure.com/acme/web/_git/app", "project": {"name": "web"}}]}`,
+		repoPath:                       `{"name": "app", "remoteUrl": "https://acme@dev.azure.com/acme/web/_git/app"}`,
+		repoPath + "/pullrequests": `{"count": 1, "value": [{"pullRequestId": 7, "title": "Add feature", "status": "active",
+			"sourceRefName": "refs/heads/feature", "targetRefName": "refs/heads/main", "lastMergeSourceCommit": {"commitId": "abc123"}}]}`,
+		repoPath + "/pullrequests/7/iterations": `{"count": 2, "value": [{"id": 1}, {"id": 2}]}`,
+		repoPath + "/pullrequests/7/iterations/2/changes": `{"changeEntries": [
+			{"changeType": "edit", "item": {"path": "/app.go"}},
+			{"changeType": "add", "item": {"path": "/new.go"}}], "nextSkip": 2}`,
+		repoPath + "/pullrequests/7/iterations/2/changes?$skip=2": `{"changeEntries": [
+			{"changeType": "add", "item": {"path": "/docs", "isFolder": true}},
+			{"changeType": "add", "item": {"path": "/docs/guide.md"}}], "nextSkip": 0}`,
+		repoPath + "/commits": `{"count": 2, "value": [
+			{"commitId": "c2", "author": {"name": "Bob", "email": "bob@example.com", "date": "2024-02-01T00:00:00Z"}},
+			{"commitId": "c1", "author": {"name": "Alice", "email": "alice@example.com", "date": "2024-01-01T00:00:00Z"}}]}`,
+		repoPath + "/commits/c2/changes": `{"changeCounts": {"Edit": 1, "Rename": 1}, "changes": [
+			{"changeType": "edit", "item": {"path": "/app.go"}},
+			{"changeType": "rename", "item": {"path": "/lib.go"}, "originalPath": "/util.go"}]}`,
+		repoPath + "/commits/c1/changes": `{"changeCounts": {"Add": 1}, "changes": [
+			{"changeType": "add", "item": {"path": "/", "isFolder": true}},
+			{"changeType": "add", "item": {"path": "/app.go"}}]}`,
+	}
+
+	api := &fakeREST{
+		responses:     responses,
+		paging:        []string{"$skip"},
+		authorization: "Basic secret",
+		check: func(r *http.Request) error {
+			if r.URL.Query().Get("api-version") != azureAPIVersion {
+				return errors.New("no api-version")
+			}
+			return nil
+		},
+	}
+	server := api.start(t)
+	httpClient := api.client()
+	client := NewAzureClient(httpClient, server.URL+"/", "acme")
+	ctx := context.Background()
+	ref := mustParseRepoRef(t, Azure, "acme/web/app")
+
+	repos, err := client.ListRepositories(ctx)
+	if err != nil {
+		t.Fatalf("ListRepositories failed: %v", err)
+	}
+	if len(repos) != 1 || repos[0].FullName != "acme/web/app" {
+		t.Errorf("Expected acme/web/app, got %+v", repos)
+	}
+
+	prs, err := client.ListPullRequests(ctx, ref)
+	if err != nil {
+		t.Fatalf("ListPullRequests failed: %v", err)
+	}
+	if len(prs) != 1 {
+		t.Fatalf("Expected 1 pull request, got %d", len(prs))
+	}
+	pr := prs[0]
+	if pr.Number != 7 || pr.BaseRef != "main" || pr.HeadRef != "feature" || pr.HeadSHA != "abc123" {
+		t.Errorf("Unexpected pull request: %+v", pr)
+	}
+	if want := server.URL + "/acme/web/_git/app/pullrequest/7"; pr.URL != want {
+		t.Errorf("Expected URL %s, got %s", want, pr.URL)
+	}
+	if !reflect.DeepEqual(pr.ChangedFiles, []string{"app.go", "new.go", "docs/guide.md"}) {
+		t.Errorf("Expected app.go, new.go and docs/guide.md, got %v", pr.ChangedFiles)
+	}
+
+	cloneURL, err := client.CloneURL(ctx, ref)
+	if err != nil {
+		t.Fatalf("CloneURL failed: %v", err)
+	}
+	if cloneURL != "https://dev.azure.com/acme/web/_git/app" {
+		t.Errorf("Unexpected clone URL: %s", cloneURL)
+	}
+
+	window := Window{Since: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), Ref: "main"}
+	commits, err := client.GetCommitHistory(ctx, ref, window)
+	if err != nil {
+		t.Fatalf("GetCommitHistory failed: %v", err)
+	}
+	expected := []Commit{
+		{SHA: "c2", Author: "Bob", Email: "bob@example.com", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			Files: []FileChange{{Path: "app.go"}, {Path: "lib.go", OldPath: "util.go"}}},
+		{SHA: "c1", Author: "Alice", Email: "alice@example.com", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			Files: []FileChange{{Path: "app.go"}}},
+	}
+	if !reflect.DeepEqual(commits, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, commits)
+	}
+	query := api.query(repoPath + "/commits")
+	if !strings.Contains(query, "searchCriteria.itemVersion.version=main") || !strings.Contains(query, "searchCriteria.fromDate=2023-12-01") {
+		t.Errorf("Expected the ref and since in the commits query, got %s", query)
+	}
+
+	if _, err := client.GetPullRequest(ctx, mustParseRepoRef(t, GitHub, "acme/app"), 7); err == nil || !strings.Contains(err.Error(), "organization/project/repo") {
+		t.Errorf("Expected a name format error for acme/app, got %v", err)
+	}
+	if _, err := NewAzureClient(httpClient, server.URL, "").ListRepositories(ctx); err == nil {
+		t.Error("Expected an error without an organization")
+	}
+}
@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// NewBitbucketClient returns a client for the Bitbucket API at baseURL, which
+// sends its requests through httpClient. cloud selects the Bitbucket Cloud
+// API (baseURL is its /2.0 root) over the Bitbucket Server API (baseURL is the
+// root of the instance).
+func NewBitbucketClient(httpClient *http.Client, baseURL string, cloud bool) RepositoryClient {
+	api := restAPI{client: httpClient, baseURL: strings.TrimSuffix(baseURL, "/")}
+	if cloud {
+		return &BitbucketCloudClient{pager: newPager(), fetcher: newFetcher(), api: api}
+	}
+	api.baseURL += "/rest/api/1.0"
+	return &BitbucketServerClient{pager: newPager(), fetcher: newFetcher(), api: api}
+}
+
+// bitbucketCloneURL picks the HTTP clone URL from a repository's links,
+// without the username Bitbucket puts in it
+func bitbucketCloneURL(links []bitbucketLink, name string) (string, error) {
+	for _, link := range links {
+		if link.Name != name {
+			continue
+		}
+		u, err := url.Parse(link.Href)
+		if err != nil {
+			return "", fmt.Errorf("invalid clone URL: %v", err)
+		}
+		u.User = nil
+		return u.String(), nil
+	}
+	return "", fmt.Errorf("repository has no %s clone URL", name)
+}
+
+// bitbucketLink is a named link of a Bitbucket resource
+type bitbucketLink struct {
+	Name string `json:"name"`
+	Href string `json:"href"`
+}
+
+// splitCommitAuthor splits a raw git author, such as "Alice <alice@example.com>",
+// into a name and an email
+func splitCommitAuthor(raw string) (string, string) {
+	name, email, ok := strings.Cut(raw, "<")
+	if !ok {
+		return strings.TrimSpace(raw), ""
+	}
+	return strings.TrimSpace(name), strings.TrimSuffix(strings.TrimSpace(email), ">")
+}
@@ -0,0 +1,324 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// BitbucketCloudClient implements RepositoryClient for Bitbucket Cloud, where
+// repositories are named workspace/repository
+type BitbucketCloudClient struct {
+	pager
+	fetcher
+	commitCache
+	api restAPI
+}
+
+type bitbucketCloudRepository struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Links    struct {
+		HTML  bitbucketLink   `json:"html"`
+		Clone []bitbucketLink `json:"clone"`
+	} `json:"links"`
+	MainBranch struct {
+		Name string `json:"name"`
+	} `json:"mainbranch"`
+}
+
+type bitbucketCloudPullRequest struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	State string `json:"state"`
+	Links struct {
+		HTML bitbucketLink `json:"html"`
+	} `json:"links"`
+	Source      bitbucketCloudEndpoint `json:"source"`
+	Destination bitbucketCloudEndpoint `json:"destination"`
+}
+
+// bitbucketCloudEndpoint is the branch and commit on one side of a pull request
+type bitbucketCloudEndpoint struct {
+	Branch struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+	Commit struct {
+		Hash string `json:"hash"`
+	} `json:"commit"`
+}
+
+// bitbucketCloudDiffStat is the change to one file. Old is nil for added
+// files and New for removed ones.
+type bitbucketCloudDiffStat struct {
+	Status       string `json:"status"`
+	LinesAdded   int    `json:"lines_added"`
+	LinesRemoved int    `json:"lines_removed"`
+	Old          *struct {
+		Path string `json:"path"`
+	} `json:"old"`
+	New *struct {
+		Path string `json:"path"`
+	} `json:"new"`
+}
+
+// path returns the path of the file after the change, or before it for
+// removed files
+func (d bitbucketCloudDiffStat) path() string {
+	if d.New != nil {
+		return d.New.Path
+	}
+	if d.Old != nil {
+		return d.Old.Path
+	}
+	return ""
+}
+
+type bitbucketCloudCommit struct {
+	Hash   string    `json:"hash"`
+	Date   time.Time `json:"date"`
+	Author struct {
+		Raw string `json:"raw"`
+	} `json:"author"`
+}
+
+// listBitbucketCloud collects a paged list by following the next links of
+// its pages. When stop is set, the list ends at the first item it accepts.
+func listBitbucketCloud[T any](ctx context.Context, c *BitbucketCloudClient, what, path string, query url.Values, stop func(T) bool) ([]T, error) {
+	next := c.api.url(path, query)
+	return paginate(&c.pager, what, func(page int) ([]T, int, error) {
+		var resp struct {
+			Values []T    `json:"values"`
+			Next   string `json:"next"`
+		}
+		if err := c.api.getJSON(ctx, next, &resp); err != nil {
+			return nil, 0, err
+		}
+		next = resp.Next
+
+		values := resp.Values
+		if stop != nil {
+			for i, value := range values {
+				if stop(value) {
+					return values[:i], 0, nil
+				}
+			}
+		}
+		if next == "" {
+			return values, 0, nil
+		}
+		return values, page + 1, nil
+	})
+}
+
+func (c *BitbucketCloudClient) ListRepositories(ctx context.Context) ([]Repository, error) {
+	query := url.Values{"role": {"member"}, "sort": {"-updated_on"}, "pagelen": {"100"}}
+	repos, err := listBitbucketCloud[bitbucketCloudRepository](ctx, c, "repositories", "/repositories", query, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Bitbucket repositories: %v", err)
+	}
+
+	var result []Repository
+	for _, repo := range repos {
+		result = append(result, Repository{
+			Name:     repo.Name,
+			FullName: repo.FullName,
+			URL:      repo.Links.HTML.Href,
+			Provider: "bitbucket",
+		})
+	}
+
+	return result, nil
+}
+
+func (c *BitbucketCloudClient) ListPullRequests(ctx context.Context, repoRef RepoRef) ([]PullRequest, error) {
+	workspace, slug, err := repoRef.ownerAndName()
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"state": {"OPEN"}, "pagelen": {"50"}}
+	prs, err := listBitbucketCloud[bitbucketCloudPullRequest](ctx, c, "pull requests in "+repoRef.String(),
+		apiPath("repositories", workspace, slug, "pullrequests"), query, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pull requests: %v", err)
+	}
+
+	return convertPullRequests(ctx, prs, func(pr bitbucketCloudPullRequest) (*PullRequest, error) {
+		return c.convertPullRequest(ctx, workspace, slug, pr)
+	})
+}
+
+func (c *BitbucketCloudClient) GetPullRequest(ctx context.Context, repoRef RepoRef, number int) (*PullRequest, error) {
+	workspace, slug, err := repoRef.ownerAndName()
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := c.getPullRequest(ctx, workspace, slug, number)
+	if err != nil {
+		return nil, err
+	}
+	return c.convertPullRequest(ctx, workspace, slug, *pr)
+}
+
+func (c *BitbucketCloudClient) getPullRequest(ctx context.Context, workspace, slug string, number int) (*bitbucketCloudPullRequest, error) {
+	var pr bitbucketCloudPullRequest
+	path := apiPath("repositories", workspace, slug, "pullrequests", strconv.Itoa(number))
+	err := c.api.getJSON(ctx, c.api.url(path, nil), &pr)
+	if isNotFound(err) {
+		return nil, pullRequestNotFound(number)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pull request: %v", err)
+	}
+	return &pr, nil
+}
+
+func (c *BitbucketCloudClient) CloneURL(ctx context.Context, repoRef RepoRef) (string, error) {
+	repo, err := c.getRepository(ctx, repoRef)
+	if err != nil {
+		return "", err
+	}
+	return bitbucketCloneURL(repo.Links.Clone, "https")
+}
+
+func (c *BitbucketCloudClient) getRepository(ctx context.Context, repoRef RepoRef) (*bitbucketCloudRepository, error) {
+	workspace, slug, err := repoRef.ownerAndName()
+	if err != nil {
+		return nil, err
+	}
+
+	var repo bitbucketCloudRepository
+	if err := c.api.getJSON(ctx, c.api.url(apiPath("repositories", workspace, slug), nil), &repo); err != nil {
+		return nil, fmt.Errorf("failed to get repository: %v", err)
+	}
+	return &repo, nil
+}
+
+// convertPullRequest converts a Bitbucket Cloud pull request, fetching its
+// changed files and diff
+func (c *BitbucketCloudClient) convertPullRequest(ctx context.Context, workspace, slug string, pr bitbucketCloudPullRequest) (*PullRequest, error) {
+	prPath := apiPath("repositories", workspace, slug, "pullrequests", strconv.Itoa(pr.ID))
+	stats, err := listBitbucketCloud[bitbucketCloudDiffStat](ctx, c, fmt.Sprintf("files in pull request #%d", pr.ID),
+		prPath+"/diffstat", url.Values{"pagelen": {"100"}}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %v", err)
+	}
+	diff, err := c.api.getText(ctx, c.api.url(prPath+"/diff", nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get diff: %v", err)
+	}
+
+	var changedFiles []string
+	for _, stat := range stats {
+		changedFiles = append(changedFiles, stat.path())
+	}
+
+	return &PullRequest{
+		Number:       pr.ID,
+		Title:        pr.Title,
+		State:        pr.State,
+		URL:          pr.Links.HTML.Href,
+		Provider:     "bitbucket",
+		BaseRef:      pr.Destination.Branch.Name,
+		HeadRef:      pr.Source.Branch.Name,
+		HeadSHA:      pr.Source.Commit.Hash,
+		ChangedFiles: changedFiles,
+		Hunks:        parseDiffHunks(diff),
+	}, nil
+}
+
+// GetBlameInfo fails, as the Bitbucket Cloud API has no blame endpoint
+func (c *BitbucketCloudClient) GetBlameInfo(ctx context.Context, repoRef RepoRef, prNumber int, files []string, window Window) (*BlameResult, error) {
+	return nil, fmt.Errorf("blame is not supported by Bitbucket Cloud")
+}
+
+func (c *BitbucketCloudClient) GetCommitHistory(ctx context.Context, repoRef RepoRef, window Window) ([]Commit, error) {
+	workspace, slug, err := repoRef.ownerAndName()
+	if err != nil {
+		return nil, err
+	}
+
+	ref := window.Ref
+	if ref == "" {
+		repo, err := c.getRepository(ctx, repoRef)
+		if err != nil {
+			return nil, err
+		}
+		ref = repo.MainBranch.Name
+	}
+
+	// Commits come newest first and cannot be filtered by date, so the list
+	// ends at the first commit older than the window
+	commits, err := listBitbucketCloud(ctx, c, "commits in "+repoRef.String(),
+		apiPath("repositories", workspace, slug, "commits", ref), url.Values{"pagelen": {"100"}},
+		func(commit bitbucketCloudCommit) bool {
+			return !window.Since.IsZero() && commit.Date.Before(window.Since)
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits: %v", err)
+	}
+
+	var shas []string
+	var inWindow []bitbucketCloudCommit
+	for _, commit := range commits {
+		if !window.Until.IsZero() && commit.Date.After(window.Until) {
+			continue
+		}
+		shas = append(shas, commit.Hash)
+		inWindow = append(inWindow, commit)
+	}
+	progress := newProgressCounter(ctx, PhaseFetchCommits, len(shas))
+	allStats, err := fetchAll(ctx, &c.fetcher, shas, func(ctx context.Context, sha string) ([]bitbucketCloudDiffStat, error) {
+		stats, err := c.getCommitDiffStat(ctx, workspace, slug, sha)
+		if err == nil {
+			progress.add()
+		}
+		return stats, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit diff: %v", err)
+	}
+
+	var result []Commit
+	for i, commit := range inWindow {
+		var files []FileChange
+		for _, stat := range allStats[i] {
+			change := FileChange{
+				Path:      stat.path(),
+				Additions: stat.LinesAdded,
+				Deletions: stat.LinesRemoved,
+			}
+			if stat.Status == "renamed" && stat.Old != nil {
+				change.OldPath = stat.Old.Path
+			}
+			files = append(files, change)
+		}
+
+		author, email := splitCommitAuthor(commit.Author.Raw)
+		result = append(result, Commit{
+			SHA:    commit.Hash,
+			Author: author,
+			Email:  email,
+			Date:   commit.Date,
+			Files:  files,
+		})
+	}
+
+	return result, nil
+}
+
+// getCommitDiffStat returns the changes a commit made to each file, from the
+// cache when it holds them
+func (c *BitbucketCloudClient) getCommitDiffStat(ctx context.Context, workspace, slug, sha string) ([]bitbucketCloudDiffStat, error) {
+	key := fmt.Sprintf("bitbucket:%s/%s/%s/commit/%s/diffstat", c.api.host(), workspace, slug, sha)
+	return cached(&c.commitCache, key, func() ([]bitbucketCloudDiffStat, error) {
+		return listBitbucketCloud[bitbucketCloudDiffStat](ctx, c, "files in commit "+sha,
+			apiPath("repositories", workspace, slug, "diffstat", sha), url.Values{"pagelen": {"100"}}, nil)
+	}, func(stats []bitbucketCloudDiffStat) bool {
+		return c.below(len(stats))
+	})
+}
@@ -0,0 +1,470 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// BitbucketServerClient implements RepositoryClient for Bitbucket Server and
+// Data Center, where repositories are named PROJECT/repository
+type BitbucketServerClient struct {
+	pager
+	fetcher
+	commitCache
+	api restAPI
+}
+
+type bitbucketServerRepository struct {
+	Slug    string `json:"slug"`
+	Name    string `json:"name"`
+	Project struct {
+		Key string `json:"key"`
+	} `json:"project"`
+	Links struct {
+		Self  []bitbucketLink `json:"self"`
+		Clone []bitbucketLink `json:"clone"`
+	} `json:"links"`
+}
+
+type bitbucketServerPullRequest struct {
+	ID      int                `json:"id"`
+	Title   string             `json:"title"`
+	State   string             `json:"state"`
+	FromRef bitbucketServerRef `json:"fromRef"`
+	ToRef   bitbucketServerRef `json:"toRef"`
+	Links   struct {
+		Self []bitbucketLink `json:"self"`
+	} `json:"links"`
+}
+
+// bitbucketServerRef is the branch on one side of a pull request
+type bitbucketServerRef struct {
+	DisplayID    string `json:"displayId"`
+	LatestCommit string `json:"latestCommit"`
+}
+
+type bitbucketServerPath struct {
+	ToString string `json:"toString"`
+}
+
+// bitbucketServerChange is a file changed by a pull request. SrcPath is the
+// previous path of moved files.
+type bitbucketServerChange struct {
+	Path    bitbucketServerPath  `json:"path"`
+	SrcPath *bitbucketServerPath `json:"srcPath"`
+	Type    string               `json:"type"`
+}
+
+// bitbucketServerDiff is the diff of one file. Source is nil for added files
+// and Destination for deleted ones.
+type bitbucketServerDiff struct {
+	Source      *bitbucketServerPath `json:"source"`
+	Destination *bitbucketServerPath `json:"destination"`
+	Hunks       []struct {
+		SourceLine int `json:"sourceLine"`
+		SourceSpan int `json:"sourceSpan"`
+		Segments   []struct {
+			Type  string     `json:"type"`
+			Lines []struct{} `json:"lines"`
+		} `json:"segments"`
+	} `json:"hunks"`
+}
+
+// path returns the path of the file after the change, or before it for
+// deleted files
+func (d bitbucketServerDiff) path() string {
+	if d.Destination != nil {
+		return d.Destination.ToString
+	}
+	if d.Source != nil {
+		return d.Source.ToString
+	}
+	return ""
+}
+
+type bitbucketServerDiffs struct {
+	Diffs     []bitbucketServerDiff `json:"diffs"`
+	Truncated bool                  `json:"truncated"`
+}
+
+type bitbucketServerCommit struct {
+	ID     string `json:"id"`
+	Author struct {
+		Name         string `json:"name"`
+		EmailAddress string `json:"emailAddress"`
+	} `json:"author"`
+	// AuthorTimestamp is in milliseconds since the epoch
+	AuthorTimestamp int64 `json:"authorTimestamp"`
+}
+
+// bitbucketServerBlame attributes SpannedLines lines from LineNumber to a commit
+type bitbucketServerBlame struct {
+	Author struct {
+		Name         string `json:"name"`
+		EmailAddress string `json:"emailAddress"`
+	} `json:"author"`
+	AuthorTimestamp int64  `json:"authorTimestamp"`
+	CommitID        string `json:"commitId"`
+	LineNumber      int    `json:"lineNumber"`
+	SpannedLines    int    `json:"spannedLines"`
+}
+
+// listBitbucketServer collects a paged list, where each page gives the start
+// of the next. When stop is set, the list ends at the first item it accepts.
+func listBitbucketServer[T any](ctx context.Context, c *BitbucketServerClient, what, path string, query url.Values, stop func(T) bool) ([]T, error) {
+	return paginate(&c.pager, what, func(page int) ([]T, int, error) {
+		pageQuery := url.Values{"limit": {"100"}}
+		for key, values := range query {
+			pageQuery[key] = values
+		}
+		if page > 0 {
+			pageQuery.Set("start", strconv.Itoa(page))
+		}
+
+		var resp struct {
+			Values        []T  `json:"values"`
+			IsLastPage    bool `json:"isLastPage"`
+			NextPageStart int  `json:"nextPageStart"`
+		}
+		if err := c.api.getJSON(ctx, c.api.url(path, pageQuery), &resp); err != nil {
+			return nil, 0, err
+		}
+
+		values := resp.Values
+		if stop != nil {
+			for i, value := range values {
+				if stop(value) {
+					return values[:i], 0, nil
+				}
+			}
+		}
+		if resp.IsLastPage {
+			return values, 0, nil
+		}
+		return values, resp.NextPageStart, nil
+	})
+}
+
+// repoPath returns the API path of a repository, below which its resources are
+func (c *BitbucketServerClient) repoPath(repoRef RepoRef) (string, error) {
+	project, slug, err := repoRef.ownerAndName()
+	if err != nil {
+		return "", err
+	}
+	return apiPath("projects", project, "repos", slug), nil
+}
+
+func (c *BitbucketServerClient) ListRepositories(ctx context.Context) ([]Repository, error) {
+	repos, err := listBitbucketServer[bitbucketServerRepository](ctx, c, "repositories", "/repos", nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Bitbucket repositories: %v", err)
+	}
+
+	var result []Repository
+	for _, repo := range repos {
+		var webURL string
+		if len(repo.Links.Self) > 0 {
+			webURL = repo.Links.Self[0].Href
+		}
+		result = append(result, Repository{
+			Name:     repo.Name,
+			FullName: repo.Project.Key + "/" + repo.Slug,
+			URL:      webURL,
+			Provider: "bitbucket",
+		})
+	}
+
+	return result, nil
+}
+
+func (c *BitbucketServerClient) ListPullRequests(ctx context.Context, repoRef RepoRef) ([]PullRequest, error) {
+	repoPath, err := c.repoPath(repoRef)
+	if err != nil {
+		return nil, err
+	}
+
+	prs, err := listBitbucketServer[bitbucketServerPullRequest](ctx, c, "pull requests in "+repoRef.String(),
+		repoPath+"/pull-requests", url.Values{"state": {"OPEN"}}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pull requests: %v", err)
+	}
+
+	return convertPullRequests(ctx, prs, func(pr bitbucketServerPullRequest) (*PullRequest, error) {
+		return c.convertPullRequest(ctx, repoPath, pr)
+	})
+}
+
+func (c *BitbucketServerClient) GetPullRequest(ctx context.Context, repoRef RepoRef, number int) (*PullRequest, error) {
+	repoPath, err := c.repoPath(repoRef)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := c.getPullRequest(ctx, repoPath, number)
+	if err != nil {
+		return nil, err
+	}
+	return c.convertPullRequest(ctx, repoPath, *pr)
+}
+
+func (c *BitbucketServerClient) getPullRequest(ctx context.Context, repoPath string, number int) (*bitbucketServerPullRequest, error) {
+	var pr bitbucketServerPullRequest
+	err := c.api.getJSON(ctx, c.api.url(repoPath+"/pull-requests/"+strconv.Itoa(number), nil), &pr)
+	if isNotFound(err) {
+		return nil, pullRequestNotFound(number)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pull request: %v", err)
+	}
+	return &pr, nil
+}
+
+func (c *BitbucketServerClient) CloneURL(ctx context.Context, repoRef RepoRef) (string, error) {
+	repoPath, err := c.repoPath(repoRef)
+	if err != nil {
+		return "", err
+	}
+
+	var repo bitbucketServerRepository
+	if err := c.api.getJSON(ctx, c.api.url(repoPath, nil), &repo); err != nil {
+		return "", fmt.Errorf("failed to get repository: %v", err)
+	}
+	return bitbucketCloneURL(repo.Links.Clone, "http")
+}
+
+// listPullRequestChanges returns every file changed by a pull request
+func (c *BitbucketServerClient) listPullRequestChanges(ctx context.Context, repoPath string, number int) ([]bitbucketServerChange, error) {
+	return listBitbucketServer[bitbucketServerChange](ctx, c, fmt.Sprintf("files in pull request #%d", number),
+		fmt.Sprintf("%s/pull-requests/%d/changes", repoPath, number), nil, nil)
+}
+
+// convertPullRequest converts a Bitbucket Server pull request, fetching its
+// changed files and diff
+func (c *BitbucketServerClient) convertPullRequest(ctx context.Context, repoPath string, pr bitbucketServerPullRequest) (*PullRequest, error) {
+	changes, err := c.listPullRequestChanges(ctx, repoPath, pr.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %v", err)
+	}
+	var diff bitbucketServerDiffs
+	if err := c.api.getJSON(ctx, c.api.url(fmt.Sprintf("%s/pull-requests/%d/diff", repoPath, pr.ID), nil), &diff); err != nil {
+		return nil, fmt.Errorf("failed to get diff: %v", err)
+	}
+
+	var changedFiles []string
+	for _, change := range changes {
+		changedFiles = append(changedFiles, change.Path.ToString)
+	}
+	hunks := map[string][]LineRange{}
+	for _, file := range diff.Diffs {
+		for _, hunk := range file.Hunks {
+			hunks[file.path()] = append(hunks[file.path()], hunkRange(hunk.SourceLine, hunk.SourceSpan))
+		}
+	}
+
+	var webURL string
+	if len(pr.Links.Self) > 0 {
+		webURL = pr.Links.Self[0].Href
+	}
+	return &PullRequest{
+		Number:       pr.ID,
+		Title:        pr.Title,
+		State:        pr.State,
+		URL:          webURL,
+		Provider:     "bitbucket",
+		BaseRef:      pr.ToRef.DisplayID,
+		HeadRef:      pr.FromRef.DisplayID,
+		HeadSHA:      pr.FromRef.LatestCommit,
+		ChangedFiles: changedFiles,
+		Hunks:        hunks,
+	}, nil
+}
+
+func (c *BitbucketServerClient) GetBlameInfo(ctx context.Context, repoRef RepoRef, prNumber int, files []string, window Window) (*BlameResult, error) {
+	repoPath, err := c.repoPath(repoRef)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := c.getPullRequest(ctx, repoPath, prNumber)
+	if err != nil {
+		return nil, err
+	}
+	baseSHA := pr.ToRef.LatestCommit
+	ref := baseSHA
+	if window.Ref != "" {
+		// Files missing at the ref are skipped, so the ref itself must exist
+		var commit struct{}
+		if err := c.api.getJSON(ctx, c.api.url(repoPath+"/commits"+apiPath(window.Ref), nil), &commit); err != nil {
+			return nil, fmt.Errorf("failed to find ref %s: %v", window.Ref, err)
+		}
+		ref = window.Ref
+	}
+
+	// Map each file to its path at the base commit, skipping files the PR adds
+	changes, err := c.listPullRequestChanges(ctx, repoPath, prNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %v", err)
+	}
+	basePaths := make(map[string]string)
+	for _, change := range changes {
+		switch {
+		case change.Type == "ADD":
+		case change.SrcPath != nil && change.SrcPath.ToString != "":
+			basePaths[change.Path.ToString] = change.SrcPath.ToString
+		default:
+			basePaths[change.Path.ToString] = change.Path.ToString
+		}
+	}
+
+	result := newBlameResult(ref)
+	reportBlameProgress(ctx, result, 0, len(files))
+	for i, filename := range files {
+		basePath, ok := basePaths[filename]
+		if !ok {
+			continue
+		}
+
+		// Blame at a fixed commit never changes, unlike blame at a branch
+		key := fmt.Sprintf("bitbucket:%s%s/blame/%s/%s", c.api.host(), repoPath, ref, basePath)
+		ranges, err := cached(&c.commitCache, key, func() ([]BlameRange, error) {
+			return c.blameFile(ctx, repoPath, ref, basePath)
+		}, func([]BlameRange) bool { return ref == baseSHA })
+		if isNotFound(err) {
+			result.Skipped = append(result.Skipped, filename)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get blame for file %s: %v", filename, err)
+		}
+		result.addFile(filename, window.filter(ranges))
+		reportBlameProgress(ctx, result, i+1, len(files))
+	}
+
+	return result, nil
+}
+
+// blameFile fetches the blame of a file at ref
+func (c *BitbucketServerClient) blameFile(ctx context.Context, repoPath, ref, path string) ([]BlameRange, error) {
+	query := url.Values{"at": {ref}, "blame": {"true"}, "noContent": {"true"}}
+	var blame []bitbucketServerBlame
+	if err := c.api.getJSON(ctx, c.api.url(repoPath+"/browse"+apiFilePath(path), query), &blame); err != nil {
+		return nil, err
+	}
+
+	var ranges []BlameRange
+	for _, group := range blame {
+		author := group.Author.Name
+		if author == "" {
+			author = group.Author.EmailAddress
+		}
+		ranges = append(ranges, BlameRange{
+			StartLine: group.LineNumber,
+			EndLine:   group.LineNumber + group.SpannedLines - 1,
+			Author:    author,
+			Commit:    group.CommitID,
+			Date:      time.UnixMilli(group.AuthorTimestamp),
+		})
+	}
+	return ranges, nil
+}
+
+func (c *BitbucketServerClient) GetCommitHistory(ctx context.Context, repoRef RepoRef, window Window) ([]Commit, error) {
+	repoPath, err := c.repoPath(repoRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a ref, the commits of the default branch are listed
+	query := url.Values{}
+	if window.Ref != "" {
+		query.Set("until", window.Ref)
+	}
+
+	// Commits come newest first and cannot be filtered by date, so the list
+	// ends at the first commit older than the window
+	commits, err := listBitbucketServer(ctx, c, "commits in "+repoRef.String(), repoPath+"/commits", query,
+		func(commit bitbucketServerCommit) bool {
+			return !window.Since.IsZero() && time.UnixMilli(commit.AuthorTimestamp).Before(window.Since)
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits: %v", err)
+	}
+
+	var shas []string
+	var inWindow []bitbucketServerCommit
+	for _, commit := range commits {
+		if !window.Until.IsZero() && time.UnixMilli(commit.AuthorTimestamp).After(window.Until) {
+			continue
+		}
+		shas = append(shas, commit.ID)
+		inWindow = append(inWindow, commit)
+	}
+	progress := newProgressCounter(ctx, PhaseFetchCommits, len(shas))
+	allChanges, err := fetchAll(ctx, &c.fetcher, shas, func(ctx context.Context, sha string) ([]FileChange, error) {
+		changes, err := c.getCommitChanges(ctx, repoPath, sha)
+		if err == nil {
+			progress.add()
+		}
+		return changes, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit diff: %v", err)
+	}
+
+	var result []Commit
+	for i, commit := range inWindow {
+		result = append(result, Commit{
+			SHA:    commit.ID,
+			Author: commit.Author.Name,
+			Email:  commit.Author.EmailAddress,
+			Date:   time.UnixMilli(commit.AuthorTimestamp),
+			Files:  allChanges[i],
+		})
+	}
+
+	return result, nil
+}
+
+// getCommitChanges returns the lines a commit added to and deleted from each
+// file, from the cache when it holds them. Diffs the server truncated are not
+// cached.
+func (c *BitbucketServerClient) getCommitChanges(ctx context.Context, repoPath, sha string) ([]FileChange, error) {
+	key := fmt.Sprintf("bitbucket:%s%s/commit/%s/changes", c.api.host(), repoPath, sha)
+	var truncated bool
+	return cached(&c.commitCache, key, func() ([]FileChange, error) {
+		var diff bitbucketServerDiffs
+		if err := c.api.getJSON(ctx, c.api.url(repoPath+"/commits/"+url.PathEscape(sha)+"/diff", nil), &diff); err != nil {
+			return nil, err
+		}
+		truncated = diff.Truncated
+		return diffFileChanges(diff.Diffs), nil
+	}, func([]FileChange) bool {
+		return !truncated
+	})
+}
+
+// diffFileChanges counts the added and removed lines of each file in a diff
+func diffFileChanges(diffs []bitbucketServerDiff) []FileChange {
+	var files []FileChange
+	for _, diff := range diffs {
+		change := FileChange{Path: diff.path()}
+		if diff.Source != nil && diff.Destination != nil && diff.Source.ToString != diff.Destination.ToString {
+			change.OldPath = diff.Source.ToString
+		}
+		for _, hunk := range diff.Hunks {
+			for _, segment := range hunk.Segments {
+				switch segment.Type {
+				case "ADDED":
+					change.Additions += len(segment.Lines)
+				case "REMOVED":
+					change.Deletions += len(segment.Lines)
+				}
+			}
+		}
+		files = append(files, change)
+	}
+	return files
+}
@@ -0,0 +1,212 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const bitbucketTestDiff = `diff --git a/app.go b/app.go
+--- a/app.go
++++ b/app.go
+@@ -10,3 +10,4 @@
+ context
++added
+diff --git a/new.go b/new.go
+new file mode 100644
+--- /dev/null
++++ b/new.go
+@@ -0,0 +1 @@
++package app
+`
+
+func TestBitbucketCloudClient(t *testing.T) {
+	server := (&fakeREST{paging: []string{"page", "start"}, responses: map[string]string{
+		"/2.0/repositories": `{"values": [{"name": "app", "full_name": "ws/app", "links": {"html": {"href": "https://bitbucket.org/ws/app"}}}],
+			"next": "{{url}}/2.0/repositories?page=2"}`,
+		"/2.0/repositories?page=2": `{"values": [{"name": "lib", "full_name": "ws/lib"}]}`,
+		"/2.0/repositories/ws/app": `{"name": "app", "mainbranch": {"name": "main"},
+			"links": {"clone": [{"name": "https", "href": "https://alice@bitbucket.org/ws/app.git"}, {"name": "ssh", "href": "git@bitbucket.org:ws/app.git"}]}}`,
+		"/2.0/repositories/ws/app/pullrequests": `{"values": [{"id": 7, "title": "Add feature", "state": "OPEN",
+			"links": {"html": {"href": "https://bitbucket.org/ws/app/pull-requests/7"}},
+			"source": {"branch": {"name": "feature"}, "commit": {"hash": "abc123"}},
+			"destination": {"branch": {"name": "main"}, "commit": {"hash": "def456"}}}]}`,
+		"/2.0/repositories/ws/app/pullrequests/7/diffstat": `{"values": [
+			{"status": "modified", "old": {"path": "app.go"}, "new": {"path": "app.go"}},
+			{"status": "added", "old": null, "new": {"path": "new.go"}}]}`,
+		"/2.0/repositories/ws/app/pullrequests/7/diff": bitbucketTestDiff,
+		"/2.0/repositories/ws/app/commits/main": `{"values": [
+			{"hash": "c3", "date": "2024-03-01T00:00:00Z", "author": {"raw": "Carol <carol@example.com>"}},
+			{"hash": "c2", "date": "2024-02-01T00:00:00Z", "author": {"raw": "Bob <bob@example.com>"}},
+			{"hash": "c1", "date": "2023-01-01T00:00:00Z", "author": {"raw": "Alice <alice@example.com>"}}],
+			"next": "{{url}}/2.0/repositories/ws/app/commits/main?page=2"}`,
+		"/2.0/repositories/ws/app/diffstat/c3": `{"values": [{"status": "renamed", "lines_added": 1, "lines_removed": 0,
+			"old": {"path": "old.go"}, "new": {"path": "app.go"}}]}`,
+		"/2.0/repositories/ws/app/diffstat/c2": `{"values": [{"status": "removed", "lines_added": 0, "lines_removed": 4,
+			"old": {"path": "gone.go"}, "new": null}]}`,
+	}}).start(t)
+	client := NewBitbucketClient(server.Client(), server.URL+"/2.0", true)
+	ctx := context.Background()
+
+	repos, err := client.ListRepositories(ctx)
+	if err != nil {
+		t.Fatalf("ListRepositories failed: %v", err)
+	}
+	if len(repos) != 2 || repos[0].FullName != "ws/app" || repos[1].FullName != "ws/lib" {
+		t.Errorf("Expected ws/app and ws/lib over two pages, got %+v", repos)
+	}
+
+	prs, err := client.ListPullRequests(ctx, mustParseRepoRef(t, Bitbucket, "ws/app"))
+	if err != nil {
+		t.Fatalf("ListPullRequests failed: %v", err)
+	}
+	if len(prs) != 1 {
+		t.Fatalf("Expected 1 pull request, got %d", len(prs))
+	}
+	pr := prs[0]
+	if pr.Number != 7 || pr.BaseRef != "main" || pr.HeadRef != "feature" || pr.HeadSHA != "abc123" {
+		t.Errorf("Unexpected pull request: %+v", pr)
+	}
+	if !reflect.DeepEqual(pr.ChangedFiles, []string{"app.go", "new.go"}) {
+		t.Errorf("Expected app.go and new.go, got %v", pr.ChangedFiles)
+	}
+	expectedHunks := map[string][]LineRange{"app.go": {{10, 12}}, "new.go": {{1, 1}}}
+	if !reflect.DeepEqual(pr.Hunks, expectedHunks) {
+		t.Errorf("Expected hunks %v, got %v", expectedHunks, pr.Hunks)
+	}
+
+	cloneURL, err := client.CloneURL(ctx, mustParseRepoRef(t, Bitbucket, "ws/app"))
+	if err != nil {
+		t.Fatalf("CloneURL failed: %v", err)
+	}
+	if cloneURL != "https://bitbucket.org/ws/app.git" {
+		t.Errorf("Expected the clone URL without a username, got %s", cloneURL)
+	}
+
+	// c1 is older than the window, so the second page is never fetched
+	window := Window{Since: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	commits, err := client.GetCommitHistory(ctx, mustParseRepoRef(t, Bitbucket, "ws/app"), window)
+	if err != nil {
+		t.Fatalf("GetCommitHistory failed: %v", err)
+	}
+	expected := []Commit{
+		{SHA: "c3", Author: "Carol", Email: "carol@example.com", Date: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			Files: []FileChange{{Path: "app.go", OldPath: "old.go", Additions: 1}}},
+		{SHA: "c2", Author: "Bob", Email: "bob@example.com", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			Files: []FileChange{{Path: "gone.go", Deletions: 4}}},
+	}
+	if !reflect.DeepEqual(commits, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, commits)
+	}
+
+	if _, err := client.GetBlameInfo(ctx, mustParseRepoRef(t, Bitbucket, "ws/app"), 7, pr.ChangedFiles, Window{}); err == nil {
+		t.Error("Expected blame to be unsupported")
+	}
+}
+
+func TestBitbucketServerClient(t *testing.T) {
+	const repoPath = "/rest/api/1.0/projects/PROJ/repos/app"
+	server := (&fakeREST{paging: []string{"page", "start"}, responses: map[string]string{
+		"/rest/api/1.0/repos": `{"values": [{"slug": "app", "name": "App", "project": {"key": "PROJ"},
+			"links": {"self": [{"href": "https://bitbucket.example.com/projects/PROJ/repos/app/browse"}]}}],
+			"isLastPage": false, "nextPageStart": 1}`,
+		"/rest/api/1.0/repos?start=1": `{"values": [{"slug": "lib", "name": "Lib", "project": {"key": "PROJ"}}], "isLastPage": true}`,
+		repoPath: `{"slug": "app", "links": {"clone": [{"name": "ssh", "href": "ssh://git@bitbucket.example.com:7999/proj/app.git"},
+			{"name": "http", "href": "https://alice@bitbucket.example.com/scm/proj/app.git"}]}}`,
+		repoPath + "/pull-requests": `{"values": [{"id": 3, "title": "Rename", "state": "OPEN",
+			"fromRef": {"displayId": "feature", "latestCommit": "abc123"},
+			"toRef": {"displayId": "main", "latestCommit": "def456"}}], "isLastPage": true}`,
+		repoPath + "/pull-requests/3": `{"id": 3, "title": "Rename", "state": "OPEN",
+			"fromRef": {"displayId": "feature", "latestCommit": "abc123"},
+			"toRef": {"displayId": "main", "latestCommit": "def456"}}`,
+		repoPath + "/pull-requests/3/changes": `{"values": [
+			{"path": {"toString": "src/new name.go"}, "srcPath": {"toString": "src/old.go"}, "type": "MOVE"},
+			{"path": {"toString": "added.go"}, "type": "ADD"}], "isLastPage": true}`,
+		repoPath + "/pull-requests/3/diff": `{"diffs": [
+			{"source": {"toString": "src/old.go"}, "destination": {"toString": "src/new name.go"},
+			 "hunks": [{"sourceLine": 5, "sourceSpan": 2, "segments": []}]},
+			{"source": null, "destination": {"toString": "added.go"},
+			 "hunks": [{"sourceLine": 0, "sourceSpan": 0, "segments": []}]}]}`,
+		repoPath + "/browse/src/old.go": `[
+			{"author": {"name": "alice"}, "authorTimestamp": 1704067200000, "commitId": "c1", "lineNumber": 1, "spannedLines": 3},
+			{"author": {"name": "bob"}, "authorTimestamp": 1706745600000, "commitId": "c2", "lineNumber": 4, "spannedLines": 2}]`,
+		repoPath + "/commits": `{"values": [
+			{"id": "c2", "author": {"name": "bob", "emailAddress": "bob@example.com"}, "authorTimestamp": 1706745600000},
+			{"id": "c1", "author": {"name": "alice", "emailAddress": "alice@example.com"}, "authorTimestamp": 1704067200000}],
+			"isLastPage": true}`,
+		repoPath + "/commits/c2/diff": `{"diffs": [{"source": {"toString": "a.go"}, "destination": {"toString": "a.go"},
+			"hunks": [{"sourceLine": 1, "sourceSpan": 2, "segments": [
+				{"type": "CONTEXT", "lines": [{}]}, {"type": "REMOVED", "lines": [{}]}, {"type": "ADDED", "lines": [{}, {}]}]}]}]}`,
+		repoPath + "/commits/c1/diff": `{"diffs": [{"source": null, "destination": {"toString": "a.go"},
+			"hunks": [{"sourceLine": 0, "sourceSpan": 0, "segments": [{"type": "ADDED", "lines": [{}, {}, {}]}]}]}]}`,
+	}}).start(t)
+	client := NewBitbucketClient(server.Client(), server.URL, false)
+	ctx := context.Background()
+
+	repos, err := client.ListRepositories(ctx)
+	if err != nil {
+		t.Fatalf("ListRepositories failed: %v", err)
+	}
+	if len(repos) != 2 || repos[0].FullName != "PROJ/app" || repos[1].FullName != "PROJ/lib" {
+		t.Errorf("Expected PROJ/app and PROJ/lib over two pages, got %+v", repos)
+	}
+
+	pr, err := client.GetPullRequest(ctx, mustParseRepoRef(t, Bitbucket, "PROJ/app"), 3)
+	if err != nil {
+		t.Fatalf("GetPullRequest failed: %v", err)
+	}
+	if pr.BaseRef != "main" || pr.HeadRef != "feature" || pr.HeadSHA != "abc123" {
+		t.Errorf("Unexpected pull request: %+v", pr)
+	}
+	if !reflect.DeepEqual(pr.ChangedFiles, []string{"src/new name.go", "added.go"}) {
+		t.Errorf("Expected the moved and added files, got %v", pr.ChangedFiles)
+	}
+	expectedHunks := map[string][]LineRange{"src/new name.go": {{5, 6}}, "added.go": {{1, 1}}}
+	if !reflect.DeepEqual(pr.Hunks, expectedHunks) {
+		t.Errorf("Expected hunks %v, got %v", expectedHunks, pr.Hunks)
+	}
+
+	// The moved file is blamed at its old path and the added file skipped
+	blame, err := client.GetBlameInfo(ctx, mustParseRepoRef(t, Bitbucket, "PROJ/app"), 3, pr.ChangedFiles, Window{})
+	if err != nil {
+		t.Fatalf("GetBlameInfo failed: %v", err)
+	}
+	if blame.Ref != "def456" || len(blame.Files) != 1 {
+		t.Fatalf("Expected one file blamed at def456, got %+v", blame)
+	}
+	if blame.Authors["alice"].Lines != 3 || blame.Authors["bob"].Lines != 2 {
+		t.Errorf("Expected alice to own 3 lines and bob 2, got %+v", blame.Authors)
+	}
+
+	cloneURL, err := client.CloneURL(ctx, mustParseRepoRef(t, Bitbucket, "PROJ/app"))
+	if err != nil {
+		t.Fatalf("CloneURL failed: %v", err)
+	}
+	if cloneURL != "https://bitbucket.example.com/scm/proj/app.git" {
+		t.Errorf("Expected the HTTP clone URL without a username, got %s", cloneURL)
+	}
+
+	commits, err := client.GetCommitHistory(ctx, mustParseRepoRef(t, Bitbucket, "PROJ/app"), Window{})
+	if err != nil {
+		t.Fatalf("GetCommitHistory failed: %v", err)
+	}
+	if len(commits) != 2 {
+		t.Fatalf("Expected 2 commits, got %d", len(commits))
+	}
+	if got := commits[0].Files; !reflect.DeepEqual(got, []FileChange{{Path: "a.go", Additions: 2, Deletions: 1}}) {
+		t.Errorf("Expected 2 additions and 1 deletion in a.go, got %+v", got)
+	}
+	if got := commits[1]; got.Author != "alice" || got.Email != "alice@example.com" || got.Files[0].Additions != 3 {
+		t.Errorf("Unexpected first commit: %+v", got)
+	}
+
+	if _, err := client.CloneURL(ctx, mustParseRepoRef(t, Bitbucket, "PROJ/missing")); err == nil || !strings.Contains(err.Error(), "no response for") {
+		t.Errorf("Expected the server's error message, got %v", err)
+	}
+	if _, err := client.GetPullRequest(ctx, mustParseRepoRef(t, Bitbucket, "PROJ/app"), 9); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected %v for a missing pull request, got %v", ErrNotFound, err)
+	}
+}
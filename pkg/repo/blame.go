@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BlameRange attributes a contiguous block of lines to the commit that last changed them
+type BlameRange struct {
+	StartLine int
+	EndLine   int
+	Author    string
+	Commit    string
+	// Date is when the commit was authored, if the provider reports it
+	Date time.Time
+}
+
+// Lines returns the number of lines covered by the range
+func (r BlameRange) Lines() int {
+	return r.EndLine - r.StartLine + 1
+}
+
+// FileBlame is the line-level blame of a single file
+type FileBlame struct {
+	Path    string
+	Ranges  []BlameRange
+	Authors map[string]int
+}
+
+// BlameResult describes which authors own the surviving lines of a pull
+// request's files at the pull request's base commit, or another ref
+type BlameResult struct {
+	Ref     string
+	Authors map[string]BlameInfo
+	Files   []FileBlame
+	// Skipped lists the files that do not exist at Ref, such as files added
+	// on a branch that Ref is not on
+	Skipped []string
+}
+
+// errNotAtRef is returned by the providers' blame of a file that does not
+// exist at the ref it is blamed at
+var errNotAtRef = errors.New("file does not exist at ref")
+
+func newBlameResult(ref string) *BlameResult {
+	return &BlameResult{
+		Ref:     ref,
+		Authors: make(map[string]BlameInfo),
+	}
+}
+
+// addFile records the blame of a file and adds its lines to the author totals
+func (r *BlameResult) addFile(path string, ranges []BlameRange) {
+	file := FileBlame{
+		Path:    path,
+		Ranges:  mergeBlameRanges(ranges),
+		Authors: make(map[string]int),
+	}
+
+	for _, rng := range file.Ranges {
+		file.Authors[rng.Author] += rng.Lines()
+
+		info := r.Authors[rng.Author]
+		info.User = rng.Author
+		info.Lines += rng.Lines()
+		r.Authors[rng.Author] = info
+	}
+
+	r.Files = append(r.Files, file)
+}
+
+// mergeBlameRanges sorts ranges by line and joins adjacent ranges from the same commit
+func mergeBlameRanges(ranges []BlameRange) []BlameRange {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].StartLine < ranges[j].StartLine
+	})
+
+	var merged []BlameRange
+	for _, rng := range ranges {
+		if n := len(merged); n > 0 && merged[n-1].Commit == rng.Commit && merged[n-1].EndLine+1 == rng.StartLine {
+			merged[n-1].EndLine = rng.EndLine
+			continue
+		}
+		merged = append(merged, rng)
+	}
+	return merged
+}
+
+// githubBlameQuery fetches the blame of a file at a given commit, and whether
+// the file exists there, as blame fails for missing files
+const githubBlameQuery = `query($owner: String!, $name: String!, $expression: String!, $path: String!, $file: String!) {
+  repository(owner: $owner, name: $name) {
+    file: object(expression: $file) {
+      oid
+    }
+    object(expression: $expression) {
+      ... on Commit {
+        blame(path: $path) {
+          ranges {
+            startingLine
+            endingLine
+            commit {
+              oid
+              authoredDate
+              author {
+                name
+                email
+                user {
+                  login
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+type githubBlameResponse struct {
+	Data struct {
+		Repository *struct {
+			File *struct {
+				OID string `json:"oid"`
+			} `json:"file"`
+			Object *struct {
+				Blame struct {
+					Ranges []struct {
+						StartingLine int `json:"startingLine"`
+						EndingLine   int `json:"endingLine"`
+						Commit       struct {
+							OID          string    `json:"oid"`
+							AuthoredDate time.Time `json:"authoredDate"`
+							Author       struct {
+								Name  string `json:"name"`
+								Email string `json:"email"`
+								User  *struct {
+									Login string `json:"login"`
+								} `json:"user"`
+							} `json:"author"`
+						} `json:"commit"`
+					} `json:"ranges"`
+				} `json:"blame"`
+			} `json:"object"`
+		} `json:"repository"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// githubGraphQLURL derives the GraphQL endpoint from a REST API base URL.
+// GitHub Enterprise serves REST under /api/v3/ and GraphQL under /api/graphql.
+func githubGraphQLURL(base *url.URL) string {
+	u := *base
+	if strings.HasSuffix(u.Path, "/api/v3/") {
+		u.Path = strings.TrimSuffix(u.Path, "v3/") + "graphql"
+	} else {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/graphql"
+	}
+	return u.String()
+}
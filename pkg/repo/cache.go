@@ -0,0 +1,43 @@
+package repo
+
+import (
+	"github.com/andrewweb/hackday/pkg/cache"
+)
+
+// CachingClient is implemented by clients that keep immutable provider data,
+// such as commit details, in an on-disk cache
+type CachingClient interface {
+	// SetCache sets the store used for immutable data (nil disables caching)
+	SetCache(store *cache.Store)
+}
+
+// commitCache holds the store shared by a client's fetches of immutable data.
+// Clients embed it to implement CachingClient.
+type commitCache struct {
+	store *cache.Store
+}
+
+// SetCache sets the store used for immutable data (nil disables caching)
+func (c *commitCache) SetCache(store *cache.Store) {
+	c.store = store
+}
+
+// cached returns the value stored under key, calling fetch on a miss. The
+// result of fetch is stored only when complete reports it is whole, since a
+// list cut short by the item limit must not outlive the limit. Cache failures
+// are not fatal: the value is simply fetched again.
+func cached[T any](c *commitCache, key string, fetch func() (T, error), complete func(T) bool) (T, error) {
+	var value T
+	if found, err := c.store.Get(key, &value); err == nil && found {
+		return value, nil
+	}
+
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+	if complete(value) {
+		c.store.Put(key, value)
+	}
+	return value, nil
+}
@@ -0,0 +1,157 @@
+package repo
+
+import (
+	"strconv"
+	"strings"
+)
+
+// LineRange is a block of lines, numbered from 1, with End inclusive
+type LineRange struct {
+	Start int
+	End   int
+}
+
+// Overlaps reports whether two ranges share a line
+func (r LineRange) Overlaps(other LineRange) bool {
+	return r.Start <= other.End && other.Start <= r.End
+}
+
+// ParseHunks returns the lines of the original file that the hunks of a
+// unified diff cover. A hunk that only adds lines covers the line it follows,
+// so insertions at the same place are seen to overlap.
+func ParseHunks(patch string) []LineRange {
+	var ranges []LineRange
+	for _, line := range strings.Split(patch, "\n") {
+		if r, ok := parseHunkHeader(line); ok {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// parseDiffHunks splits the output of git diff by file, keyed by the file's
+// path after the change (before it, for deleted files)
+func parseDiffHunks(diff string) map[string][]LineRange {
+	hunks := map[string][]LineRange{}
+	var oldPath, file string
+	inHunk := false
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			oldPath, file = "", ""
+			inHunk = false
+		case file != "" && strings.HasPrefix(line, "@@ "):
+			if r, ok := parseHunkHeader(line); ok {
+				hunks[file] = append(hunks[file], r)
+			}
+			inHunk = true
+		case inHunk:
+			// Lines within a hunk are content, even when they look like headers
+		case strings.HasPrefix(line, "--- "):
+			oldPath = strings.TrimPrefix(strings.TrimPrefix(line, "--- "), "a/")
+		case strings.HasPrefix(line, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			if file == "/dev/null" {
+				file = oldPath
+			}
+		}
+	}
+	return hunks
+}
+
+// parseDiffStats counts the lines the output of git diff adds to and deletes
+// from each file, in the order the files appear
+func parseDiffStats(diff string) []FileChange {
+	var files []FileChange
+	inHunk := false
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			// The header names both paths; ---, +++ and rename lines, which
+			// are unambiguous, override them
+			change := FileChange{}
+			header := strings.TrimPrefix(line, "diff --git ")
+			if i := strings.LastIndex(header, " b/"); i >= 0 {
+				change.OldPath = strings.TrimPrefix(header[:i], "a/")
+				change.Path = header[i+len(" b/"):]
+			}
+			files = append(files, change)
+			inHunk = false
+			continue
+		}
+		if len(files) == 0 {
+			continue
+		}
+		file := &files[len(files)-1]
+
+		switch {
+		case inHunk && strings.HasPrefix(line, "+"):
+			file.Additions++
+		case inHunk && strings.HasPrefix(line, "-"):
+			file.Deletions++
+		case strings.HasPrefix(line, "@@ "):
+			inHunk = true
+		case inHunk:
+		case strings.HasPrefix(line, "rename from "):
+			file.OldPath = strings.TrimPrefix(line, "rename from ")
+		case strings.HasPrefix(line, "rename to "):
+			file.Path = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "--- "):
+			if old := strings.TrimPrefix(line, "--- "); old != "/dev/null" {
+				file.OldPath = strings.TrimPrefix(old, "a/")
+			}
+		case strings.HasPrefix(line, "+++ "):
+			if path := strings.TrimPrefix(line, "+++ "); path != "/dev/null" {
+				file.Path = strings.TrimPrefix(path, "b/")
+			} else {
+				file.Path = file.OldPath
+			}
+		}
+	}
+
+	// Only renamed files keep their old path
+	for i := range files {
+		if files[i].OldPath == files[i].Path {
+			files[i].OldPath = ""
+		}
+	}
+	return files
+}
+
+// parseHunkHeader reads the original side of a hunk header such as
+// @@ -12,5 +12,7 @@
+func parseHunkHeader(line string) (LineRange, bool) {
+	if !strings.HasPrefix(line, "@@ -") {
+		return LineRange{}, false
+	}
+	old, _, ok := strings.Cut(strings.TrimPrefix(line, "@@ -"), " ")
+	if !ok {
+		return LineRange{}, false
+	}
+
+	startText, countText, hasCount := strings.Cut(old, ",")
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return LineRange{}, false
+	}
+	count := 1
+	if hasCount {
+		if count, err = strconv.Atoi(countText); err != nil {
+			return LineRange{}, false
+		}
+	}
+
+	return hunkRange(start, count), true
+}
+
+// hunkRange returns the original lines a hunk of count lines from start
+// covers. A hunk that only adds lines covers the line it follows.
+func hunkRange(start, count int) LineRange {
+	if count == 0 {
+		// Lines are added after start, which is 0 at the top of the file
+		if start == 0 {
+			start = 1
+		}
+		return LineRange{Start: start, End: start}
+	}
+	return LineRange{Start: start, End: start + count - 1}
+}
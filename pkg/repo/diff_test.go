@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHunks(t *testing.T) {
+	patch := "@@ -3,4 +3,5 @@ func main() {\n context\n-old\n+new\n+added\n@@ -20 +21 @@\n-x\n+y\n@@ -0,0 +1,2 @@\n+top\n@@ -30,0 +32 @@\n+appended"
+
+	expected := []LineRange{{3, 6}, {20, 20}, {1, 1}, {30, 30}}
+	if got := ParseHunks(patch); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestParseDiffHunks(t *testing.T) {
+	diff := `diff --git a/a.txt b/a.txt
+index 1111111..2222222 100644
+--- a/a.txt
++++ b/a.txt
+@@ -2,2 +2,2 @@
+-two
++TWO
+--- a/not-a-file.txt
++++ b/not-a-file.txt
+@@ -9 +9 @@
+-nine
++NINE
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+--- a/gone.txt
++++ /dev/null
+@@ -1,3 +0,0 @@
+-x
+diff --git a/img.png b/img.png
+Binary files a/img.png and b/img.png differ
+`
+
+	expected := map[string][]LineRange{
+		"a.txt":    {{2, 3}, {9, 9}},
+		"gone.txt": {{1, 3}},
+	}
+	if got := parseDiffHunks(diff); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestParseDiffStats(t *testing.T) {
+	diff := `diff --git a/a.txt b/a.txt
+index 1111111..2222222 100644
+--- a/a.txt
++++ b/a.txt
+@@ -1,3 +1,3 @@
+ one
+--- not a header
++-- still content
++++ added
+diff --git a/old.go b/new.go
+similarity index 100%
+rename from old.go
+rename to new.go
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+--- a/gone.txt
++++ /dev/null
+@@ -1,2 +0,0 @@
+-x
+-y
+diff --git a/img.png b/img.png
+Binary files a/img.png and b/img.png differ
+`
+
+	expected := []FileChange{
+		{Path: "a.txt", Additions: 2, Deletions: 1},
+		{Path: "new.go", OldPath: "old.go"},
+		{Path: "gone.txt", Deletions: 2},
+		{Path: "img.png"},
+	}
+	if got := parseDiffStats(diff); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+}
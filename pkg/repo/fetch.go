@@ -0,0 +1,202 @@
+package repo
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/xanzy/go-gitlab"
+)
+
+// DefaultConcurrency is the number of commit details fetched in parallel
+const DefaultConcurrency = 8
+
+// ConcurrentClient is implemented by clients that fetch commit details in parallel
+type ConcurrentClient interface {
+	// SetConcurrency sets how many requests may be in flight at once
+	SetConcurrency(n int)
+}
+
+// RateLimit is the provider quota reported by a response
+type RateLimit struct {
+	Remaining int
+	Reset     time.Time
+}
+
+// rateGate hands out request slots from the quota the provider last
+// reported and blocks callers once it runs out, until the quota resets
+type rateGate struct {
+	mu        sync.Mutex
+	known     bool
+	remaining int
+	reset     time.Time
+}
+
+// update records the quota reported by a response. Responses can arrive out
+// of order, so within one reset window the lowest remaining count wins.
+func (g *rateGate) update(limit RateLimit) {
+	if limit.Reset.IsZero() {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.known || limit.Reset.After(g.reset) || limit.Remaining < g.remaining {
+		g.known = true
+		g.remaining = limit.Remaining
+		g.reset = limit.Reset
+	}
+}
+
+// wait takes a request slot, sleeping until the quota resets if none are left
+func (g *rateGate) wait(ctx context.Context) error {
+	for {
+		g.mu.Lock()
+		if !g.known || g.remaining > 0 || !time.Now().Before(g.reset) {
+			if g.known && g.remaining > 0 {
+				g.remaining--
+			}
+			g.mu.Unlock()
+			return nil
+		}
+		delay := time.Until(g.reset)
+		g.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		// The new window's quota is unknown until the next response arrives
+		g.mu.Lock()
+		if !time.Now().Before(g.reset) {
+			g.known = false
+		}
+		g.mu.Unlock()
+	}
+}
+
+// fetcher holds the concurrency settings shared by a client's parallel fetches,
+// and the gate their requests pass through. Clients embed it to implement
+// ConcurrentClient.
+type fetcher struct {
+	concurrency int
+	gate        rateGate
+}
+
+func newFetcher() fetcher {
+	return fetcher{concurrency: DefaultConcurrency}
+}
+
+// SetConcurrency sets how many requests may be in flight at once
+func (f *fetcher) SetConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	f.concurrency = n
+}
+
+// fetchAll calls fetch once for every distinct key using a bounded pool of
+// workers. The result at index i belongs to keys[i], regardless of the order
+// fetches complete in; repeated keys share one fetch. The first error cancels
+// the rest.
+func fetchAll[T any](ctx context.Context, f *fetcher, keys []string, fetch func(ctx context.Context, key string) (T, error)) ([]T, error) {
+	var unique []string
+	index := make(map[string]int)
+	for _, key := range keys {
+		if _, ok := index[key]; !ok {
+			index[key] = len(unique)
+			unique = append(unique, key)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]T, len(unique))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	workers := f.concurrency
+	if workers > len(unique) {
+		workers = len(unique)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var err error
+				results[i], err = fetch(ctx, unique[i])
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range unique {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ordered := make([]T, len(keys))
+	for i, key := range keys {
+		ordered[i] = results[index[key]]
+	}
+	return ordered, nil
+}
+
+// githubRateLimit extracts the quota from a GitHub response
+func githubRateLimit(resp *github.Response) RateLimit {
+	if resp == nil {
+		return RateLimit{}
+	}
+	return RateLimit{Remaining: resp.Rate.Remaining, Reset: resp.Rate.Reset.Time}
+}
+
+// gitlabRateLimit extracts the quota from GitLab's RateLimit-* headers,
+// which are only sent when rate limiting is enabled on the instance
+func gitlabRateLimit(resp *gitlab.Response) RateLimit {
+	if resp == nil || resp.Response == nil {
+		return RateLimit{}
+	}
+	return parseRateLimitHeaders(resp.Header, "RateLimit-Remaining", "RateLimit-Reset")
+}
+
+// parseRateLimitHeaders reads a remaining count and a Unix reset time from headers
+func parseRateLimitHeaders(header http.Header, remainingKey, resetKey string) RateLimit {
+	remaining, err := strconv.Atoi(header.Get(remainingKey))
+	if err != nil {
+		return RateLimit{}
+	}
+	reset, err := strconv.ParseInt(header.Get(resetKey), 10, 64)
+	if err != nil {
+		return RateLimit{}
+	}
+	return RateLimit{Remaining: remaining, Reset: time.Unix(reset, 0)}
+}
@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchAll(t *testing.T) {
+	f := newFetcher()
+	f.SetConcurrency(3)
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	var inFlight, maxInFlight int32
+
+	keys := []string{"e", "a", "d", "a", "c", "b", "e"}
+	results, err := fetchAll(context.Background(), &f, keys, func(ctx context.Context, key string) (string, error) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+				break
+			}
+		}
+
+		mu.Lock()
+		calls[key]++
+		mu.Unlock()
+
+		// Finish out of order to check results keep the input order
+		time.Sleep(time.Duration('f'-key[0]) * time.Millisecond)
+		return "sha-" + key, nil
+	})
+	if err != nil {
+		t.Fatalf("fetchAll failed: %v", err)
+	}
+
+	want := []string{"sha-e", "sha-a", "sha-d", "sha-a", "sha-c", "sha-b", "sha-e"}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("Expected %v, got %v", want, results)
+	}
+	for key, n := range calls {
+		if n != 1 {
+			t.Errorf("Expected key %s to be fetched once, got %d", key, n)
+		}
+	}
+	if maxInFlight > 3 {
+		t.Errorf("Expected at most 3 concurrent fetches, got %d", maxInFlight)
+	}
+}
+
+func TestFetchAll_Error(t *testing.T) {
+	f := newFetcher()
+	failure := errors.New("boom")
+
+	_, err := fetchAll(context.Background(), &f, []string{"a", "b", "c"}, func(ctx context.Context, key string) (int, error) {
+		if key == "b" {
+			return 0, failure
+		}
+		return 1, nil
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("Expected %v, got %v", failure, err)
+	}
+}
+
+func TestRateGate_WaitsForReset(t *testing.T) {
+	var gate rateGate
+	gate.update(RateLimit{Remaining: 1, Reset: time.Now().Add(50 * time.Millisecond)})
+
+	// The first slot is free, the second has to wait for the reset
+	start := time.Now()
+	if err := gate.wait(context.Background()); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	if err := gate.wait(context.Background()); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Expected to wait for the quota reset, waited %v", elapsed)
+	}
+
+	// Cancellation interrupts a wait
+	gate.update(RateLimit{Remaining: 0, Reset: time.Now().Add(time.Hour)})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := gate.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ValidatePatterns checks that every file pattern is well formed
+func ValidatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		for _, segment := range strings.Split(pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid file pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// MatchFile reports whether a repository path matches any of the patterns.
+// Patterns use path.Match syntax per segment, and ** matches any number of
+// directories. A pattern without a slash matches the file name at any depth,
+// so *.go matches cmd/cli/main.go. No patterns match every path.
+func MatchFile(patterns []string, file string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		if matchSegments(strings.Split(pattern, "/"), strings.Split(file, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, file []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try every number of directories the wildcard could stand for
+			for skip := 0; skip <= len(file); skip++ {
+				if matchSegments(pattern[1:], file[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(file) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], file[0]); !ok {
+			return false
+		}
+		pattern, file = pattern[1:], file[1:]
+	}
+	return len(file) == 0
+}
+
+// FilterFiles returns the files matching any of the patterns
+func FilterFiles(files []string, patterns []string) []string {
+	if len(patterns) == 0 {
+		return files
+	}
+	var result []string
+	for _, file := range files {
+		if MatchFile(patterns, file) {
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+// FilterCommits drops the file changes that match none of the patterns, and
+// the commits left without any
+func FilterCommits(commits []Commit, patterns []string) []Commit {
+	if len(patterns) == 0 {
+		return commits
+	}
+	var result []Commit
+	for _, commit := range commits {
+		var files []FileChange
+		for _, file := range commit.Files {
+			if MatchFile(patterns, file.Path) {
+				files = append(files, file)
+			}
+		}
+		if len(files) > 0 {
+			commit.Files = files
+			result = append(result, commit)
+		}
+	}
+	return result
+}
@@ -0,0 +1,41 @@
+package repo
+
+import "testing"
+
+func TestMatchFile(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		file     string
+		want     bool
+	}{
+		{nil, "any/file.go", true},
+		{[]string{"*.go"}, "main.go", true},
+		{[]string{"*.go"}, "cmd/cli/main.go", true},
+		{[]string{"*.go"}, "README.md", false},
+		{[]string{"pkg/*.go"}, "pkg/repo.go", true},
+		{[]string{"pkg/*.go"}, "pkg/repo/repo.go", false},
+		{[]string{"pkg/**/*.go"}, "pkg/repo.go", true},
+		{[]string{"pkg/**/*.go"}, "pkg/repo/repo.go", true},
+		{[]string{"pkg/**"}, "pkg/repo/blame.go", true},
+		{[]string{"docs/**", "*.md"}, "README.md", true},
+		{[]string{"docs/**", "*.md"}, "src/main.c", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchFile(tt.patterns, tt.file); got != tt.want {
+			t.Errorf("MatchFile(%v, %q) = %v; want %v", tt.patterns, tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestFilterCommits(t *testing.T) {
+	commits := []Commit{
+		{SHA: "a", Files: []FileChange{{Path: "main.go"}, {Path: "README.md"}}},
+		{SHA: "b", Files: []FileChange{{Path: "docs/index.md"}}},
+	}
+
+	got := FilterCommits(commits, []string{"*.go"})
+	if len(got) != 1 || got[0].SHA != "a" || len(got[0].Files) != 1 {
+		t.Errorf("Expected commit a with only main.go, got %+v", got)
+	}
+}
@@ -0,0 +1,266 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GiteaClient implements RepositoryClient for Gitea and Forgejo
+type GiteaClient struct {
+	pager
+	fetcher
+	commitCache
+	api restAPI
+}
+
+// NewGiteaClient returns a client for the Gitea instance at baseURL, which
+// sends its requests through httpClient
+func NewGiteaClient(httpClient *http.Client, baseURL string) *GiteaClient {
+	api := restAPI{client: httpClient, baseURL: strings.TrimSuffix(baseURL, "/") + "/api/v1"}
+	return &GiteaClient{pager: newPager(), fetcher: newFetcher(), api: api}
+}
+
+type giteaRepository struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+	CloneURL string `json:"clone_url"`
+}
+
+type giteaPullRequest struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	State   string `json:"state"`
+	HTMLURL string `json:"html_url"`
+	Base    struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	} `json:"base"`
+	Head struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	} `json:"head"`
+}
+
+type giteaChangedFile struct {
+	Filename string `json:"filename"`
+}
+
+type giteaCommit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Author struct {
+			Name  string    `json:"name"`
+			Email string    `json:"email"`
+			Date  time.Time `json:"date"`
+		} `json:"author"`
+	} `json:"commit"`
+}
+
+// listGitea collects a paged list, following the next links of the pages'
+// Link headers
+func listGitea[T any](ctx context.Context, c *GiteaClient, what, path string, query url.Values) ([]T, error) {
+	return paginate(&c.pager, what, func(page int) ([]T, int, error) {
+		pageQuery := url.Values{"limit": {"50"}}
+		for key, values := range query {
+			pageQuery[key] = values
+		}
+		if page > 0 {
+			pageQuery.Set("page", strconv.Itoa(page))
+		}
+
+		var values []T
+		header, err := c.api.getJSONHeader(ctx, c.api.url(path, pageQuery), &values)
+		if err != nil {
+			return nil, 0, err
+		}
+		next, err := url.Parse(nextLink(header))
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid next link: %v", err)
+		}
+		nextPage, _ := strconv.Atoi(next.Query().Get("page"))
+		return values, nextPage, nil
+	})
+}
+
+// repoPath returns the API path of a repository, below which its resources are
+func (c *GiteaClient) repoPath(repoRef RepoRef) (string, error) {
+	owner, repo, err := repoRef.ownerAndName()
+	if err != nil {
+		return "", err
+	}
+	return apiPath("repos", owner, repo), nil
+}
+
+func (c *GiteaClient) ListRepositories(ctx context.Context) ([]Repository, error) {
+	repos, err := listGitea[giteaRepository](ctx, c, "repositories", "/user/repos", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Gitea repositories: %v", err)
+	}
+
+	var result []Repository
+	for _, repo := range repos {
+		result = append(result, Repository{
+			Name:     repo.Name,
+			FullName: repo.FullName,
+			URL:      repo.HTMLURL,
+			Provider: "gitea",
+		})
+	}
+
+	return result, nil
+}
+
+func (c *GiteaClient) ListPullRequests(ctx context.Context, repoRef RepoRef) ([]PullRequest, error) {
+	repoPath, err := c.repoPath(repoRef)
+	if err != nil {
+		return nil, err
+	}
+
+	prs, err := listGitea[giteaPullRequest](ctx, c, "pull requests in "+repoRef.String(), repoPath+"/pulls", url.Values{"state": {"open"}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pull requests: %v", err)
+	}
+
+	return convertPullRequests(ctx, prs, func(pr giteaPullRequest) (*PullRequest, error) {
+		return c.convertPullRequest(ctx, repoPath, pr)
+	})
+}
+
+func (c *GiteaClient) GetPullRequest(ctx context.Context, repoRef RepoRef, number int) (*PullRequest, error) {
+	repoPath, err := c.repoPath(repoRef)
+	if err != nil {
+		return nil, err
+	}
+
+	var pr giteaPullRequest
+	err = c.api.getJSON(ctx, c.api.url(repoPath+"/pulls/"+strconv.Itoa(number), nil), &pr)
+	if isNotFound(err) {
+		return nil, pullRequestNotFound(number)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pull request: %v", err)
+	}
+	return c.convertPullRequest(ctx, repoPath, pr)
+}
+
+func (c *GiteaClient) CloneURL(ctx context.Context, repoRef RepoRef) (string, error) {
+	repoPath, err := c.repoPath(repoRef)
+	if err != nil {
+		return "", err
+	}
+
+	var repo giteaRepository
+	if err := c.api.getJSON(ctx, c.api.url(repoPath, nil), &repo); err != nil {
+		return "", fmt.Errorf("failed to get repository: %v", err)
+	}
+	return repo.CloneURL, nil
+}
+
+// convertPullRequest converts a Gitea pull request, fetching its changed
+// files and diff
+func (c *GiteaClient) convertPullRequest(ctx context.Context, repoPath string, pr giteaPullRequest) (*PullRequest, error) {
+	files, err := listGitea[giteaChangedFile](ctx, c, fmt.Sprintf("files in pull request #%d", pr.Number),
+		fmt.Sprintf("%s/pulls/%d/files", repoPath, pr.Number), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %v", err)
+	}
+	diff, err := c.api.getText(ctx, c.api.url(fmt.Sprintf("%s/pulls/%d.diff", repoPath, pr.Number), nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get diff: %v", err)
+	}
+
+	var changedFiles []string
+	for _, file := range files {
+		changedFiles = append(changedFiles, file.Filename)
+	}
+
+	return &PullRequest{
+		Number:       pr.Number,
+		Title:        pr.Title,
+		State:        pr.State,
+		URL:          pr.HTMLURL,
+		Provider:     "gitea",
+		BaseRef:      pr.Base.Ref,
+		HeadRef:      pr.Head.Ref,
+		HeadSHA:      pr.Head.SHA,
+		ChangedFiles: changedFiles,
+		Hunks:        parseDiffHunks(diff),
+	}, nil
+}
+
+// GetBlameInfo fails, as the Gitea API has no blame endpoint
+func (c *GiteaClient) GetBlameInfo(ctx context.Context, repoRef RepoRef, prNumber int, files []string, window Window) (*BlameResult, error) {
+	return nil, fmt.Errorf("blame is not supported by Gitea")
+}
+
+func (c *GiteaClient) GetCommitHistory(ctx context.Context, repoRef RepoRef, window Window) ([]Commit, error) {
+	repoPath, err := c.repoPath(repoRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// The files and stats of the list are per commit, not per file, so the
+	// list leaves them out and each commit's diff is fetched instead
+	query := url.Values{"stat": {"false"}, "verification": {"false"}, "files": {"false"}}
+	if window.Ref != "" {
+		query.Set("sha", window.Ref)
+	}
+	if !window.Since.IsZero() {
+		query.Set("since", window.Since.Format(time.RFC3339))
+	}
+	if !window.Until.IsZero() {
+		query.Set("until", window.Until.Format(time.RFC3339))
+	}
+	commits, err := listGitea[giteaCommit](ctx, c, "commits in "+repoRef.String(), repoPath+"/commits", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits: %v", err)
+	}
+
+	var shas []string
+	for _, commit := range commits {
+		shas = append(shas, commit.SHA)
+	}
+	progress := newProgressCounter(ctx, PhaseFetchCommits, len(shas))
+	allChanges, err := fetchAll(ctx, &c.fetcher, shas, func(ctx context.Context, sha string) ([]FileChange, error) {
+		changes, err := c.getCommitChanges(ctx, repoPath, sha)
+		if err == nil {
+			progress.add()
+		}
+		return changes, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit diff: %v", err)
+	}
+
+	var result []Commit
+	for i, commit := range commits {
+		result = append(result, Commit{
+			SHA:    commit.SHA,
+			Author: commit.Commit.Author.Name,
+			Email:  commit.Commit.Author.Email,
+			Date:   commit.Commit.Author.Date,
+			Files:  allChanges[i],
+		})
+	}
+
+	return result, nil
+}
+
+// getCommitChanges returns the lines a commit added to and deleted from each
+// file, from the cache when it holds them
+func (c *GiteaClient) getCommitChanges(ctx context.Context, repoPath, sha string) ([]FileChange, error) {
+	key := fmt.Sprintf("gitea:%s%s/commit/%s/changes", c.api.host(), repoPath, sha)
+	return cached(&c.commitCache, key, func() ([]FileChange, error) {
+		diff, err := c.api.getText(ctx, c.api.url(repoPath+"/git/commits/"+url.PathEscape(sha)+".diff", nil))
+		if err != nil {
+			return nil, err
+		}
+		return parseDiffStats(diff), nil
+	}, func([]FileChange) bool { return true })
+}
@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGiteaClient(t *testing.T) {
+	const repoPath = "/api/v1/repos/alice/app"
+	responses := map[string]string{
+		"/api/v1/user/repos":        `[{"name": "app", "full_name": "alice/app", "html_url": "https://gitea.example.com/alice/app"}]`,
+		"/api/v1/user/repos?page=2": `[{"name": "lib", "full_name": "alice/lib"}]`,
+		repoPath:                    `{"name": "app", "clone_url": "https://gitea.example.com/alice/app.git"}`,
+		repoPath + "/pulls": `[{"number": 4, "title": "Add feature", "state": "open", "html_url": "https://gitea.example.com/alice/app/pulls/4",
+			"base": {"ref": "main", "sha": "def456"}, "head": {"ref": "feature", "sha": "abc123"}}]`,
+		repoPath + "/pulls/4/files": `[{"filename": "app.go", "status": "changed"}, {"filename": "new.go", "status": "added"}]`,
+		repoPath + "/pulls/4.diff":  bitbucketTestDiff,
+		repoPath + "/commits": `[
+			{"sha": "c2", "commit": {"author": {"name": "Bob", "email": "bob@example.com", "date": "2024-02-01T00:00:00Z"}}},
+			{"sha": "c1", "commit": {"author": {"name": "Alice", "email": "alice@example.com", "date": "2024-01-01T00:00:00Z"}}}]`,
+		repoPath + "/git/commits/c2.diff": "diff --git a/app.go b/app.go\n--- a/app.go\n+++ b/app.go\n@@ -1 +1,2 @@\n-old\n+new\n+more\n",
+		repoPath + "/git/commits/c1.diff": "diff --git a/app.go b/app.go\nnew file mode 100644\n--- /dev/null\n+++ b/app.go\n@@ -0,0 +1 @@\n+old\n",
+	}
+
+	api := &fakeREST{
+		responses:     responses,
+		paging:        []string{"page"},
+		authorization: "token secret",
+		headers: map[string]http.Header{"/api/v1/user/repos": {"Link": {
+			`<{{url}}/api/v1/user/repos?limit=50&page=2>; rel="next", <{{url}}/api/v1/user/repos?limit=50&page=2>; rel="last"`}}},
+	}
+	server := api.start(t)
+	client := NewGiteaClient(api.client(), server.URL+"/")
+	ctx := context.Background()
+
+	repos, err := client.ListRepositories(ctx)
+	if err != nil {
+		t.Fatalf("ListRepositories failed: %v", err)
+	}
+	if len(repos) != 2 || repos[0].FullName != "alice/app" || repos[1].FullName != "alice/lib" {
+		t.Errorf("Expected alice/app and alice/lib over two pages, got %+v", repos)
+	}
+
+	prs, err := client.ListPullRequests(ctx, mustParseRepoRef(t, Gitea, "alice/app"))
+	if err != nil {
+		t.Fatalf("ListPullRequests failed: %v", err)
+	}
+	if len(prs) != 1 {
+		t.Fatalf("Expected 1 pull request, got %d", len(prs))
+	}
+	pr := prs[0]
+	if pr.Number != 4 || pr.BaseRef != "main" || pr.HeadRef != "feature" || pr.HeadSHA != "abc123" {
+		t.Errorf("Unexpected pull request: %+v", pr)
+	}
+	if !reflect.DeepEqual(pr.ChangedFiles, []string{"app.go", "new.go"}) {
+		t.Errorf("Expected app.go and new.go, got %v", pr.ChangedFiles)
+	}
+	expectedHunks := map[string][]LineRange{"app.go": {{10, 12}}, "new.go": {{1, 1}}}
+	if !reflect.DeepEqual(pr.Hunks, expectedHunks) {
+		t.Errorf("Expected hunks %v, got %v", expectedHunks, pr.Hunks)
+	}
+
+	cloneURL, err := client.CloneURL(ctx, mustParseRepoRef(t, Gitea, "alice/app"))
+	if err != nil {
+		t.Fatalf("CloneURL failed: %v", err)
+	}
+	if cloneURL != "https://gitea.example.com/alice/app.git" {
+		t.Errorf("Unexpected clone URL: %s", cloneURL)
+	}
+
+	window := Window{Since: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), Ref: "main"}
+	commits, err := client.GetCommitHistory(ctx, mustParseRepoRef(t, Gitea, "alice/app"), window)
+	if err != nil {
+		t.Fatalf("GetCommitHistory failed: %v", err)
+	}
+	expected := []Commit{
+		{SHA: "c2", Author: "Bob", Email: "bob@example.com", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			Files: []FileChange{{Path: "app.go", Additions: 2, Deletions: 1}}},
+		{SHA: "c1", Author: "Alice", Email: "alice@example.com", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			Files: []FileChange{{Path: "app.go", Additions: 1}}},
+	}
+	if !reflect.DeepEqual(commits, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, commits)
+	}
+	query := api.query(repoPath + "/commits")
+	if want := "files=false&limit=50&sha=main&since=2023-12-01T00%3A00%3A00Z&stat=false&verification=false"; query != want {
+		t.Errorf("Expected commits query %s, got %s", want, query)
+	}
+
+	if _, err := client.GetPullRequest(ctx, mustParseRepoRef(t, Gitea, "alice/missing"), 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected %v for a missing pull request, got %v", ErrNotFound, err)
+	}
+}
@@ -0,0 +1,455 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalClient implements RepositoryClient for a git working copy on disk.
+// Branches other than the base branch are reported as pull requests.
+type LocalClient struct {
+	pager
+	path string
+}
+
+func NewLocalClient(path string) *LocalClient {
+	return &LocalClient{pager: newPager(), path: path}
+}
+
+func (c *LocalClient) ListRepositories(ctx context.Context) ([]Repository, error) {
+	root, err := c.git(ctx, c.path, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local repository: %v", err)
+	}
+	root = strings.TrimSpace(root)
+
+	url := "file://" + root
+	if remote, err := c.git(ctx, root, "config", "--get", "remote.origin.url"); err == nil && strings.TrimSpace(remote) != "" {
+		url = strings.TrimSpace(remote)
+	}
+
+	return []Repository{{
+		Name:     filepath.Base(root),
+		FullName: root,
+		URL:      url,
+		Provider: "local",
+	}}, nil
+}
+
+// ListPullRequests describes each branch but the default branch, numbered
+// in the order of their names. Numbers shift as branches are created and
+// deleted, so callers that keep them should name branches to CompareRefs
+// instead.
+func (c *LocalClient) ListPullRequests(ctx context.Context, repoRef RepoRef) ([]PullRequest, error) {
+	dir := repoRef.String()
+	base, branches, err := c.branches(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []PullRequest
+	for i, branch := range branches {
+		if limit := c.limit(); limit > 0 && len(result) == limit {
+			c.markTruncated("branches in %s (stopped at %d items)", dir, limit)
+			break
+		}
+
+		pr, err := c.compare(ctx, dir, base, branch)
+		if err != nil {
+			return nil, err
+		}
+		pr.Number = i + 1
+		result = append(result, *pr)
+	}
+
+	return result, nil
+}
+
+// GetPullRequest describes the branch ListPullRequests numbers number,
+// without comparing the other branches
+func (c *LocalClient) GetPullRequest(ctx context.Context, repoRef RepoRef, number int) (*PullRequest, error) {
+	dir := repoRef.String()
+	base, branches, err := c.branches(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	if number < 1 || number > len(branches) {
+		return nil, pullRequestNotFound(number)
+	}
+
+	pr, err := c.compare(ctx, dir, base, branches[number-1])
+	if err != nil {
+		return nil, err
+	}
+	pr.Number = number
+	return pr, nil
+}
+
+// CompareRefs describes the difference between two refs as a pull request
+// without a number. An empty base stands for the default branch, and a ref
+// that does not exist fails with an error that wraps ErrNotFound.
+func (c *LocalClient) CompareRefs(ctx context.Context, repoRef RepoRef, base, head string) (*PullRequest, error) {
+	dir := repoRef.String()
+	if base == "" {
+		var err error
+		if base, err = c.defaultBranch(ctx, dir); err != nil {
+			return nil, err
+		}
+	}
+	return c.compare(ctx, dir, base, head)
+}
+
+// branches returns the default branch and the others, by name
+func (c *LocalClient) branches(ctx context.Context, dir string) (string, []string, error) {
+	base, err := c.defaultBranch(ctx, dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	output, err := c.git(ctx, dir, "for-each-ref", "--format=%(refname:short)", "refs/heads")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list branches: %v", err)
+	}
+
+	var branches []string
+	for _, branch := range strings.Split(strings.TrimSpace(output), "\n") {
+		if branch != "" && branch != base {
+			branches = append(branches, branch)
+		}
+	}
+	return base, branches, nil
+}
+
+// compare describes the changes of head since it left base. Both are
+// resolved to commits first, so neither is taken for an option.
+func (c *LocalClient) compare(ctx context.Context, dir, base, head string) (*PullRequest, error) {
+	baseSHA, err := c.resolve(ctx, dir, base)
+	if err != nil {
+		return nil, err
+	}
+	headSHA, err := c.resolve(ctx, dir, head)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := c.git(ctx, dir, "diff", "--name-only", baseSHA+"..."+headSHA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %v", err)
+	}
+
+	var changedFiles []string
+	for _, file := range strings.Split(strings.TrimSpace(output), "\n") {
+		if file != "" {
+			changedFiles = append(changedFiles, file)
+		}
+	}
+
+	diff, err := c.git(ctx, dir, "diff", "--no-color", "--src-prefix=a/", "--dst-prefix=b/", baseSHA+"..."+headSHA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get diff: %v", err)
+	}
+
+	return &PullRequest{
+		Title:        head,
+		State:        "open",
+		URL:          "file://" + dir,
+		Provider:     "local",
+		BaseRef:      base,
+		HeadRef:      head,
+		HeadSHA:      headSHA,
+		ChangedFiles: changedFiles,
+		Hunks:        parseDiffHunks(diff),
+	}, nil
+}
+
+// resolve returns the commit a ref names, failing with an error that wraps
+// ErrNotFound when there is none
+func (c *LocalClient) resolve(ctx context.Context, dir, ref string) (string, error) {
+	sha, err := c.git(ctx, dir, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", fmt.Errorf("ref %s %w", ref, ErrNotFound)
+	}
+	return strings.TrimSpace(sha), nil
+}
+
+func (c *LocalClient) GetBlameInfo(ctx context.Context, repoRef RepoRef, prNumber int, files []string, window Window) (*BlameResult, error) {
+	pr, err := c.GetPullRequest(ctx, repoRef, prNumber)
+	if err != nil {
+		return nil, err
+	}
+	return c.BlamePullRequest(ctx, repoRef, pr, files, window)
+}
+
+// BlamePullRequest blames files like GetBlameInfo, for a pull request from
+// GetPullRequest or CompareRefs
+func (c *LocalClient) BlamePullRequest(ctx context.Context, repoRef RepoRef, pr *PullRequest, files []string, window Window) (*BlameResult, error) {
+	dir := repoRef.String()
+	baseTip, err := c.resolve(ctx, dir, pr.BaseRef)
+	if err != nil {
+		return nil, err
+	}
+	baseSHA, err := c.git(ctx, dir, "merge-base", baseTip, pr.HeadSHA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find merge base: %v", err)
+	}
+	baseSHA = strings.TrimSpace(baseSHA)
+	ref, at := baseSHA, baseSHA
+	if window.Ref != "" {
+		// Files missing at the ref are skipped, so the ref itself must exist.
+		// It is blamed at the commit it resolves to, so it is never taken for
+		// an option.
+		if at, err = c.resolve(ctx, dir, window.Ref); err != nil {
+			return nil, err
+		}
+		ref = window.Ref
+	}
+
+	// Map each file to its path at the base commit, skipping files the branch adds
+	output, err := c.git(ctx, dir, "diff", "--name-status", "-M", baseSHA, pr.HeadSHA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %v", err)
+	}
+	basePaths := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Split(line, "\t")
+		switch {
+		case len(fields) < 2, strings.HasPrefix(fields[0], "A"):
+		case strings.HasPrefix(fields[0], "R") && len(fields) == 3:
+			basePaths[fields[2]] = fields[1]
+		default:
+			basePaths[fields[1]] = fields[1]
+		}
+	}
+
+	result := newBlameResult(ref)
+	reportBlameProgress(ctx, result, 0, len(files))
+	for i, filename := range files {
+		basePath, ok := basePaths[filename]
+		if !ok {
+			continue
+		}
+		if at != baseSHA {
+			if _, err := c.git(ctx, dir, "cat-file", "-e", at+":"+basePath); err != nil {
+				result.Skipped = append(result.Skipped, filename)
+				continue
+			}
+		}
+
+		output, err := c.git(ctx, dir, "blame", "--porcelain", at, "--", basePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to blame file %s: %v", filename, err)
+		}
+		result.addFile(filename, window.filter(parseBlamePorcelain(output)))
+		reportBlameProgress(ctx, result, i+1, len(files))
+	}
+
+	return result, nil
+}
+
+func (c *LocalClient) GetCommitHistory(ctx context.Context, repoRef RepoRef, window Window) ([]Commit, error) {
+	dir := repoRef.String()
+	args := []string{"log", "--numstat", "-M", "--pretty=format:%x1e%H%x1f%aI%x1f%aN%x1f%aE"}
+
+	// Ask for one commit more than the limit to detect truncation
+	limit := c.limit()
+	if limit > 0 {
+		args = append(args, fmt.Sprintf("--max-count=%d", limit+1))
+	}
+	args = append(args, window.LogArgs()...)
+
+	output, err := c.git(ctx, dir, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run git log: %v", err)
+	}
+
+	commits, err := parseGitLog(output)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(commits) > limit {
+		c.markTruncated("commits in %s (stopped at %d items)", dir, limit)
+		commits = commits[:limit]
+	}
+	return commits, nil
+}
+
+// CloneURL returns the absolute path of the repository's git directory
+func (c *LocalClient) CloneURL(ctx context.Context, repoRef RepoRef) (string, error) {
+	dir := repoRef.String()
+	dir, err := c.git(ctx, dir, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return "", fmt.Errorf("failed to open local repository: %v", err)
+	}
+	return strings.TrimSpace(dir), nil
+}
+
+// defaultBranch returns the branch pull requests are compared against
+func (c *LocalClient) defaultBranch(ctx context.Context, dir string) (string, error) {
+	// Prefer the branch the origin remote points at, as a mirror would
+	if ref, err := c.git(ctx, dir, "symbolic-ref", "--short", "refs/remotes/origin/HEAD"); err == nil {
+		return strings.TrimPrefix(strings.TrimSpace(ref), "origin/"), nil
+	}
+
+	ref, err := c.git(ctx, dir, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("failed to determine default branch: %v", err)
+	}
+	return strings.TrimSpace(ref), nil
+}
+
+// git runs a git command in dir and returns its standard output
+func (c *LocalClient) git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
+
+// parseBlamePorcelain converts `git blame --porcelain` output into line ranges
+func parseBlamePorcelain(output string) []BlameRange {
+	authors := make(map[string]string)
+	dates := make(map[string]time.Time)
+	var ranges []BlameRange
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			// Content line; the header before it already counted it
+		case strings.HasPrefix(line, "author "):
+			if n := len(ranges); n > 0 {
+				authors[ranges[n-1].Commit] = strings.TrimPrefix(line, "author ")
+			}
+		case strings.HasPrefix(line, "author-time "):
+			if n := len(ranges); n > 0 {
+				if seconds, err := strconv.ParseInt(strings.TrimPrefix(line, "author-time "), 10, 64); err == nil {
+					dates[ranges[n-1].Commit] = time.Unix(seconds, 0)
+				}
+			}
+		default:
+			// Group headers are "<sha> <orig-line> <final-line> <num-lines>"
+			fields := strings.Fields(line)
+			if len(fields) != 4 || len(fields[0]) != 40 {
+				continue
+			}
+			start, err1 := strconv.Atoi(fields[2])
+			count, err2 := strconv.Atoi(fields[3])
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			ranges = append(ranges, BlameRange{
+				StartLine: start,
+				EndLine:   start + count - 1,
+				Commit:    fields[0],
+			})
+		}
+	}
+
+	// Author details are only printed the first time a commit appears
+	for i := range ranges {
+		ranges[i].Author = authors[ranges[i].Commit]
+		ranges[i].Date = dates[ranges[i].Commit]
+		if ranges[i].Author == "" {
+			ranges[i].Author = "Unknown Author"
+		}
+	}
+
+	return ranges
+}
+
+// parseGitLog parses `git log --numstat` output produced with the
+// %x1e%H%x1f%aI%x1f%aN%x1f%aE pretty format
+func parseGitLog(output string) ([]Commit, error) {
+	var result []Commit
+	for _, record := range strings.Split(output, "\x1e") {
+		if strings.TrimSpace(record) == "" {
+			continue
+		}
+
+		lines := strings.Split(record, "\n")
+		header := strings.Split(lines[0], "\x1f")
+		if len(header) != 4 {
+			return nil, fmt.Errorf("unexpected git log header: %q", lines[0])
+		}
+
+		date, err := time.Parse(time.RFC3339, header[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid commit date %q: %v", header[1], err)
+		}
+
+		commit := Commit{
+			SHA:    header[0],
+			Date:   date,
+			Author: header[2],
+			Email:  header[3],
+		}
+
+		for _, line := range lines[1:] {
+			fields := strings.SplitN(line, "\t", 3)
+			if len(fields) != 3 {
+				continue
+			}
+
+			// Binary files are reported as "-" and count as no line changes
+			added, _ := strconv.Atoi(fields[0])
+			deleted, _ := strconv.Atoi(fields[1])
+			oldPath, newPath := parseNumstatPath(fields[2])
+			change := FileChange{
+				Path:      newPath,
+				Additions: added,
+				Deletions: deleted,
+			}
+			if oldPath != newPath {
+				change.OldPath = oldPath
+			}
+			commit.Files = append(commit.Files, change)
+		}
+
+		result = append(result, commit)
+	}
+
+	return result, nil
+}
+
+// parseNumstatPath splits a numstat path into its old and new names. Renames
+// are reported either as "old => new" or as "dir/{old => new}/file".
+func parseNumstatPath(path string) (string, string) {
+	if !strings.Contains(path, " => ") {
+		return path, path
+	}
+
+	start := strings.Index(path, "{")
+	end := strings.LastIndex(path, "}")
+	if start < 0 || end < start {
+		parts := strings.SplitN(path, " => ", 2)
+		return parts[0], parts[1]
+	}
+
+	prefix, suffix := path[:start], path[end+1:]
+	parts := strings.SplitN(path[start+1:end], " => ", 2)
+	join := func(middle string) string {
+		// An empty side such as "{ => sub}/file" must not leave a double slash
+		return strings.ReplaceAll(prefix+middle+suffix, "//", "/")
+	}
+	return join(parts[0]), join(parts[1])
+}
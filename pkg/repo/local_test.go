@@ -0,0 +1,250 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestRepo creates a git repository with a main branch and one feature branch
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	run := func(author string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME="+author, "GIT_AUTHOR_EMAIL="+author+"@example.com",
+			"GIT_COMMITTER_NAME="+author, "GIT_COMMITTER_EMAIL="+author+"@example.com",
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+	}
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	run("alice", "init", "-q", "-b", "main")
+	write("a.txt", "one\ntwo\nthree\n")
+	run("alice", "add", ".")
+	run("alice", "commit", "-q", "-m", "initial")
+
+	write("a.txt", "one\ntwo\nTHREE\nfour\n")
+	run("bob", "commit", "-q", "-am", "edit")
+
+	run("bob", "checkout", "-q", "-b", "feature")
+	write("b.txt", "new\n")
+	write("a.txt", "one\n")
+	run("bob", "add", ".")
+	run("bob", "commit", "-q", "-m", "feature")
+	run("bob", "checkout", "-q", "main")
+
+	return dir
+}
+
+func TestLocalClient_ListPullRequests(t *testing.T) {
+	dir := newTestRepo(t)
+	client := NewLocalClient(dir)
+
+	prs, err := client.ListPullRequests(context.Background(), RepoRef{Name: dir})
+	if err != nil {
+		t.Fatalf("ListPullRequests failed: %v", err)
+	}
+	if len(prs) != 1 {
+		t.Fatalf("Expected 1 pull request, got %d", len(prs))
+	}
+
+	pr := prs[0]
+	if pr.Number != 1 || pr.BaseRef != "main" || pr.HeadRef != "feature" {
+		t.Errorf("Unexpected pull request: %+v", pr)
+	}
+	if len(pr.ChangedFiles) != 2 {
+		t.Errorf("Expected 2 changed files, got %v", pr.ChangedFiles)
+	}
+	if len(pr.HeadSHA) != 40 {
+		t.Errorf("Expected the head commit SHA, got %q", pr.HeadSHA)
+	}
+}
+
+func TestLocalClient_CompareRefs(t *testing.T) {
+	dir := newTestRepo(t)
+	client := NewLocalClient(dir)
+	ref := RepoRef{Name: dir}
+	ctx := context.Background()
+
+	// The default branch is the base when none is given
+	pr, err := client.CompareRefs(ctx, ref, "", "feature")
+	if err != nil {
+		t.Fatalf("CompareRefs failed: %v", err)
+	}
+	if pr.Number != 0 || pr.BaseRef != "main" || pr.HeadRef != "feature" || len(pr.ChangedFiles) != 2 {
+		t.Errorf("Unexpected pull request: %+v", pr)
+	}
+
+	result, err := client.BlamePullRequest(ctx, ref, pr, []string{"a.txt"}, Window{})
+	if err != nil {
+		t.Fatalf("BlamePullRequest failed: %v", err)
+	}
+	if result.Authors["alice"].Lines != 2 || result.Authors["bob"].Lines != 2 {
+		t.Errorf("Unexpected authors: %+v", result.Authors)
+	}
+
+	for _, head := range []string{"missing", "--output=/tmp/x"} {
+		if _, err := client.CompareRefs(ctx, ref, "", head); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected %v for %s, got %v", ErrNotFound, head, err)
+		}
+	}
+	if _, err := client.GetPullRequest(ctx, ref, 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected %v for a second branch, got %v", ErrNotFound, err)
+	}
+}
+
+func TestLocalClient_GetBlameInfo(t *testing.T) {
+	dir := newTestRepo(t)
+	client := NewLocalClient(dir)
+
+	// b.txt only exists on the feature branch and must be skipped
+	result, err := client.GetBlameInfo(context.Background(), RepoRef{Name: dir}, 1, []string{"a.txt", "b.txt"}, Window{})
+	if err != nil {
+		t.Fatalf("GetBlameInfo failed: %v", err)
+	}
+
+	if result.Authors["alice"].Lines != 2 {
+		t.Errorf("Expected alice to own 2 lines, got %d", result.Authors["alice"].Lines)
+	}
+	if result.Authors["bob"].Lines != 2 {
+		t.Errorf("Expected bob to own 2 lines, got %d", result.Authors["bob"].Lines)
+	}
+
+	if len(result.Files) != 1 {
+		t.Fatalf("Expected blame for 1 file, got %d", len(result.Files))
+	}
+	ranges := result.Files[0].Ranges
+	if len(ranges) != 2 {
+		t.Fatalf("Expected 2 blame ranges, got %+v", ranges)
+	}
+	if ranges[0].StartLine != 1 || ranges[0].EndLine != 2 || ranges[0].Author != "alice" {
+		t.Errorf("Unexpected first range: %+v", ranges[0])
+	}
+	if ranges[1].StartLine != 3 || ranges[1].EndLine != 4 || ranges[1].Author != "bob" {
+		t.Errorf("Unexpected second range: %+v", ranges[1])
+	}
+}
+
+func TestLocalClient_GetBlameInfoAtRef(t *testing.T) {
+	dir := newTestRepo(t)
+	client := NewLocalClient(dir)
+
+	// A tag of a commit with an empty tree, where a.txt does not exist
+	cmd := exec.Command("sh", "-c", "git update-ref refs/tags/empty $(git commit-tree $(git mktree </dev/null) -m empty)")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=carol", "GIT_AUTHOR_EMAIL=carol@example.com",
+		"GIT_COMMITTER_NAME=carol", "GIT_COMMITTER_EMAIL=carol@example.com")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to create the empty tag: %v\n%s", err, output)
+	}
+
+	result, err := client.GetBlameInfo(context.Background(), RepoRef{Name: dir}, 1, []string{"a.txt", "b.txt"}, Window{Ref: "empty"})
+	if err != nil {
+		t.Fatalf("GetBlameInfo failed: %v", err)
+	}
+	if len(result.Files) != 0 || !reflect.DeepEqual(result.Skipped, []string{"a.txt"}) {
+		t.Errorf("Expected a.txt to be skipped, got %+v", result)
+	}
+
+	for _, ref := range []string{"missing", "--contents=/dev/null"} {
+		if _, err := client.GetBlameInfo(context.Background(), RepoRef{Name: dir}, 1, []string{"a.txt"}, Window{Ref: ref}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected %v for %s, got %v", ErrNotFound, ref, err)
+		}
+	}
+}
+
+func TestLocalClient_GetBlameInfoProgress(t *testing.T) {
+	dir := newTestRepo(t)
+	client := NewLocalClient(dir)
+
+	var reports []Progress
+	ctx := WithProgress(context.Background(), func(p Progress) {
+		reports = append(reports, p)
+	})
+	if _, err := client.GetBlameInfo(ctx, RepoRef{Name: dir}, 1, []string{"a.txt"}, Window{}); err != nil {
+		t.Fatalf("GetBlameInfo failed: %v", err)
+	}
+
+	if len(reports) != 2 {
+		t.Fatalf("Expected 2 progress reports, got %+v", reports)
+	}
+	last := reports[1]
+	if last.Phase != PhaseBlame || last.Done != 1 || last.Total != 1 {
+		t.Errorf("Unexpected progress: %+v", last)
+	}
+	if last.Authors["alice"] != 2 || last.Authors["bob"] != 2 {
+		t.Errorf("Expected partial author totals, got %v", last.Authors)
+	}
+}
+
+func TestLocalClient_GetCommitHistory(t *testing.T) {
+	dir := newTestRepo(t)
+	client := NewLocalClient(dir)
+
+	commits, err := client.GetCommitHistory(context.Background(), RepoRef{Name: dir}, Window{})
+	if err != nil {
+		t.Fatalf("GetCommitHistory failed: %v", err)
+	}
+	if len(commits) != 3 {
+		t.Fatalf("Expected 3 commits, got %d", len(commits))
+	}
+
+	// Commits are listed newest first
+	latest := commits[0]
+	if latest.Author != "bob" || len(latest.Files) != 2 {
+		t.Errorf("Unexpected latest commit: %+v", latest)
+	}
+
+	// A ref limits the history to one branch
+	commits, err = client.GetCommitHistory(context.Background(), RepoRef{Name: dir}, Window{Ref: "main"})
+	if err != nil {
+		t.Fatalf("GetCommitHistory failed: %v", err)
+	}
+	if len(commits) != 2 {
+		t.Errorf("Expected 2 commits on main, got %d", len(commits))
+	}
+
+	// Every commit is older than an hour from now
+	commits, err = client.GetCommitHistory(context.Background(), RepoRef{Name: dir}, Window{Since: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("GetCommitHistory failed: %v", err)
+	}
+	if len(commits) != 0 {
+		t.Errorf("Expected no commits in the future, got %d", len(commits))
+	}
+}
+
+func TestParseNumstatPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		oldPath string
+		newPath string
+	}{
+		{"a.txt", "a.txt", "a.txt"},
+		{"a.txt => b.txt", "a.txt", "b.txt"},
+		{"src/{a => b}/file.go", "src/a/file.go", "src/b/file.go"},
+		{"src/{ => sub}/file.go", "src/file.go", "src/sub/file.go"},
+	}
+
+	for _, tt := range tests {
+		oldPath, newPath := parseNumstatPath(tt.path)
+		if oldPath != tt.oldPath || newPath != tt.newPath {
+			t.Errorf("parseNumstatPath(%q) = %q, %q; want %q, %q", tt.path, oldPath, newPath, tt.oldPath, tt.newPath)
+		}
+	}
+}
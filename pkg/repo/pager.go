@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DefaultMaxItems is the number of items a single list call collects unless
+// the client is configured otherwise
+const DefaultMaxItems = 1000
+
+// pager holds the item limit shared by a client's list calls and records
+// which lists were cut short by it. Clients embed it to implement the
+// SetMaxItems and Truncated methods of RepositoryClient.
+type pager struct {
+	mu        sync.Mutex
+	maxItems  int
+	truncated []string
+}
+
+func newPager() pager {
+	return pager{maxItems: DefaultMaxItems}
+}
+
+// SetMaxItems limits how many items each list call collects. A value of zero
+// or less removes the limit.
+func (p *pager) SetMaxItems(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	p.maxItems = n
+}
+
+// Truncated describes every list that stopped at the item limit before the
+// provider ran out of pages
+func (p *pager) Truncated() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.truncated...)
+}
+
+// limit returns the configured item limit, or 0 when unlimited
+func (p *pager) limit() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.maxItems
+}
+
+// below reports whether a list of n items stayed below the pager's limit, and
+// so cannot have been truncated by it
+func (p *pager) below(n int) bool {
+	limit := p.limit()
+	return limit == 0 || n < limit
+}
+
+func (p *pager) markTruncated(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.truncated = append(p.truncated, fmt.Sprintf(format, args...))
+}
+
+// paginate calls fetch for successive pages, starting with page 0 (the
+// provider default), until fetch reports no next page or the pager limit is
+// reached. what names the list in truncation reports.
+func paginate[T any](p *pager, what string, fetch func(page int) ([]T, int, error)) ([]T, error) {
+	limit := p.limit()
+
+	var items []T
+	page := 0
+	for {
+		batch, nextPage, err := fetch(page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, batch...)
+
+		if limit > 0 && len(items) >= limit {
+			if len(items) > limit || nextPage != 0 {
+				p.markTruncated("%s (stopped at %d items)", what, limit)
+			}
+			return items[:limit], nil
+		}
+		if nextPage == 0 {
+			return items, nil
+		}
+		page = nextPage
+	}
+}
@@ -0,0 +1,56 @@
+package repo
+
+import (
+	"reflect"
+	"testing"
+)
+
+// pagesOf serves items in pages of size, numbering pages from 1 like the providers
+func pagesOf(items []int, size int) func(page int) ([]int, int, error) {
+	return func(page int) ([]int, int, error) {
+		if page == 0 {
+			page = 1
+		}
+		start := (page - 1) * size
+		end := start + size
+		if end >= len(items) {
+			return items[start:], 0, nil
+		}
+		return items[start:end], page + 1, nil
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6, 7}
+
+	tests := []struct {
+		name      string
+		maxItems  int
+		want      []int
+		truncated bool
+	}{
+		{name: "no limit", maxItems: 0, want: items},
+		{name: "limit above total", maxItems: 10, want: items},
+		{name: "limit equal to total", maxItems: 7, want: items},
+		{name: "limit within a page", maxItems: 4, want: []int{1, 2, 3, 4}, truncated: true},
+		{name: "limit on a page boundary", maxItems: 3, want: []int{1, 2, 3}, truncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPager()
+			p.SetMaxItems(tt.maxItems)
+
+			got, err := paginate(&p, "numbers", pagesOf(items, 3))
+			if err != nil {
+				t.Fatalf("paginate failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			if truncated := len(p.Truncated()) > 0; truncated != tt.truncated {
+				t.Errorf("Expected truncated %v, got %v (%v)", tt.truncated, truncated, p.Truncated())
+			}
+		})
+	}
+}
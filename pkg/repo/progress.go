@@ -0,0 +1,72 @@
+package repo
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Phases an analysis reports progress for
+const (
+	PhaseAuth             = "auth"
+	PhaseListPullRequests = "listing-pull-requests"
+	PhaseBlame            = "blaming-files"
+	PhaseFetchCommits     = "fetching-commits"
+	PhaseClone            = "cloning"
+	PhaseAnalysis         = "running-analysis"
+)
+
+// Progress describes how far an analysis has got
+type Progress struct {
+	Phase string
+	// Done and Total count the items of the phase, when known
+	Done  int
+	Total int
+	// Authors holds the lines blamed on each author so far
+	Authors map[string]int
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context whose operations report their progress to
+// fn. fn may be called from several goroutines at once.
+func WithProgress(ctx context.Context, fn func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// ReportProgress reports progress to the function attached to ctx, if any
+func ReportProgress(ctx context.Context, p Progress) {
+	if fn, ok := ctx.Value(progressKey{}).(func(Progress)); ok {
+		fn(p)
+	}
+}
+
+// reportBlameProgress reports a file blamed, with the totals so far
+func reportBlameProgress(ctx context.Context, result *BlameResult, done, total int) {
+	if ctx.Value(progressKey{}) == nil {
+		return
+	}
+	authors := make(map[string]int, len(result.Authors))
+	for author, info := range result.Authors {
+		authors[author] = info.Lines
+	}
+	ReportProgress(ctx, Progress{Phase: PhaseBlame, Done: done, Total: total, Authors: authors})
+}
+
+// progressCounter reports the items of a phase as they finish. It is safe for
+// concurrent use.
+type progressCounter struct {
+	ctx   context.Context
+	phase string
+	total int
+	done  atomic.Int64
+}
+
+// newProgressCounter reports the start of a phase of total items
+func newProgressCounter(ctx context.Context, phase string, total int) *progressCounter {
+	ReportProgress(ctx, Progress{Phase: phase, Total: total})
+	return &progressCounter{ctx: ctx, phase: phase, total: total}
+}
+
+func (p *progressCounter) add() {
+	ReportProgress(p.ctx, Progress{Phase: p.phase, Done: int(p.done.Add(1)), Total: p.total})
+}
@@ -3,8 +3,12 @@ package repo
 type ProviderType string
 
 const (
-	GitHub ProviderType = "github"
-	GitLab ProviderType = "gitlab"
+	GitHub    ProviderType = "github"
+	GitLab    ProviderType = "gitlab"
+	Bitbucket ProviderType = "bitbucket"
+	Gitea     ProviderType = "gitea"
+	Azure     ProviderType = "azure"
+	Local     ProviderType = "local"
 )
 
 func (p ProviderType) String() string {
@@ -13,7 +17,7 @@ func (p ProviderType) String() string {
 
 func (p ProviderType) IsValid() bool {
 	switch p {
-	case GitHub, GitLab:
+	case GitHub, GitLab, Bitbucket, Gitea, Azure, Local:
 		return true
 	default:
 		return false
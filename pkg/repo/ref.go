@@ -0,0 +1,195 @@
+package repo
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// RepoRef identifies a repository: by its path, such as owner/repo on GitHub
+// or group/subgroup/project on GitLab, or by the numeric ID GitLab gives
+// each project. ParseRepoRef also accepts the web URL of a repository.
+type RepoRef struct {
+	// Namespace holds the parts of the path before the name: the owner, the
+	// GitLab group and its subgroups, or the Azure DevOps organization and
+	// project
+	Namespace []string
+	Name      string
+	// ID is the numeric ID of a GitLab project named by ID, and 0 otherwise
+	ID int
+	// Host is the host of the URL the reference was parsed from, or "" when
+	// it was parsed from a path
+	Host string
+}
+
+// ParseRepoRef parses a repository of a provider, given as its path
+// (owner/repo, group/subgroup/project on GitLab, organization/project/repo
+// on Azure DevOps), as the numeric ID of a GitLab project, or as a web or
+// HTTPS clone URL. For the local provider, the repository is a path on disk
+// and is kept as the name.
+func ParseRepoRef(provider ProviderType, repository string) (RepoRef, error) {
+	repository = strings.TrimSpace(repository)
+	if repository == "" {
+		return RepoRef{}, fmt.Errorf("repository is empty")
+	}
+	if provider == Local {
+		return RepoRef{Name: repository}, nil
+	}
+
+	var ref RepoRef
+	path := repository
+	if strings.Contains(repository, "://") {
+		u, err := url.Parse(repository)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return RepoRef{}, fmt.Errorf("invalid repository URL: %s", repository)
+		}
+		ref.Host = strings.ToLower(u.Host)
+		if path, err = webRepoPath(provider, u); err != nil {
+			return RepoRef{}, err
+		}
+		// organization.visualstudio.com is the former host of dev.azure.com
+		if provider == Azure && strings.HasSuffix(ref.Host, ".visualstudio.com") {
+			ref.Host = "dev.azure.com"
+		}
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+
+	if provider == GitLab && ref.Host == "" {
+		if id, err := strconv.Atoi(path); err == nil {
+			if id <= 0 {
+				return RepoRef{}, fmt.Errorf("invalid project ID: %s", repository)
+			}
+			ref.ID = id
+			return ref, nil
+		}
+	}
+
+	parts := strings.Split(path, "/")
+	valid := len(parts) >= 2
+	for _, part := range parts {
+		valid = valid && part != ""
+	}
+	switch provider {
+	case GitLab:
+	case Azure:
+		valid = valid && len(parts) == 3
+	default:
+		valid = valid && len(parts) == 2
+	}
+	if !valid {
+		return RepoRef{}, fmt.Errorf("invalid repository name format: %s. Expected format: %s", repository, repoFormat(provider))
+	}
+
+	ref.Namespace = parts[:len(parts)-1]
+	ref.Name = parts[len(parts)-1]
+	return ref, nil
+}
+
+// repoFormat describes the repositories ParseRepoRef accepts for a provider
+func repoFormat(provider ProviderType) string {
+	switch provider {
+	case GitLab:
+		return "group/project, group/subgroup/project or a project ID"
+	case Azure:
+		return "organization/project/repo"
+	default:
+		return "owner/repo"
+	}
+}
+
+// webRepoPath returns the repository path of a web URL, leaving out the
+// page of the repository the URL is for, such as a pull request
+func webRepoPath(provider ProviderType, u *url.URL) (string, error) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch provider {
+	case GitLab:
+		// Pages of a project follow a "-" part
+		for i, part := range parts {
+			if part == "-" {
+				return strings.Join(parts[:i], "/"), nil
+			}
+		}
+		return strings.Join(parts, "/"), nil
+	case Bitbucket:
+		// Bitbucket Server pages are under /projects/KEY/repos/slug, and its
+		// clone URLs under /scm/KEY/slug
+		for i, part := range parts {
+			if part == "projects" && i+3 < len(parts) && parts[i+2] == "repos" {
+				return parts[i+1] + "/" + parts[i+3], nil
+			}
+			if part == "scm" && i+2 < len(parts) {
+				return parts[i+1] + "/" + parts[i+2], nil
+			}
+		}
+	case Azure:
+		// Repositories are at organization/project/_git/repo on dev.azure.com,
+		// and at project/_git/repo on organization.visualstudio.com
+		for i, part := range parts {
+			if part != "_git" || i+1 >= len(parts) {
+				continue
+			}
+			namespace := append([]string(nil), parts[:i]...)
+			if organization, ok := strings.CutSuffix(strings.ToLower(u.Hostname()), ".visualstudio.com"); ok {
+				namespace = append([]string{organization}, namespace...)
+			}
+			// A repository named after its project may leave the project out
+			if len(namespace) == 1 {
+				namespace = append(namespace, parts[i+1])
+			}
+			return strings.Join(append(namespace, parts[i+1]), "/"), nil
+		}
+		return "", fmt.Errorf("invalid repository URL: %s. Expected a URL with /_git/ in its path", u.Redacted())
+	}
+
+	// Other pages of a repository follow its owner and name
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// String returns the path of the repository, or the ID of a GitLab project
+// named by ID
+func (r RepoRef) String() string {
+	if r.ID != 0 {
+		return strconv.Itoa(r.ID)
+	}
+	return strings.Join(append(append([]string(nil), r.Namespace...), r.Name), "/")
+}
+
+// Owner returns the first part of the path: the owner, the top-level GitLab
+// group or the Azure DevOps organization
+func (r RepoRef) Owner() string {
+	if len(r.Namespace) == 0 {
+		return ""
+	}
+	return r.Namespace[0]
+}
+
+// segments returns the parts of the path, failing unless they are laid out
+// as format is, such as owner/repo
+func (r RepoRef) segments(format string) ([]string, error) {
+	if r.ID != 0 || len(r.Namespace) != strings.Count(format, "/") {
+		return nil, fmt.Errorf("invalid repository name format: %s. Expected format: %s", r, format)
+	}
+	return append(append([]string(nil), r.Namespace...), r.Name), nil
+}
+
+// ownerAndName returns the owner and name of a repository named owner/repo
+func (r RepoRef) ownerAndName() (string, string, error) {
+	parts, err := r.segments("owner/repo")
+	if err != nil {
+		return "", "", err
+	}
+	return parts[0], parts[1], nil
+}
+
+// gitlabProject returns the project ID or path the GitLab client identifies
+// the project by
+func (r RepoRef) gitlabProject() interface{} {
+	if r.ID != 0 {
+		return r.ID
+	}
+	return r.String()
+}
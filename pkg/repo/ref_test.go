@@ -0,0 +1,102 @@
+package repo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mustParseRepoRef parses a repository the test knows to be valid
+func mustParseRepoRef(t *testing.T, provider ProviderType, repository string) RepoRef {
+	t.Helper()
+	ref, err := ParseRepoRef(provider, repository)
+	if err != nil {
+		t.Fatalf("ParseRepoRef(%s, %s) failed: %v", provider, repository, err)
+	}
+	return ref
+}
+
+func TestParseRepoRef(t *testing.T) {
+	tests := []struct {
+		provider   ProviderType
+		repository string
+		expected   RepoRef
+		err        string
+	}{
+		{GitHub, "octo/app", RepoRef{Namespace: []string{"octo"}, Name: "app"}, ""},
+		{GitHub, " octo/app.git ", RepoRef{Namespace: []string{"octo"}, Name: "app"}, ""},
+		{GitHub, "https://github.com/octo/app/pull/12", RepoRef{Namespace: []string{"octo"}, Name: "app", Host: "github.com"}, ""},
+		{GitHub, "https://GHE.example.com/octo/app.git", RepoRef{Namespace: []string{"octo"}, Name: "app", Host: "ghe.example.com"}, ""},
+		{GitHub, "octo", RepoRef{}, "Expected format: owner/repo"},
+		{GitHub, "octo/app/extra", RepoRef{}, "Expected format: owner/repo"},
+		{GitHub, "octo//app", RepoRef{}, "Expected format: owner/repo"},
+		{GitHub, "ssh://git@github.com/octo/app", RepoRef{}, "invalid repository URL"},
+		{GitHub, "", RepoRef{}, "repository is empty"},
+		{GitLab, "group/project", RepoRef{Namespace: []string{"group"}, Name: "project"}, ""},
+		{GitLab, "group/sub/team/project", RepoRef{Namespace: []string{"group", "sub", "team"}, Name: "project"}, ""},
+		{GitLab, "278964", RepoRef{ID: 278964}, ""},
+		{GitLab, "0", RepoRef{}, "invalid project ID"},
+		{GitLab, "https://gitlab.com/group/sub/project/-/merge_requests/4", RepoRef{Namespace: []string{"group", "sub"}, Name: "project", Host: "gitlab.com"}, ""},
+		{GitLab, "https://gitlab.example.com/group/sub/project.git", RepoRef{Namespace: []string{"group", "sub"}, Name: "project", Host: "gitlab.example.com"}, ""},
+		{GitLab, "project", RepoRef{}, "Expected format: group/project"},
+		{Bitbucket, "https://bitbucket.org/ws/app/pull-requests/3", RepoRef{Namespace: []string{"ws"}, Name: "app", Host: "bitbucket.org"}, ""},
+		{Bitbucket, "https://bb.example.com/projects/PROJ/repos/app/browse", RepoRef{Namespace: []string{"PROJ"}, Name: "app", Host: "bb.example.com"}, ""},
+		{Bitbucket, "https://bb.example.com/scm/PROJ/app.git", RepoRef{Namespace: []string{"PROJ"}, Name: "app", Host: "bb.example.com"}, ""},
+		{Gitea, "https://gitea.com/alice/app/pulls/2", RepoRef{Namespace: []string{"alice"}, Name: "app", Host: "gitea.com"}, ""},
+		{Azure, "acme/web/app", RepoRef{Namespace: []string{"acme", "web"}, Name: "app"}, ""},
+		{Azure, "acme/app", RepoRef{}, "Expected format: organization/project/repo"},
+		{Azure, "https://dev.azure.com/acme/web/_git/app/pullrequest/7", RepoRef{Namespace: []string{"acme", "web"}, Name: "app", Host: "dev.azure.com"}, ""},
+		{Azure, "https://acme@dev.azure.com/acme/web/_git/app", RepoRef{Namespace: []string{"acme", "web"}, Name: "app", Host: "dev.azure.com"}, ""},
+		{Azure, "https://acme.visualstudio.com/web/_git/app", RepoRef{Namespace: []string{"acme", "web"}, Name: "app", Host: "dev.azure.com"}, ""},
+		{Azure, "https://dev.azure.com/acme/_git/app", RepoRef{Namespace: []string{"acme", "app"}, Name: "app", Host: "dev.azure.com"}, ""},
+		{Azure, "https://dev.azure.com/acme/web", RepoRef{}, "Expected a URL with /_git/"},
+		{Local, "../checkouts/app", RepoRef{Name: "../checkouts/app"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.provider)+" "+tt.repository, func(t *testing.T) {
+			ref, err := ParseRepoRef(tt.provider, tt.repository)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("Expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRepoRef failed: %v", err)
+			}
+			if !reflect.DeepEqual(ref, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, ref)
+			}
+		})
+	}
+}
+
+func TestRepoRef_String(t *testing.T) {
+	tests := []struct {
+		ref      RepoRef
+		expected string
+		owner    string
+	}{
+		{RepoRef{Namespace: []string{"octo"}, Name: "app"}, "octo/app", "octo"},
+		{RepoRef{Namespace: []string{"group", "sub"}, Name: "project"}, "group/sub/project", "group"},
+		{RepoRef{ID: 42}, "42", ""},
+		{RepoRef{Name: "/tmp/app"}, "/tmp/app", ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.ref.String(); got != tt.expected {
+			t.Errorf("Expected %s, got %s", tt.expected, got)
+		}
+		if got := tt.ref.Owner(); got != tt.owner {
+			t.Errorf("Expected owner %q for %s, got %q", tt.owner, tt.expected, got)
+		}
+	}
+
+	if _, _, err := (RepoRef{ID: 42}).ownerAndName(); err == nil {
+		t.Error("Expected a name format error for a project ID")
+	}
+	if project := (RepoRef{ID: 42}).gitlabProject(); project != 42 {
+		t.Errorf("Expected project ID 42, got %v", project)
+	}
+}
@@ -2,7 +2,9 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"sort"
 	"strings"
 	"time"
@@ -11,11 +13,35 @@ import (
 	"github.com/xanzy/go-gitlab"
 )
 
-// RepositoryClient defines the interface for repository operations
+// RepositoryClient defines the interface for repository operations. Every
+// operation stops early and returns the context's error once ctx is done.
 type RepositoryClient interface {
-	ListRepositories() ([]Repository, error)
-	ListPullRequests(repoFullName string) ([]PullRequest, error)
-	GetBlameInfo(repoFullName string, prNumber int, files []string) (map[string]BlameInfo, error)
+	ListRepositories(ctx context.Context) ([]Repository, error)
+	ListPullRequests(ctx context.Context, repoRef RepoRef) ([]PullRequest, error)
+	// GetPullRequest fetches a single pull request, including its changed
+	// files, failing with an error that wraps ErrNotFound when there is none
+	GetPullRequest(ctx context.Context, repoRef RepoRef, number int) (*PullRequest, error)
+	// GetBlameInfo blames files at the pull request's base commit, or at
+	// window.Ref, keeping the lines last changed within the window
+	GetBlameInfo(ctx context.Context, repoRef RepoRef, prNumber int, files []string, window Window) (*BlameResult, error)
+	// GetCommitHistory returns the commits of window.Ref (the default branch
+	// when empty) authored within the window
+	GetCommitHistory(ctx context.Context, repoRef RepoRef, window Window) ([]Commit, error)
+	// CloneURL returns the URL git fetches the repository from
+	CloneURL(ctx context.Context, repoRef RepoRef) (string, error)
+
+	// SetMaxItems caps how many items each list call collects (0 for no limit)
+	SetMaxItems(n int)
+	// Truncated describes the lists that were cut short by the item limit
+	Truncated() []string
+}
+
+// ErrNotFound is wrapped by the errors of lookups that find nothing
+var ErrNotFound = errors.New("not found")
+
+// pullRequestNotFound is the error of a lookup of a missing pull request
+func pullRequestNotFound(number int) error {
+	return fmt.Errorf("pull request #%d %w", number, ErrNotFound)
 }
 
 type Repository struct {
@@ -31,7 +57,14 @@ type PullRequest struct {
 	State        string
 	URL          string
 	Provider     string
+	BaseRef      string
+	HeadRef      string
+	HeadSHA      string // commit the head ref pointed at when fetched
 	ChangedFiles []string
+	// Hunks holds, by changed file, the lines of the base version the diff
+	// touches. Files the provider gave no diff for, such as binary or very
+	// large files, are missing.
+	Hunks map[string][]LineRange
 }
 
 type BlameInfo struct {
@@ -39,21 +72,51 @@ type BlameInfo struct {
 	Lines int
 }
 
+// Commit is a provider-neutral view of a single commit and the files it touched
+type Commit struct {
+	SHA    string
+	Author string
+	Email  string
+	Date   time.Time
+	Files  []FileChange
+}
+
+// FileChange describes the changes a commit made to a single file
+type FileChange struct {
+	Path      string
+	OldPath   string // previous path when the file was renamed
+	Additions int
+	Deletions int
+}
+
+// Renamed reports whether the change moved the file to a new path
+func (f FileChange) Renamed() bool {
+	return f.OldPath != "" && f.OldPath != f.Path
+}
+
 // GitHubClient implements RepositoryClient for GitHub
 type GitHubClient struct {
+	pager
+	fetcher
+	commitCache
 	client *github.Client
 }
 
 func NewGitHubClient(client *github.Client) *GitHubClient {
-	return &GitHubClient{client: client}
+	return &GitHubClient{pager: newPager(), fetcher: newFetcher(), client: client}
 }
 
-func (c *GitHubClient) ListRepositories() ([]Repository, error) {
-	ctx := context.Background()
-	repos, _, err := c.client.Repositories.List(ctx, "", &github.RepositoryListOptions{
-		Sort:        "updated",
-		Direction:   "desc",
-		ListOptions: github.ListOptions{PerPage: 100},
+func (c *GitHubClient) ListRepositories(ctx context.Context) ([]Repository, error) {
+	repos, err := paginate(&c.pager, "repositories", func(page int) ([]*github.Repository, int, error) {
+		repos, resp, err := c.client.Repositories.List(ctx, "", &github.RepositoryListOptions{
+			Sort:        "updated",
+			Direction:   "desc",
+			ListOptions: github.ListOptions{Page: page, PerPage: 100},
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		return repos, resp.NextPage, nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list GitHub repositories: %v", err)
@@ -77,111 +140,294 @@ func (c *GitHubClient) ListRepositories() ([]Repository, error) {
 	return result, nil
 }
 
-func (c *GitHubClient) ListPullRequests(repoFullName string) ([]PullRequest, error) {
-	ctx := context.Background()
-	owner, repo, err := splitRepoFullName(repoFullName)
+func (c *GitHubClient) ListPullRequests(ctx context.Context, repoRef RepoRef) ([]PullRequest, error) {
+	owner, repo, err := repoRef.ownerAndName()
 	if err != nil {
 		return nil, err
 	}
 
-	prs, _, err := c.client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
-		State:       "open",
-		ListOptions: github.ListOptions{PerPage: 100},
+	prs, err := paginate(&c.pager, "pull requests in "+repoRef.String(), func(page int) ([]*github.PullRequest, int, error) {
+		prs, resp, err := c.client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+			State:       "open",
+			ListOptions: github.ListOptions{Page: page, PerPage: 100},
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		return prs, resp.NextPage, nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list pull requests: %v", err)
 	}
 
+	// Fetching the changed files takes a request per pull request
+	progress := newProgressCounter(ctx, PhaseListPullRequests, len(prs))
 	var result []PullRequest
 	for _, pr := range prs {
-		// Get changed files for each PR
-		files, _, err := c.client.PullRequests.ListFiles(ctx, owner, repo, pr.GetNumber(), &github.ListOptions{PerPage: 100})
+		converted, err := c.convertPullRequest(ctx, owner, repo, pr)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get changed files: %v", err)
+			return nil, err
 		}
+		result = append(result, *converted)
+		progress.add()
+	}
+
+	return result, nil
+}
+
+func (c *GitHubClient) GetPullRequest(ctx context.Context, repoRef RepoRef, number int) (*PullRequest, error) {
+	owner, repo, err := repoRef.ownerAndName()
+	if err != nil {
+		return nil, err
+	}
 
-		var changedFiles []string
-		for _, file := range files {
-			changedFiles = append(changedFiles, file.GetFilename())
+	pr, resp, err := c.client.PullRequests.Get(ctx, owner, repo, number)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, pullRequestNotFound(number)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pull request: %v", err)
+	}
+	return c.convertPullRequest(ctx, owner, repo, pr)
+}
+
+func (c *GitHubClient) CloneURL(ctx context.Context, repoRef RepoRef) (string, error) {
+	owner, repo, err := repoRef.ownerAndName()
+	if err != nil {
+		return "", err
+	}
+
+	repository, _, err := c.client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to get repository: %v", err)
+	}
+	return repository.GetCloneURL(), nil
+}
+
+// convertPullRequest converts a GitHub pull request, fetching its changed files
+func (c *GitHubClient) convertPullRequest(ctx context.Context, owner, repo string, pr *github.PullRequest) (*PullRequest, error) {
+	files, err := c.listPullRequestFiles(ctx, owner, repo, pr.GetNumber())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %v", err)
+	}
+
+	var changedFiles []string
+	hunks := map[string][]LineRange{}
+	for _, file := range files {
+		changedFiles = append(changedFiles, file.GetFilename())
+		if patch := file.GetPatch(); patch != "" {
+			hunks[file.GetFilename()] = ParseHunks(patch)
 		}
+	}
 
-		result = append(result, PullRequest{
-			Number:       pr.GetNumber(),
-			Title:        pr.GetTitle(),
-			State:        pr.GetState(),
-			URL:          pr.GetHTMLURL(),
-			Provider:     "github",
-			ChangedFiles: changedFiles,
-		})
+	return &PullRequest{
+		Number:       pr.GetNumber(),
+		Title:        pr.GetTitle(),
+		State:        pr.GetState(),
+		URL:          pr.GetHTMLURL(),
+		Provider:     "github",
+		BaseRef:      pr.GetBase().GetRef(),
+		HeadRef:      pr.GetHead().GetRef(),
+		HeadSHA:      pr.GetHead().GetSHA(),
+		ChangedFiles: changedFiles,
+		Hunks:        hunks,
+	}, nil
+}
+
+func (c *GitHubClient) GetBlameInfo(ctx context.Context, repoRef RepoRef, prNumber int, files []string, window Window) (*BlameResult, error) {
+	owner, repo, err := repoRef.ownerAndName()
+	if err != nil {
+		return nil, err
+	}
+
+	pr, _, err := c.client.PullRequests.Get(ctx, owner, repo, prNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pull request: %v", err)
+	}
+	baseSHA := pr.GetBase().GetSHA()
+	ref := baseSHA
+	if window.Ref != "" {
+		ref = window.Ref
+	}
+
+	// Map each file to its path at the base commit, skipping files the PR adds
+	prFiles, err := c.listPullRequestFiles(ctx, owner, repo, prNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %v", err)
+	}
+	basePaths := make(map[string]string)
+	for _, file := range prFiles {
+		switch file.GetStatus() {
+		case "added":
+		case "renamed":
+			basePaths[file.GetFilename()] = file.GetPreviousFilename()
+		default:
+			basePaths[file.GetFilename()] = file.GetFilename()
+		}
+	}
+
+	result := newBlameResult(ref)
+	reportBlameProgress(ctx, result, 0, len(files))
+	for i, filename := range files {
+		basePath, ok := basePaths[filename]
+		if !ok {
+			continue
+		}
+
+		// Blame at a fixed commit never changes, unlike blame at a branch
+		key := fmt.Sprintf("github:%s/%s/%s/blame/%s/%s", c.client.BaseURL.Host, owner, repo, ref, basePath)
+		ranges, err := cached(&c.commitCache, key, func() ([]BlameRange, error) {
+			return c.blameFile(ctx, owner, repo, ref, basePath)
+		}, func([]BlameRange) bool { return ref == baseSHA })
+		if errors.Is(err, errNotAtRef) {
+			result.Skipped = append(result.Skipped, filename)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get blame for file %s: %v", filename, err)
+		}
+		result.addFile(filename, window.filter(ranges))
+		reportBlameProgress(ctx, result, i+1, len(files))
 	}
 
 	return result, nil
 }
 
-func (c *GitHubClient) GetBlameInfo(repoFullName string, prNumber int, files []string) (map[string]BlameInfo, error) {
-	ctx := context.Background()
-	owner, repo, err := splitRepoFullName(repoFullName)
+// listPullRequestFiles returns every file changed by a pull request
+func (c *GitHubClient) listPullRequestFiles(ctx context.Context, owner, repo string, number int) ([]*github.CommitFile, error) {
+	return paginate(&c.pager, fmt.Sprintf("files in pull request #%d", number), func(page int) ([]*github.CommitFile, int, error) {
+		files, resp, err := c.client.PullRequests.ListFiles(ctx, owner, repo, number, &github.ListOptions{Page: page, PerPage: 100})
+		if err != nil {
+			return nil, 0, err
+		}
+		return files, resp.NextPage, nil
+	})
+}
+
+// blameFile fetches the blame of a file through the GraphQL API, which is the
+// only GitHub API that exposes line-level blame
+func (c *GitHubClient) blameFile(ctx context.Context, owner, repo, ref, path string) ([]BlameRange, error) {
+	body := map[string]interface{}{
+		"query": githubBlameQuery,
+		"variables": map[string]string{
+			"owner":      owner,
+			"name":       repo,
+			"expression": ref,
+			"path":       path,
+			"file":       ref + ":" + path,
+		},
+	}
+
+	req, err := c.client.NewRequest("POST", githubGraphQLURL(c.client.BaseURL), body)
 	if err != nil {
 		return nil, err
 	}
 
-	blameInfo := make(map[string]BlameInfo)
+	var resp githubBlameResponse
+	if _, err := c.client.Do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	// Blame fails for a file missing at ref, which leaves the file object
+	// null; a missing ref leaves both null without an error
+	repository := resp.Data.Repository
+	switch {
+	case repository != nil && repository.File == nil && len(resp.Errors) > 0:
+		return nil, errNotAtRef
+	case len(resp.Errors) > 0:
+		return nil, fmt.Errorf("graphql: %s", resp.Errors[0].Message)
+	case repository == nil || repository.Object == nil:
+		return nil, fmt.Errorf("ref %s not found", ref)
+	}
+
+	var ranges []BlameRange
+	for _, rng := range repository.Object.Blame.Ranges {
+		author := rng.Commit.Author.Name
+		if rng.Commit.Author.User != nil && rng.Commit.Author.User.Login != "" {
+			author = rng.Commit.Author.User.Login
+		}
+		if author == "" {
+			author = "Unknown Author"
+		}
 
-	for _, filename := range files {
-		// Get the file's commit history
-		commits, _, err := c.client.Repositories.ListCommits(ctx, owner, repo, &github.CommitsListOptions{
-			Path: filename,
+		ranges = append(ranges, BlameRange{
+			StartLine: rng.StartingLine,
+			EndLine:   rng.EndingLine,
+			Author:    author,
+			Commit:    rng.Commit.OID,
+			Date:      rng.Commit.AuthoredDate,
 		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to get commits for file %s: %v", filename, err)
-		}
-
-		// For each commit, count the number of lines it modified
-		for _, commit := range commits {
-			// Try to get author name in order of preference
-			var author string
-			if commit.GetAuthor() != nil {
-				author = commit.GetAuthor().GetLogin()
-				if author == "" {
-					author = commit.GetAuthor().GetName()
-				}
-			}
-			if author == "" && commit.GetCommitter() != nil {
-				author = commit.GetCommitter().GetLogin()
-				if author == "" {
-					author = commit.GetCommitter().GetName()
-				}
-			}
-			if author == "" {
-				author = "Unknown Author"
-			}
+	}
 
-			// Get the commit details to see what files were modified
-			commitDetails, _, err := c.client.Repositories.GetCommit(ctx, owner, repo, commit.GetSHA(), nil)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get commit details: %v", err)
-			}
+	return ranges, nil
+}
 
-			// Count lines modified in this commit for this file
-			for _, file := range commitDetails.Files {
-				if file.GetFilename() == filename {
-					info := blameInfo[author]
-					info.User = author
-					info.Lines += file.GetChanges()
-					blameInfo[author] = info
-					break
-				}
-			}
+func (c *GitHubClient) GetCommitHistory(ctx context.Context, repoRef RepoRef, window Window) ([]Commit, error) {
+	owner, repo, err := repoRef.ownerAndName()
+	if err != nil {
+		return nil, err
+	}
+
+	commits, err := c.GetCommits(ctx, owner, repo, window)
+	if err != nil {
+		return nil, err
+	}
+
+	// The list endpoint does not include files, so fetch each commit in parallel
+	var shas []string
+	for _, commit := range commits {
+		shas = append(shas, commit.GetSHA())
+	}
+	progress := newProgressCounter(ctx, PhaseFetchCommits, len(shas))
+	details, err := fetchAll(ctx, &c.fetcher, shas, func(ctx context.Context, sha string) (*github.RepositoryCommit, error) {
+		details, err := c.GetCommitDetails(ctx, owner, repo, sha)
+		if err == nil {
+			progress.add()
 		}
+		return details, err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return blameInfo, nil
+	var result []Commit
+	for i, commit := range commits {
+		details := details[i]
+
+		var files []FileChange
+		for _, file := range details.Files {
+			files = append(files, FileChange{
+				Path:      file.GetFilename(),
+				OldPath:   file.GetPreviousFilename(),
+				Additions: file.GetAdditions(),
+				Deletions: file.GetDeletions(),
+			})
+		}
+
+		result = append(result, Commit{
+			SHA:    commit.GetSHA(),
+			Author: commit.GetCommit().GetAuthor().GetName(),
+			Email:  commit.GetCommit().GetAuthor().GetEmail(),
+			Date:   commit.GetCommit().GetAuthor().GetDate(),
+			Files:  files,
+		})
+	}
+
+	return result, nil
 }
 
-// GetCommits returns all commits for a repository since a given date
-func (c *GitHubClient) GetCommits(ctx context.Context, owner, repo string, since time.Time) ([]*github.RepositoryCommit, error) {
-	commits, _, err := c.client.Repositories.ListCommits(ctx, owner, repo, &github.CommitsListOptions{
-		Since: since,
+// GetCommits returns the commits of a repository within a window
+func (c *GitHubClient) GetCommits(ctx context.Context, owner, repo string, window Window) ([]*github.RepositoryCommit, error) {
+	commits, err := paginate(&c.pager, fmt.Sprintf("commits in %s/%s", owner, repo), func(page int) ([]*github.RepositoryCommit, int, error) {
+		commits, resp, err := c.client.Repositories.ListCommits(ctx, owner, repo, &github.CommitsListOptions{
+			SHA:         window.Ref,
+			Since:       window.Since,
+			Until:       window.Until,
+			ListOptions: github.ListOptions{Page: page, PerPage: 100},
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		return commits, resp.NextPage, nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list commits: %v", err)
@@ -189,34 +435,68 @@ func (c *GitHubClient) GetCommits(ctx context.Context, owner, repo string, since
 	return commits, nil
 }
 
-// GetCommitDetails returns the details of a specific commit
+// GetCommitDetails returns the details of a specific commit. Commits never
+// change, so the details are served from the cache when it holds them.
 func (c *GitHubClient) GetCommitDetails(ctx context.Context, owner, repo, sha string) (*github.RepositoryCommit, error) {
-	commit, _, err := c.client.Repositories.GetCommit(ctx, owner, repo, sha, nil)
+	key := fmt.Sprintf("github:%s/%s/%s/commit/%s", c.client.BaseURL.Host, owner, repo, sha)
+	return cached(&c.commitCache, key, func() (*github.RepositoryCommit, error) {
+		return c.fetchCommitDetails(ctx, owner, repo, sha)
+	}, func(commit *github.RepositoryCommit) bool {
+		return c.below(len(commit.Files))
+	})
+}
+
+func (c *GitHubClient) fetchCommitDetails(ctx context.Context, owner, repo, sha string) (*github.RepositoryCommit, error) {
+	// Large commits list their files over several pages
+	var commit *github.RepositoryCommit
+	files, err := paginate(&c.pager, "files in commit "+sha, func(page int) ([]*github.CommitFile, int, error) {
+		if err := c.gate.wait(ctx); err != nil {
+			return nil, 0, err
+		}
+		details, resp, err := c.client.Repositories.GetCommit(ctx, owner, repo, sha, &github.ListOptions{Page: page, PerPage: 100})
+		c.gate.update(githubRateLimit(resp))
+		if err != nil {
+			return nil, 0, err
+		}
+		if commit == nil {
+			commit = details
+		}
+		return details.Files, resp.NextPage, nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get commit details: %v", err)
 	}
+	commit.Files = files
 	return commit, nil
 }
 
 // GitLabClient implements RepositoryClient for GitLab
 type GitLabClient struct {
+	pager
+	fetcher
+	commitCache
 	client *gitlab.Client
 }
 
 func NewGitLabClient(client *gitlab.Client) *GitLabClient {
-	return &GitLabClient{client: client}
+	return &GitLabClient{pager: newPager(), fetcher: newFetcher(), client: client}
 }
 
-func (c *GitLabClient) ListRepositories() ([]Repository, error) {
-	opt := &gitlab.ListProjectsOptions{
-		OrderBy: gitlab.String("updated_at"),
-		Sort:    gitlab.String("desc"),
-		ListOptions: gitlab.ListOptions{
-			PerPage: 100,
-		},
-	}
-
-	projects, _, err := c.client.Projects.ListProjects(opt)
+func (c *GitLabClient) ListRepositories(ctx context.Context) ([]Repository, error) {
+	projects, err := paginate(&c.pager, "repositories", func(page int) ([]*gitlab.Project, int, error) {
+		projects, resp, err := c.client.Projects.ListProjects(&gitlab.ListProjectsOptions{
+			OrderBy: gitlab.String("updated_at"),
+			Sort:    gitlab.String("desc"),
+			ListOptions: gitlab.ListOptions{
+				Page:    page,
+				PerPage: 100,
+			},
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, 0, err
+		}
+		return projects, resp.NextPage, nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list GitLab repositories: %v", err)
 	}
@@ -234,86 +514,339 @@ func (c *GitLabClient) ListRepositories() ([]Repository, error) {
 	return result, nil
 }
 
-func (c *GitLabClient) ListPullRequests(repoFullName string) ([]PullRequest, error) {
-	opt := &gitlab.ListProjectMergeRequestsOptions{
-		State: gitlab.String("opened"),
-		ListOptions: gitlab.ListOptions{
-			PerPage: 100,
-		},
-	}
-
-	mrs, _, err := c.client.MergeRequests.ListProjectMergeRequests(repoFullName, opt)
+func (c *GitLabClient) ListPullRequests(ctx context.Context, repoRef RepoRef) ([]PullRequest, error) {
+	mrs, err := paginate(&c.pager, "merge requests in "+repoRef.String(), func(page int) ([]*gitlab.MergeRequest, int, error) {
+		mrs, resp, err := c.client.MergeRequests.ListProjectMergeRequests(repoRef.gitlabProject(), &gitlab.ListProjectMergeRequestsOptions{
+			State: gitlab.String("opened"),
+			ListOptions: gitlab.ListOptions{
+				Page:    page,
+				PerPage: 100,
+			},
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, 0, err
+		}
+		return mrs, resp.NextPage, nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list merge requests: %v", err)
 	}
 
+	// Fetching the changed files takes a request per merge request
+	progress := newProgressCounter(ctx, PhaseListPullRequests, len(mrs))
 	var result []PullRequest
 	for _, mr := range mrs {
-		// Get changed files for each MR
-		changes, _, err := c.client.MergeRequests.GetMergeRequestChanges(repoFullName, mr.IID, nil)
+		converted, err := c.convertMergeRequest(ctx, repoRef, mr)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get changed files: %v", err)
+			return nil, err
 		}
+		result = append(result, *converted)
+		progress.add()
+	}
+
+	return result, nil
+}
+
+func (c *GitLabClient) GetPullRequest(ctx context.Context, repoRef RepoRef, number int) (*PullRequest, error) {
+	mr, resp, err := c.client.MergeRequests.GetMergeRequest(repoRef.gitlabProject(), number, nil, gitlab.WithContext(ctx))
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, pullRequestNotFound(number)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get merge request: %v", err)
+	}
+	return c.convertMergeRequest(ctx, repoRef, mr)
+}
+
+func (c *GitLabClient) CloneURL(ctx context.Context, repoRef RepoRef) (string, error) {
+	project, _, err := c.client.Projects.GetProject(repoRef.gitlabProject(), nil, gitlab.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to get project: %v", err)
+	}
+	return project.HTTPURLToRepo, nil
+}
 
-		var changedFiles []string
-		for _, change := range changes.Changes {
-			changedFiles = append(changedFiles, change.NewPath)
+// convertMergeRequest converts a GitLab merge request, fetching its changed files
+func (c *GitLabClient) convertMergeRequest(ctx context.Context, repoRef RepoRef, mr *gitlab.MergeRequest) (*PullRequest, error) {
+	changes, err := c.listMergeRequestDiffs(ctx, repoRef, mr.IID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %v", err)
+	}
+
+	var changedFiles []string
+	hunks := map[string][]LineRange{}
+	for _, change := range changes {
+		changedFiles = append(changedFiles, change.NewPath)
+		if change.Diff != "" {
+			hunks[change.NewPath] = ParseHunks(change.Diff)
 		}
+	}
 
-		result = append(result, PullRequest{
-			Number:       mr.IID,
-			Title:        mr.Title,
-			State:        mr.State,
-			URL:          mr.WebURL,
-			Provider:     "gitlab",
-			ChangedFiles: changedFiles,
-		})
+	return &PullRequest{
+		Number:       mr.IID,
+		Title:        mr.Title,
+		State:        mr.State,
+		URL:          mr.WebURL,
+		Provider:     "gitlab",
+		BaseRef:      mr.TargetBranch,
+		HeadRef:      mr.SourceBranch,
+		HeadSHA:      mr.SHA,
+		ChangedFiles: changedFiles,
+		Hunks:        hunks,
+	}, nil
+}
+
+func (c *GitLabClient) GetBlameInfo(ctx context.Context, repoRef RepoRef, prNumber int, files []string, window Window) (*BlameResult, error) {
+	mr, _, err := c.client.MergeRequests.GetMergeRequest(repoRef.gitlabProject(), prNumber, nil, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get merge request: %v", err)
+	}
+	baseSHA := mr.DiffRefs.BaseSha
+	ref := baseSHA
+	if window.Ref != "" {
+		// Files missing at the ref are skipped, so the ref itself must exist
+		if _, _, err := c.client.Commits.GetCommit(repoRef.gitlabProject(), window.Ref, nil, gitlab.WithContext(ctx)); err != nil {
+			return nil, fmt.Errorf("failed to find ref %s: %v", window.Ref, err)
+		}
+		ref = window.Ref
+	}
+
+	// Map each file to its path at the base commit, skipping files the MR adds
+	changes, err := c.listMergeRequestDiffs(ctx, repoRef, prNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %v", err)
+	}
+	basePaths := make(map[string]string)
+	for _, change := range changes {
+		if !change.NewFile {
+			basePaths[change.NewPath] = change.OldPath
+		}
+	}
+
+	result := newBlameResult(ref)
+	reportBlameProgress(ctx, result, 0, len(files))
+	for i, filename := range files {
+		basePath, ok := basePaths[filename]
+		if !ok {
+			continue
+		}
+
+		// Blame at a fixed commit never changes, unlike blame at a branch
+		key := fmt.Sprintf("gitlab:%s/%s/blame/%s/%s", c.client.BaseURL().Host, repoRef, ref, basePath)
+		ranges, err := cached(&c.commitCache, key, func() ([]BlameRange, error) {
+			return c.blameFile(ctx, repoRef, ref, basePath)
+		}, func([]BlameRange) bool { return ref == baseSHA })
+		if errors.Is(err, errNotAtRef) {
+			result.Skipped = append(result.Skipped, filename)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get blame for file %s: %v", filename, err)
+		}
+		result.addFile(filename, window.filter(ranges))
+		reportBlameProgress(ctx, result, i+1, len(files))
 	}
 
 	return result, nil
 }
 
-func (c *GitLabClient) GetBlameInfo(repoFullName string, prNumber int, files []string) (map[string]BlameInfo, error) {
-	blameInfo := make(map[string]BlameInfo)
+// blameFile fetches the blame of a file at ref
+func (c *GitLabClient) blameFile(ctx context.Context, repoRef RepoRef, ref, path string) ([]BlameRange, error) {
+	blame, resp, err := c.client.RepositoryFiles.GetFileBlame(repoRef.gitlabProject(), path, &gitlab.GetFileBlameOptions{
+		Ref: gitlab.String(ref),
+	}, gitlab.WithContext(ctx))
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, errNotAtRef
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	for _, filename := range files {
-		// Get the file's commit history
-		commits, _, err := c.client.Commits.ListCommits(repoFullName, &gitlab.ListCommitsOptions{
-			Path: gitlab.String(filename),
+	// GitLab returns consecutive groups of lines, so line numbers are implied by order
+	var ranges []BlameRange
+	line := 1
+	for _, group := range blame {
+		author := group.Commit.AuthorName
+		if author == "" {
+			author = group.Commit.AuthorEmail
+		}
+		var date time.Time
+		if group.Commit.AuthoredDate != nil {
+			date = *group.Commit.AuthoredDate
+		}
+		ranges = append(ranges, BlameRange{
+			StartLine: line,
+			EndLine:   line + len(group.Lines) - 1,
+			Author:    author,
+			Commit:    group.Commit.ID,
+			Date:      date,
 		})
+		line += len(group.Lines)
+	}
+	return ranges, nil
+}
+
+func (c *GitLabClient) GetCommitHistory(ctx context.Context, repoRef RepoRef, window Window) ([]Commit, error) {
+	opts := &gitlab.ListCommitsOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+		},
+	}
+	if window.Ref != "" {
+		opts.RefName = gitlab.String(window.Ref)
+	}
+	if !window.Since.IsZero() {
+		opts.Since = gitlab.Time(window.Since)
+	}
+	if !window.Until.IsZero() {
+		opts.Until = gitlab.Time(window.Until)
+	}
+
+	commits, err := paginate(&c.pager, "commits in "+repoRef.String(), func(page int) ([]*gitlab.Commit, int, error) {
+		opts.Page = page
+		commits, resp, err := c.client.Commits.ListCommits(repoRef.gitlabProject(), opts, gitlab.WithContext(ctx))
 		if err != nil {
-			return nil, fmt.Errorf("failed to get commits for file %s: %v", filename, err)
+			return nil, 0, err
 		}
+		return commits, resp.NextPage, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits: %v", err)
+	}
 
-		// For each commit, count the number of lines it modified
-		for _, commit := range commits {
-			author := commit.AuthorName
-			if author == "" {
-				author = commit.AuthorEmail
-			}
+	var shas []string
+	for _, commit := range commits {
+		shas = append(shas, commit.ID)
+	}
+	progress := newProgressCounter(ctx, PhaseFetchCommits, len(shas))
+	allDiffs, err := fetchAll(ctx, &c.fetcher, shas, func(ctx context.Context, sha string) ([]*gitlab.Diff, error) {
+		diffs, err := c.getCommitDiff(ctx, repoRef, sha)
+		if err == nil {
+			progress.add()
+		}
+		return diffs, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit diff: %v", err)
+	}
 
-			// Get the diff for this commit
-			diffs, _, err := c.client.Commits.GetCommitDiff(repoFullName, commit.ID, &gitlab.GetCommitDiffOptions{})
-			if err != nil {
-				return nil, fmt.Errorf("failed to get commit diff: %v", err)
+	var result []Commit
+	for i, commit := range commits {
+		diffs := allDiffs[i]
+
+		var files []FileChange
+		for _, diff := range diffs {
+			added, deleted := countDiffLines(diff.Diff)
+			change := FileChange{
+				Path:      diff.NewPath,
+				Additions: added,
+				Deletions: deleted,
 			}
-
-			// Count lines modified in this commit for this file
-			for _, diff := range diffs {
-				if diff.NewPath == filename {
-					info := blameInfo[author]
-					info.User = author
-					// Count the number of lines in the diff
-					lines := strings.Split(diff.Diff, "\n")
-					info.Lines += len(lines)
-					blameInfo[author] = info
-					break
-				}
+			if diff.RenamedFile {
+				change.OldPath = diff.OldPath
 			}
+			files = append(files, change)
+		}
+
+		var date time.Time
+		if commit.AuthoredDate != nil {
+			date = *commit.AuthoredDate
+		}
+
+		result = append(result, Commit{
+			SHA:    commit.ID,
+			Author: commit.AuthorName,
+			Email:  commit.AuthorEmail,
+			Date:   date,
+			Files:  files,
+		})
+	}
+
+	return result, nil
+}
+
+// listMergeRequestDiffs returns every file changed by a merge request
+func (c *GitLabClient) listMergeRequestDiffs(ctx context.Context, repoRef RepoRef, iid int) ([]*gitlab.MergeRequestDiff, error) {
+	return paginate(&c.pager, fmt.Sprintf("files in merge request !%d", iid), func(page int) ([]*gitlab.MergeRequestDiff, int, error) {
+		diffs, resp, err := c.client.MergeRequests.ListMergeRequestDiffs(repoRef.gitlabProject(), iid, &gitlab.ListMergeRequestDiffsOptions{
+			ListOptions: gitlab.ListOptions{
+				Page:    page,
+				PerPage: 100,
+			},
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, 0, err
+		}
+		return diffs, resp.NextPage, nil
+	})
+}
+
+// getCommitDiff returns the diff of every file changed by a commit, from the
+// cache when it holds them
+func (c *GitLabClient) getCommitDiff(ctx context.Context, repoRef RepoRef, sha string) ([]*gitlab.Diff, error) {
+	key := fmt.Sprintf("gitlab:%s/%s/commit/%s/diff", c.client.BaseURL().Host, repoRef, sha)
+	return cached(&c.commitCache, key, func() ([]*gitlab.Diff, error) {
+		return c.fetchCommitDiff(ctx, repoRef, sha)
+	}, func(diffs []*gitlab.Diff) bool {
+		return c.below(len(diffs))
+	})
+}
+
+func (c *GitLabClient) fetchCommitDiff(ctx context.Context, repoRef RepoRef, sha string) ([]*gitlab.Diff, error) {
+	return paginate(&c.pager, "files in commit "+sha, func(page int) ([]*gitlab.Diff, int, error) {
+		if err := c.gate.wait(ctx); err != nil {
+			return nil, 0, err
+		}
+		diffs, resp, err := c.client.Commits.GetCommitDiff(repoRef.gitlabProject(), sha, &gitlab.GetCommitDiffOptions{
+			ListOptions: gitlab.ListOptions{
+				Page:    page,
+				PerPage: 100,
+			},
+		}, gitlab.WithContext(ctx))
+		c.gate.update(gitlabRateLimit(resp))
+		if err != nil {
+			return nil, 0, err
+		}
+		return diffs, resp.NextPage, nil
+	})
+}
+
+// countDiffLines counts the added and deleted lines in a unified diff body.
+// Only the lines of hunks count, so file headers before the first @@ are
+// skipped, while removed lines such as --flag are not.
+func countDiffLines(diff string) (int, int) {
+	var added, deleted int
+	inHunk := false
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			inHunk = true
+		case !inHunk, strings.HasPrefix(line, "\\"):
+			// Headers and "\ No newline at end of file" are not content
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			deleted++
 		}
 	}
+	return added, deleted
+}
 
-	return blameInfo, nil
+// FormatCodeMaatLog renders commits in the git2 log format understood by code-maat
+func FormatCodeMaatLog(commits []Commit) string {
+	var sb strings.Builder
+	for _, commit := range commits {
+		sha := commit.SHA
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		sb.WriteString(fmt.Sprintf("--%s--%s--%s\n", sha, commit.Date.Format("2006-01-02"), commit.Author))
+		for _, file := range commit.Files {
+			sb.WriteString(fmt.Sprintf("%d\t%d\t%s\n", file.Additions, file.Deletions, file.Path))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
 }
 
 func FormatRepoList(repos []Repository) string {
@@ -345,34 +878,3 @@ func FormatChangedFiles(files []string) string {
 	}
 	return sb.String()
 }
-
-func FormatBlameInfo(blameInfo map[string]BlameInfo) string {
-	var sb strings.Builder
-	sb.WriteString("\nAuthors and Lines Touched:\n")
-	sb.WriteString("-------------------------\n")
-
-	// Convert map to slice for sorting
-	var infoSlice []BlameInfo
-	for _, info := range blameInfo {
-		infoSlice = append(infoSlice, info)
-	}
-
-	// Sort by number of lines (descending)
-	sort.Slice(infoSlice, func(i, j int) bool {
-		return infoSlice[i].Lines > infoSlice[j].Lines
-	})
-
-	for _, info := range infoSlice {
-		sb.WriteString(fmt.Sprintf("%s: %d lines\n", info.User, info.Lines))
-	}
-
-	return sb.String()
-}
-
-func splitRepoFullName(fullName string) (string, string, error) {
-	parts := strings.Split(fullName, "/")
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("invalid repository name format: %s. Expected format: owner/repo", fullName)
-	}
-	return parts[0], parts[1], nil
-}
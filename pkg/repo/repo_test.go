@@ -0,0 +1,243 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestCountDiffLines(t *testing.T) {
+	tests := []struct {
+		name           string
+		diff           string
+		added, deleted int
+	}{
+		{"GitLab body", "@@ -1,2 +1,2 @@\n-old\n+new\n context", 1, 1},
+		{"lines that look like headers", "@@ -1,3 +1,3 @@\n---flag\n----\n+++counter\n++x\n context", 2, 2},
+		{"file headers", "--- a/app.go\n+++ b/app.go\n@@ -1 +1 @@\n-old\n+new", 1, 1},
+		{"no newline at end of file", "@@ -1 +1 @@\n-old\n\\ No newline at end of file\n+new\n\\ No newline at end of file", 1, 1},
+		{"several hunks", "@@ -1 +1,2 @@\n+a\n+b\n@@ -10,2 +11 @@\n-c\n-d", 2, 2},
+		{"empty", "", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, deleted := countDiffLines(tt.diff)
+			if added != tt.added || deleted != tt.deleted {
+				t.Errorf("Expected %d added and %d deleted, got %d and %d", tt.added, tt.deleted, added, deleted)
+			}
+		})
+	}
+}
+
+// The code-maat log of the commits each client returns for the same history
+const expectedCodeMaatLog = "--c2c2c2c--2024-02-01--Bob\n2\t1\tapp.go\n1\t0\tlib.go\n\n--c1c1c1c--2024-01-01--Alice\n10\t0\tapp.go\n\n"
+
+func TestGitHubClient_GetCommitHistory(t *testing.T) {
+	responses := map[string]string{
+		"/repos/octo/app/commits": `[
+			{"sha": "c2c2c2c2", "commit": {"author": {"name": "Bob", "email": "bob@example.com", "date": "2024-02-01T00:00:00Z"}}},
+			{"sha": "c1c1c1c1", "commit": {"author": {"name": "Alice", "email": "alice@example.com", "date": "2024-01-01T00:00:00Z"}}}]`,
+		"/repos/octo/app/commits/c2c2c2c2": `{"sha": "c2c2c2c2", "files": [
+			{"filename": "app.go", "additions": 2, "deletions": 1},
+			{"filename": "lib.go", "previous_filename": "util.go", "additions": 1, "deletions": 0}]}`,
+		"/repos/octo/app/commits/c1c1c1c1": `{"sha": "c1c1c1c1", "files": [{"filename": "app.go", "additions": 10, "deletions": 0}]}`,
+	}
+	server := (&fakeREST{responses: responses}).start(t)
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	commits, err := NewGitHubClient(client).GetCommitHistory(context.Background(), mustParseRepoRef(t, GitHub, "octo/app"), Window{})
+	if err != nil {
+		t.Fatalf("GetCommitHistory failed: %v", err)
+	}
+	if len(commits) != 2 || commits[0].Files[1].OldPath != "util.go" || commits[0].Email != "bob@example.com" {
+		t.Errorf("Unexpected commits: %+v", commits)
+	}
+	if got := FormatCodeMaatLog(commits); got != expectedCodeMaatLog {
+		t.Errorf("Expected %q, got %q", expectedCodeMaatLog, got)
+	}
+}
+
+func TestGitLabClient_GetCommitHistory(t *testing.T) {
+	const project = "/api/v4/projects/group%2Fsub%2Fapp/repository/commits"
+	responses := map[string]string{
+		project: `[
+			{"id": "c2c2c2c2", "author_name": "Bob", "author_email": "bob@example.com", "authored_date": "2024-02-01T00:00:00Z"},
+			{"id": "c1c1c1c1", "author_name": "Alice", "author_email": "alice@example.com", "authored_date": "2024-01-01T00:00:00Z"}]`,
+		project + "/c2c2c2c2/diff": `[
+			{"new_path": "app.go", "old_path": "app.go", "diff": "@@ -1,2 +1,3 @@\n---verbose\n+--quiet\n+++count\n keep"},
+			{"new_path": "lib.go", "old_path": "util.go", "renamed_file": true, "diff": "@@ -1 +1,2 @@\n keep\n+added\n\\ No newline at end of file"}]`,
+		project + "/c1c1c1c1/diff": `[{"new_path": "app.go", "old_path": "app.go", "new_file": true,
+			"diff": "@@ -0,0 +1,10 @@\n+1\n+2\n+3\n+4\n+5\n+6\n+7\n+8\n+9\n+10"}]`,
+	}
+	server := (&fakeREST{responses: responses}).start(t)
+
+	client, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	commits, err := NewGitLabClient(client).GetCommitHistory(context.Background(), mustParseRepoRef(t, GitLab, "group/sub/app"), Window{})
+	if err != nil {
+		t.Fatalf("GetCommitHistory failed: %v", err)
+	}
+	if len(commits) != 2 || commits[0].Files[1].OldPath != "util.go" || commits[0].Files[0].OldPath != "" {
+		t.Errorf("Unexpected commits: %+v", commits)
+	}
+	if got := FormatCodeMaatLog(commits); got != expectedCodeMaatLog {
+		t.Errorf("Expected %q, got %q", expectedCodeMaatLog, got)
+	}
+}
+
+func TestGithubGraphQLURL(t *testing.T) {
+	tests := []struct {
+		base     string
+		expected string
+	}{
+		{"https://api.github.com/", "https://api.github.com/graphql"},
+		{"https://ghe.example.com/api/v3/", "https://ghe.example.com/api/graphql"},
+		{"http://127.0.0.1:8080", "http://127.0.0.1:8080/graphql"},
+	}
+
+	for _, tt := range tests {
+		base, _ := url.Parse(tt.base)
+		if got := githubGraphQLURL(base); got != tt.expected {
+			t.Errorf("Expected %s for %s, got %s", tt.expected, tt.base, got)
+		}
+	}
+}
+
+func TestGitHubClient_BlameFile(t *testing.T) {
+	const blamed = `{"data": {"repository": {"file": {"oid": "f1"}, "object": {"blame": {"ranges": [
+		{"startingLine": 1, "endingLine": 2, "commit": {"oid": "c1", "authoredDate": "2024-01-01T00:00:00Z",
+			"author": {"name": "Alice Smith", "email": "alice@example.com", "user": {"login": "alice"}}}},
+		{"startingLine": 3, "endingLine": 3, "commit": {"oid": "c2", "authoredDate": "2024-02-01T00:00:00Z",
+			"author": {"name": "Bob", "email": "bob@example.com", "user": null}}},
+		{"startingLine": 4, "endingLine": 6, "commit": {"oid": "c3", "authoredDate": "2024-03-01T00:00:00Z",
+			"author": {"name": "", "email": "", "user": null}}}]}}}}}`
+
+	tests := []struct {
+		name     string
+		response string
+		expected []BlameRange
+		err      string
+	}{
+		{
+			name:     "ranges",
+			response: blamed,
+			expected: []BlameRange{
+				{StartLine: 1, EndLine: 2, Author: "alice", Commit: "c1", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+				{StartLine: 3, EndLine: 3, Author: "Bob", Commit: "c2", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+				{StartLine: 4, EndLine: 6, Author: "Unknown Author", Commit: "c3", Date: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:     "file missing at ref",
+			response: `{"data": {"repository": {"file": null, "object": null}}, "errors": [{"message": "Could not resolve file for path 'app.go'."}]}`,
+			err:      errNotAtRef.Error(),
+		},
+		{
+			name:     "ref missing",
+			response: `{"data": {"repository": {"file": null, "object": null}}}`,
+			err:      "ref main not found",
+		},
+		{
+			name:     "other error",
+			response: `{"data": null, "errors": [{"message": "Something went wrong"}]}`,
+			err:      "graphql: Something went wrong",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var variables map[string]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// GitHub Enterprise serves GraphQL beside, not under, /api/v3/
+				if r.Method != http.MethodPost || r.URL.Path != "/api/graphql" {
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprintf(w, `{"message": "no response for %s %s"}`, r.Method, r.URL.Path)
+					return
+				}
+				var body struct {
+					Query     string            `json:"query"`
+					Variables map[string]string `json:"variables"`
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+				variables = body.Variables
+				fmt.Fprint(w, tt.response)
+			}))
+			defer server.Close()
+
+			client := github.NewClient(nil)
+			client.BaseURL, _ = url.Parse(server.URL + "/api/v3/")
+			ranges, err := NewGitHubClient(client).blameFile(context.Background(), "octo", "app", "main", "src/app.go")
+
+			expectedVariables := map[string]string{"owner": "octo", "name": "app", "expression": "main", "path": "src/app.go", "file": "main:src/app.go"}
+			if !reflect.DeepEqual(variables, expectedVariables) {
+				t.Errorf("Expected variables %v, got %v", expectedVariables, variables)
+			}
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("Expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("blameFile failed: %v", err)
+			}
+			if !reflect.DeepEqual(ranges, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, ranges)
+			}
+		})
+	}
+}
+
+func TestGitLabClient_BlameFile(t *testing.T) {
+	const files = "/api/v4/projects/group%2Fapp/repository/files"
+	api := &fakeREST{responses: map[string]string{
+		files + "/src%2Fapp%2Ego/blame": `[
+			{"commit": {"id": "c1", "author_name": "Alice", "author_email": "alice@example.com", "authored_date": "2024-01-01T00:00:00Z"}, "lines": ["a", "b"]},
+			{"commit": {"id": "c2", "author_name": "", "author_email": "bob@example.com", "authored_date": "2024-02-01T00:00:00Z"}, "lines": ["c"]},
+			{"commit": {"id": "c1", "author_name": "Alice", "author_email": "alice@example.com"}, "lines": ["d", "e", "f"]}]`,
+	}}
+	server := api.start(t)
+
+	client, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	c := NewGitLabClient(client)
+	ref := mustParseRepoRef(t, GitLab, "group/app")
+	ctx := context.Background()
+
+	ranges, err := c.blameFile(ctx, ref, "main", "src/app.go")
+	if err != nil {
+		t.Fatalf("blameFile failed: %v", err)
+	}
+	// Line numbers follow from the order and sizes of the groups
+	expected := []BlameRange{
+		{StartLine: 1, EndLine: 2, Author: "Alice", Commit: "c1", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{StartLine: 3, EndLine: 3, Author: "bob@example.com", Commit: "c2", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{StartLine: 4, EndLine: 6, Author: "Alice", Commit: "c1"},
+	}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, ranges)
+	}
+	if query := api.query(files + "/src%2Fapp%2Ego/blame"); query != "ref=main" {
+		t.Errorf("Expected the blame at main, got %s", query)
+	}
+
+	if _, err := c.blameFile(ctx, ref, "main", "src/new.go"); !errors.Is(err, errNotAtRef) {
+		t.Errorf("Expected %v for a file missing at main, got %v", errNotAtRef, err)
+	}
+}
@@ -0,0 +1,177 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// restAPI sends GET requests to the REST API of a provider without a client
+// library
+type restAPI struct {
+	client  *http.Client
+	baseURL string
+}
+
+// url returns the URL of path, relative to the API root, with a query
+func (a *restAPI) url(path string, query url.Values) string {
+	u := a.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// host returns the host of the API, which keys cached data
+func (a *restAPI) host() string {
+	u, err := url.Parse(a.baseURL)
+	if err != nil {
+		return a.baseURL
+	}
+	return u.Host
+}
+
+// getJSON decodes the response to a GET of rawURL into v
+func (a *restAPI) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	_, err := a.getJSONHeader(ctx, rawURL, v)
+	return err
+}
+
+// getJSONHeader decodes the response to a GET of rawURL into v, and returns
+// the response's headers for APIs that page through them
+func (a *restAPI) getJSONHeader(ctx context.Context, rawURL string, v interface{}) (http.Header, error) {
+	resp, err := a.get(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return resp.Header, nil
+}
+
+// getText returns the body of a GET of rawURL, for endpoints that serve
+// plain text such as diffs
+func (a *restAPI) getText(ctx context.Context, rawURL string) (string, error) {
+	resp, err := a.get(ctx, rawURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	text, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+	return string(text), nil
+}
+
+func (a *restAPI) get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, apiError(req, resp)
+	}
+	return resp, nil
+}
+
+// convertPullRequests converts listed pull requests one at a time, reporting
+// progress, since fetching the changed files takes requests per pull request
+func convertPullRequests[T any](ctx context.Context, prs []T, convert func(T) (*PullRequest, error)) ([]PullRequest, error) {
+	progress := newProgressCounter(ctx, PhaseListPullRequests, len(prs))
+	var result []PullRequest
+	for _, pr := range prs {
+		converted, err := convert(pr)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *converted)
+		progress.add()
+	}
+	return result, nil
+}
+
+// nextLink returns the URL of the next page from a Link header, or "" on the
+// last page
+func nextLink(header http.Header) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		return strings.Trim(strings.TrimSpace(target), "<>")
+	}
+	return ""
+}
+
+// apiError describes a failed request, with the message from the error body
+// when there is one: {"message": ...} from Gitea and Azure DevOps,
+// {"error": {...}} from Bitbucket Cloud or {"errors": [...]} from Bitbucket
+// Server
+func apiError(req *http.Request, resp *http.Response) error {
+	var body struct {
+		Message string `json:"message"`
+		Error   struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&body)
+
+	message := body.Message
+	if message == "" {
+		message = body.Error.Message
+	}
+	if message == "" && len(body.Errors) > 0 {
+		message = body.Errors[0].Message
+	}
+	if message == "" {
+		return &statusError{status: resp.StatusCode, message: fmt.Sprintf("GET %s: %s", req.URL.Path, resp.Status)}
+	}
+	return &statusError{status: resp.StatusCode, message: fmt.Sprintf("GET %s: %s: %s", req.URL.Path, resp.Status, message)}
+}
+
+// statusError is a request answered with a status other than 200 OK
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// isNotFound reports whether a request failed with 404 Not Found
+func isNotFound(err error) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr) && statusErr.status == http.StatusNotFound
+}
+
+// apiPath joins escaped path segments into an API path
+func apiPath(segments ...string) string {
+	var sb strings.Builder
+	for _, segment := range segments {
+		sb.WriteString("/")
+		sb.WriteString(url.PathEscape(segment))
+	}
+	return sb.String()
+}
+
+// apiFilePath escapes each directory of a file path, keeping the slashes
+func apiFilePath(path string) string {
+	return apiPath(strings.Split(path, "/")...)
+}
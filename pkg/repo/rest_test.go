@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeREST serves canned responses to the clients of REST APIs. Bodies and
+// headers may refer to the server's own URL as {{url}}, for next links.
+type fakeREST struct {
+	// responses are keyed by escaped path, followed by the paging parameters
+	// a request carries, as in /repos?page=2
+	responses map[string]string
+	// paging names the query parameters that select a page
+	paging []string
+	// headers are sent with the responses of the same keys
+	headers map[string]http.Header
+	// authorization is the Authorization header every request must carry
+	authorization string
+	// check turns a request away with 400 Bad Request when it fails
+	check func(*http.Request) error
+
+	mu      sync.Mutex
+	queries map[string]string
+}
+
+// start serves the responses until the test ends. Requests without a
+// response get 404 Not Found, with a message naming their key.
+func (f *fakeREST) start(t *testing.T) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.authorization != "" && r.Header.Get("Authorization") != f.authorization {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if f.check != nil {
+			if err := f.check(r); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"message": %q}`, err.Error())
+				return
+			}
+		}
+
+		f.mu.Lock()
+		if f.queries == nil {
+			f.queries = map[string]string{}
+		}
+		f.queries[r.URL.EscapedPath()] = r.URL.RawQuery
+		f.mu.Unlock()
+
+		key := r.URL.EscapedPath()
+		var params []string
+		for _, name := range f.paging {
+			if value := r.URL.Query().Get(name); value != "" {
+				params = append(params, name+"="+value)
+			}
+		}
+		if len(params) > 0 {
+			key += "?" + strings.Join(params, "&")
+		}
+
+		body, ok := f.responses[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"message": "no response for %s"}`, key)
+			return
+		}
+		for name, values := range f.headers[key] {
+			for _, value := range values {
+				w.Header().Add(name, strings.ReplaceAll(value, "{{url}}", server.URL))
+			}
+		}
+		fmt.Fprint(w, strings.ReplaceAll(body, "{{url}}", server.URL))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// client returns an HTTP client that sends the authorization with each
+// request, as the clients from the auth package do
+func (f *fakeREST) client() *http.Client {
+	return &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", f.authorization)
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+}
+
+// query returns the raw query of the last request for an escaped path
+func (f *fakeREST) query(path string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries[path]
+}
+
+// roundTripFunc adapts a function to http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,120 @@
+package repo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Window limits an analysis to a period of time and a ref. Zero values leave
+// the corresponding bound open.
+type Window struct {
+	// Since and Until bound the author date of the commits analysed
+	Since time.Time
+	Until time.Time
+	// Ref is the branch, tag or commit whose history is analysed; for blame
+	// it replaces the pull request's base commit
+	Ref string
+}
+
+// Contains reports whether t falls within the window. An unknown (zero)
+// time is always contained, since it cannot be ruled out.
+func (w Window) Contains(t time.Time) bool {
+	if t.IsZero() {
+		return true
+	}
+	if !w.Since.IsZero() && t.Before(w.Since) {
+		return false
+	}
+	if !w.Until.IsZero() && t.After(w.Until) {
+		return false
+	}
+	return true
+}
+
+// filter drops the blame ranges last changed outside the window
+func (w Window) filter(ranges []BlameRange) []BlameRange {
+	if w.Since.IsZero() && w.Until.IsZero() {
+		return ranges
+	}
+	var kept []BlameRange
+	for _, rng := range ranges {
+		if w.Contains(rng.Date) {
+			kept = append(kept, rng)
+		}
+	}
+	return kept
+}
+
+// LogArgs returns the `git log` arguments selecting the window. They must
+// come last, after any other options. Without a ref, every branch is
+// included, as a mirror has no checked out branch.
+func (w Window) LogArgs() []string {
+	var args []string
+	if !w.Since.IsZero() {
+		args = append(args, "--since="+w.Since.Format(time.RFC3339))
+	}
+	if !w.Until.IsZero() {
+		args = append(args, "--until="+w.Until.Format(time.RFC3339))
+	}
+	if w.Ref != "" {
+		// End of options, so a ref cannot be mistaken for a flag
+		return append(args, "--end-of-options", w.Ref, "--")
+	}
+	return append(args, "--all")
+}
+
+var relativeTime = regexp.MustCompile(`^(\d+)([hdwmy])$`)
+
+// dateLayout is the layout of a time given as a date alone
+const dateLayout = "2006-01-02"
+
+// ParseTime parses an absolute or relative point in time. It accepts
+// RFC 3339 timestamps, dates such as 2024-01-01, and ages relative to now
+// such as 36h, 90d, 12w, 6m (months) or 1y. An empty value is the zero time.
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if m := relativeTime.FindStringSubmatch(value); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q: %v", value, err)
+		}
+		switch m[2] {
+		case "h":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "d":
+			return now.AddDate(0, 0, -n), nil
+		case "w":
+			return now.AddDate(0, 0, -7*n), nil
+		case "m":
+			return now.AddDate(0, -n, 0), nil
+		default:
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(dateLayout, value, time.UTC); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use a date (2024-01-01), an RFC 3339 timestamp or an age such as 90d", value)
+}
+
+// ParseUntil parses the upper bound of a window as ParseTime does, except
+// that a date stands for the end of that day, so that it is included
+func ParseUntil(value string, now time.Time) (time.Time, error) {
+	t, err := ParseTime(value, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if _, err := time.Parse(dateLayout, value); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return t, nil
+}
@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "", want: time.Time{}},
+		{value: "36h", want: time.Date(2025, 6, 14, 0, 0, 0, 0, time.UTC)},
+		{value: "90d", want: time.Date(2025, 3, 17, 12, 0, 0, 0, time.UTC)},
+		{value: "2w", want: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)},
+		{value: "6m", want: time.Date(2024, 12, 15, 12, 0, 0, 0, time.UTC)},
+		{value: "1y", want: time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)},
+		{value: "2024-01-01", want: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{value: "2024-01-01T08:30:00Z", want: time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC)},
+		{value: "90", wantErr: true},
+		{value: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTime(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseUntil(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{value: "", want: time.Time{}},
+		{value: "90d", want: time.Date(2025, 3, 17, 12, 0, 0, 0, time.UTC)},
+		// A date includes the whole of that day
+		{value: "2024-03-31", want: time.Date(2024, 3, 31, 23, 59, 59, 999999999, time.UTC)},
+		{value: "2024-03-31T08:30:00Z", want: time.Date(2024, 3, 31, 8, 30, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseUntil(tt.value, now)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	until, _ := ParseUntil("2024-03-31", now)
+	window := Window{Until: until}
+	if !window.Contains(time.Date(2024, 3, 31, 18, 0, 0, 0, time.UTC)) {
+		t.Error("Expected the window to contain the evening of its last day")
+	}
+	if args := window.LogArgs(); args[0] != "--until=2024-03-31T23:59:59Z" {
+		t.Errorf("Expected --until=2024-03-31T23:59:59Z, got %v", args[0])
+	}
+	if _, err := ParseUntil("yesterday", now); err == nil {
+		t.Error("Expected an error for an invalid time")
+	}
+}
+
+func TestWindow_Filter(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+	ranges := []BlameRange{
+		{StartLine: 1, EndLine: 1, Commit: "a", Date: day(1)},
+		{StartLine: 2, EndLine: 2, Commit: "b", Date: day(10)},
+		{StartLine: 3, EndLine: 3, Commit: "c", Date: day(20)},
+		{StartLine: 4, EndLine: 4, Commit: "d"},
+	}
+
+	got := Window{Since: day(5), Until: day(15)}.filter(ranges)
+
+	// Ranges without a date cannot be ruled out and are kept
+	if len(got) != 2 || got[0].Commit != "b" || got[1].Commit != "d" {
+		t.Errorf("Expected commits b and d, got %+v", got)
+	}
+}
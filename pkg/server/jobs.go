@@ -0,0 +1,478 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andrewweb/hackday/pkg/repo"
+)
+
+// JobStatus is the state of an asynchronous analysis
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// Job is the state of an asynchronous analysis, as returned by /jobs
+type Job struct {
+	ID         string              `json:"id"`
+	Name       string              `json:"name"`
+	Status     JobStatus           `json:"status"`
+	CreatedAt  time.Time           `json:"createdAt"`
+	StartedAt  *time.Time          `json:"startedAt,omitempty"`
+	FinishedAt *time.Time          `json:"finishedAt,omitempty"`
+	Result     *AnalysisResponseV2 `json:"result,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// ProgressEvent is the data of a progress event on /jobs/{id}/events. Done
+// and Total count the items of the phase when known, and Authors holds the
+// lines blamed on each author so far.
+type ProgressEvent struct {
+	Phase   string         `json:"phase"`
+	Done    int            `json:"done,omitempty"`
+	Total   int            `json:"total,omitempty"`
+	Authors map[string]int `json:"authors,omitempty"`
+}
+
+var (
+	errQueueFull   = errors.New("job queue is full")
+	errJobNotFound = errors.New("job not found")
+	errJobFinished = errors.New("job already finished")
+)
+
+// job is a queued or running analysis. Everything but the request and
+// context is guarded by the queue's mutex.
+type job struct {
+	id     string
+	owner  string // the authenticated caller who submitted the job, if any
+	req    *AnalysisRequest
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	status   JobStatus
+	created  time.Time
+	started  time.Time
+	finished time.Time
+	result   *AnalysisResponseV2
+	err      string
+
+	events    []jobEvent
+	lastEvent int
+	changed   chan struct{} // closed and replaced when an event is recorded
+}
+
+// jobEvent is an event of a job's stream, with its data encoded as JSON
+type jobEvent struct {
+	id    int
+	name  string
+	phase string
+	data  []byte
+}
+
+// jobQueue runs jobs on a fixed number of workers. Jobs wait in a queue of
+// bounded depth, and finished jobs are kept for the retention period so
+// clients can collect their results.
+type jobQueue struct {
+	run       func(ctx context.Context, req *AnalysisRequest) (*AnalysisResponseV2, error)
+	queue     chan *job
+	retention time.Duration
+	ctx       context.Context
+	stop      context.CancelFunc
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobQueue(workers, depth int, retention time.Duration, run func(context.Context, *AnalysisRequest) (*AnalysisResponseV2, error)) *jobQueue {
+	ctx, stop := context.WithCancel(context.Background())
+	q := &jobQueue{
+		run:       run,
+		queue:     make(chan *job, depth),
+		retention: retention,
+		ctx:       ctx,
+		stop:      stop,
+		jobs:      map[string]*job{},
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// submit queues a job, failing with errQueueFull rather than waiting
+func (q *jobQueue) submit(owner string, req *AnalysisRequest) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	j := &job{id: id, owner: owner, req: req, status: JobQueued, created: time.Now(), changed: make(chan struct{})}
+	ctx, cancel := context.WithCancel(q.ctx)
+	j.ctx = repo.WithProgress(ctx, func(p repo.Progress) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		j.record("progress", p.Phase, ProgressEvent{Phase: p.Phase, Done: p.Done, Total: p.Total, Authors: p.Authors})
+	})
+	j.cancel = cancel
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune()
+
+	select {
+	case q.queue <- j:
+	default:
+		cancel()
+		return Job{}, errQueueFull
+	}
+	q.jobs[id] = j
+	j.recordStatus()
+	return j.view(), nil
+}
+
+func (q *jobQueue) get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+	return j.view(), nil
+}
+
+// ownedBy reports whether a job exists and was submitted by owner. Other
+// callers are told it does not exist.
+func (q *jobQueue) ownedBy(id, owner string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	return ok && j.owner == owner
+}
+
+// cancelJob cancels a job. A queued job is cancelled at once, while a running
+// job stops when its analysis next checks the context.
+func (q *jobQueue) cancelJob(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+	switch j.status {
+	case JobQueued:
+		j.status = JobCancelled
+		j.finished = time.Now()
+		j.forgetToken()
+		j.recordStatus()
+	case JobSucceeded, JobFailed, JobCancelled:
+		return j.view(), errJobFinished
+	}
+	j.cancel()
+	return j.view(), nil
+}
+
+// events returns the events of a job recorded after the given event ID, a
+// channel closed when more are recorded, and whether the job has finished,
+// in which case there will be no more
+func (q *jobQueue) events(id string, after int) ([]jobEvent, <-chan struct{}, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return nil, nil, false, errJobNotFound
+	}
+	var events []jobEvent
+	for _, event := range j.events {
+		if event.id > after {
+			events = append(events, event)
+		}
+	}
+	return events, j.changed, !j.finished.IsZero(), nil
+}
+
+// close cancels every job and stops the workers
+func (q *jobQueue) close() {
+	q.stop()
+}
+
+// work runs queued jobs until the queue is closed, pruning finished jobs
+// between them so they expire even when no more are submitted
+func (q *jobQueue) work() {
+	prune := time.NewTicker(q.pruneInterval())
+	defer prune.Stop()
+
+	for {
+		var j *job
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-prune.C:
+			q.mu.Lock()
+			q.prune()
+			q.mu.Unlock()
+			continue
+		case j = <-q.queue:
+		}
+
+		q.mu.Lock()
+		if j.status != JobQueued {
+			// Cancelled while queued
+			q.mu.Unlock()
+			continue
+		}
+		j.status = JobRunning
+		j.started = time.Now()
+		j.recordStatus()
+		q.mu.Unlock()
+
+		result, err := q.run(j.ctx, j.req)
+
+		q.mu.Lock()
+		j.finished = time.Now()
+		switch {
+		case j.ctx.Err() != nil:
+			j.status = JobCancelled
+		case err != nil:
+			j.status = JobFailed
+			j.err = err.Error()
+		default:
+			j.status = JobSucceeded
+			j.result = result
+		}
+		j.forgetToken()
+		j.recordStatus()
+		q.mu.Unlock()
+		j.cancel()
+	}
+}
+
+// prune forgets the jobs finished longer than the retention period ago. The
+// caller holds the mutex.
+func (q *jobQueue) prune() {
+	cutoff := time.Now().Add(-q.retention)
+	for id, j := range q.jobs {
+		if !j.finished.IsZero() && j.finished.Before(cutoff) {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// pruneInterval is how often workers prune finished jobs: a fraction of the
+// retention period, but at least once a minute
+func (q *jobQueue) pruneInterval() time.Duration {
+	interval := q.retention / 4
+	if interval <= 0 || interval > time.Minute {
+		interval = time.Minute
+	}
+	return interval
+}
+
+// forgetToken drops the token from a finished job's request, so it is not
+// kept for as long as the job's result. The caller holds the queue's mutex.
+func (j *job) forgetToken() {
+	delete(j.req.Arguments, "token")
+}
+
+// record adds an event to the job's stream and wakes its subscribers. A
+// progress event replaces the previous one of the same phase, so the stream
+// stays short however many items a phase has. The caller holds the queue's
+// mutex.
+func (j *job) record(name, phase string, data interface{}) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	j.lastEvent++
+	event := jobEvent{id: j.lastEvent, name: name, phase: phase, data: encoded}
+	if n := len(j.events); n > 0 && name == "progress" && j.events[n-1].name == name && j.events[n-1].phase == phase {
+		j.events[n-1] = event
+	} else {
+		j.events = append(j.events, event)
+	}
+
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// recordStatus adds the job's current state to its stream
+func (j *job) recordStatus() {
+	j.record("status", "", j.view())
+}
+
+// view copies the job's state; the caller holds the queue's mutex
+func (j *job) view() Job {
+	view := Job{
+		ID:        j.id,
+		Name:      j.req.Name,
+		Status:    j.status,
+		CreatedAt: j.created,
+		Result:    j.result,
+		Error:     j.err,
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		view.StartedAt = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		view.FinishedAt = &finished
+	}
+	return view
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// runJob runs the analysis of a job, encoding the result as /v2/messages does
+func (s *Server) runJob(ctx context.Context, req *AnalysisRequest) (*AnalysisResponseV2, error) {
+	start := time.Now()
+	result, rerr := s.analyze(ctx, req)
+	if rerr != nil {
+		return nil, rerr
+	}
+	response := newResponseV2(req, result)
+	response.Metadata.DurationMS = time.Since(start).Milliseconds()
+	return &response, nil
+}
+
+// handleJobs queues a message as a job and answers at once with its ID.
+// Results are fetched from /jobs/{id}.
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	req, err := s.validate(w, r)
+	if err != nil {
+		return
+	}
+
+	job, err := s.jobs.submit(callerName(r.Context()), req)
+	if errors.Is(err, errQueueFull) {
+		w.Header().Set("Retry-After", "10")
+		sendErrorResponse(w, "Job queue is full, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, "Failed to create job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleJob reports the status and result of a job on GET, and cancels it on
+// DELETE
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	var job Job
+	var err error
+	if !s.jobs.ownedBy(r.PathValue("id"), callerName(r.Context())) {
+		err = errJobNotFound
+	} else {
+		switch r.Method {
+		case http.MethodGet:
+			job, err = s.jobs.get(r.PathValue("id"))
+		case http.MethodDelete:
+			job, err = s.jobs.cancelJob(r.PathValue("id"))
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	}
+
+	switch {
+	case errors.Is(err, errJobNotFound):
+		sendErrorResponse(w, "Job not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errJobFinished):
+		sendErrorResponse(w, "Job already finished", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleJobEvents streams a job's events with Server-Sent Events: a status
+// event whenever the job changes state, and progress events as its analysis
+// moves through phases. Earlier events are replayed first, from after the
+// Last-Event-ID when reconnecting, and the stream ends with the job.
+func (s *Server) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendErrorResponse(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	lastID, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	if !s.jobs.ownedBy(id, callerName(r.Context())) {
+		sendErrorResponse(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Ask proxies such as nginx not to buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Comments keep idle connections from being closed by proxies
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		events, changed, finished, err := s.jobs.events(id, lastID)
+		if err != nil {
+			// The job expired
+			return
+		}
+		for _, event := range events {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.id, event.name, event.data)
+			lastID = event.id
+		}
+		flusher.Flush()
+		if finished {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
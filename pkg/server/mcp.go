@@ -0,0 +1,581 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrewweb/hackday/pkg/access"
+	"github.com/andrewweb/hackday/pkg/auth"
+	"github.com/andrewweb/hackday/pkg/maat"
+	"github.com/andrewweb/hackday/pkg/repo"
+)
+
+// Version is reported to MCP clients; set it at build time with -ldflags
+var Version = "dev"
+
+// mcpProtocolVersions are the MCP revisions the server speaks, newest first
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// maxReports is how many analysis reports are kept as MCP resources
+const maxReports = 100
+
+// reportURIPrefix starts the URI of every report resource
+const reportURIPrefix = "git-analyzer://reports/"
+
+// rpcMessage is an incoming JSON-RPC request or notification. Notifications
+// have no ID.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type mcpInitializeResult struct {
+	ProtocolVersion string                 `json:"protocolVersion"`
+	Capabilities    map[string]interface{} `json:"capabilities"`
+	ServerInfo      mcpImplementation      `json:"serverInfo"`
+	Instructions    string                 `json:"instructions,omitempty"`
+}
+
+type mcpImplementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type mcpTool struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	InputSchema jsonSchema `json:"inputSchema"`
+}
+
+// jsonSchema is the subset of JSON Schema used for tool inputs
+type jsonSchema struct {
+	Type        string                `json:"type"`
+	Description string                `json:"description,omitempty"`
+	Enum        []string              `json:"enum,omitempty"`
+	Properties  map[string]jsonSchema `json:"properties,omitempty"`
+	Required    []string              `json:"required,omitempty"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolResult struct {
+	Content           []mcpContent `json:"content"`
+	StructuredContent interface{}  `json:"structuredContent,omitempty"`
+	IsError           bool         `json:"isError,omitempty"`
+}
+
+type mcpPromptMessage struct {
+	Role    string     `json:"role"`
+	Content mcpContent `json:"content"`
+}
+
+type mcpPromptResult struct {
+	Description string             `json:"description"`
+	Messages    []mcpPromptMessage `json:"messages"`
+}
+
+type mcpResource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType"`
+}
+
+type mcpResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// reportStore keeps the most recent analysis reports, which MCP clients can
+// read back as resources. Callers only see the reports of their own analyses.
+type reportStore struct {
+	mu      sync.Mutex
+	next    int
+	reports []report
+}
+
+type report struct {
+	owner    string
+	resource mcpResource
+	text     string
+}
+
+// add stores a report and returns its URI, dropping the oldest beyond maxReports
+func (s *reportStore) add(owner, name, description, text string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next++
+	uri := fmt.Sprintf("%s%d", reportURIPrefix, s.next)
+	s.reports = append(s.reports, report{
+		owner:    owner,
+		resource: mcpResource{URI: uri, Name: name, Description: description, MimeType: "application/json"},
+		text:     text,
+	})
+	if len(s.reports) > maxReports {
+		s.reports = s.reports[len(s.reports)-maxReports:]
+	}
+	return uri
+}
+
+// list returns the reports of owner, newest first
+func (s *reportStore) list(owner string) []mcpResource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resources := make([]mcpResource, 0, len(s.reports))
+	for i := len(s.reports) - 1; i >= 0; i-- {
+		if s.reports[i].owner == owner {
+			resources = append(resources, s.reports[i].resource)
+		}
+	}
+	return resources
+}
+
+func (s *reportStore) get(owner, uri string) (report, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.reports {
+		if r.resource.URI == uri && r.owner == owner {
+			return r, true
+		}
+	}
+	return report{}, false
+}
+
+// callerName names the authenticated caller of a request, or is empty
+func callerName(ctx context.Context) string {
+	if principal, ok := access.FromContext(ctx); ok {
+		return principal.Name
+	}
+	return ""
+}
+
+// ServeMCP speaks the Model Context Protocol over stdio: one JSON-RPC message
+// per line on in, with responses written to out. Requests run concurrently
+// and can be cancelled by the client. Tokens missing from tool calls are read
+// from the environment, as the client started the server on the user's
+// behalf. It returns when in is closed or ctx is done.
+func (s *Server) ServeMCP(ctx context.Context, in io.Reader, out io.Writer) error {
+	var (
+		mu       sync.Mutex // guards out and inFlight
+		inFlight = map[string]context.CancelFunc{}
+		wg       sync.WaitGroup
+	)
+	defer wg.Wait()
+
+	write := func(resp *rpcResponse) {
+		data, err := json.Marshal(resp)
+		if err != nil {
+			data, _ = json.Marshal(rpcFailure(resp.ID, rpcInternalError, fmt.Sprintf("Failed to encode response: %v", err)))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		out.Write(append(data, '\n'))
+	}
+
+	// Read in the background, so a blocked read does not delay shutdown
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		for scanner.Scan() {
+			select {
+			case lines <- append([]byte(nil), scanner.Bytes()...):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		var line []byte
+		select {
+		case <-ctx.Done():
+			return nil
+		case l, ok := <-lines:
+			if !ok {
+				return scanner.Err()
+			}
+			line = l
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		var msg rpcMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			write(parseFailure(line))
+			continue
+		}
+
+		// Notifications get no response; a cancellation stops the request
+		// it names
+		if len(msg.ID) == 0 {
+			if msg.Method == "notifications/cancelled" {
+				var params struct {
+					RequestID json.RawMessage `json:"requestId"`
+				}
+				if json.Unmarshal(msg.Params, &params) == nil {
+					mu.Lock()
+					if cancel, ok := inFlight[string(params.RequestID)]; ok {
+						cancel()
+					}
+					mu.Unlock()
+				}
+			}
+			continue
+		}
+
+		reqCtx, cancel := context.WithCancel(ctx)
+		mu.Lock()
+		inFlight[string(msg.ID)] = cancel
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := s.handleRPC(reqCtx, &msg, true)
+
+			mu.Lock()
+			delete(inFlight, string(msg.ID))
+			mu.Unlock()
+
+			// A cancelled request is not answered
+			if reqCtx.Err() == nil {
+				write(resp)
+			}
+			cancel()
+		}()
+	}
+}
+
+// handleMCP serves MCP over streamable HTTP. Each POST carries one JSON-RPC
+// message, and requests are answered in the response body. The server never
+// starts requests of its own, so it offers no event stream and GET is not
+// allowed.
+func (s *Server) handleMCP(w http.ResponseWriter, r *http.Request) {
+	// Browsers send an Origin; reject other sites to prevent DNS rebinding
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if version := r.Header.Get("MCP-Protocol-Version"); version != "" && !supportedProtocol(version) {
+		http.Error(w, fmt.Sprintf("Unsupported MCP protocol version: %s", version), http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var msg rpcMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		writeRPC(w, http.StatusBadRequest, parseFailure(body))
+		return
+	}
+
+	// Notifications and responses are only acknowledged
+	if len(msg.ID) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	writeRPC(w, http.StatusOK, s.handleRPC(r.Context(), &msg, false))
+}
+
+func writeRPC(w http.ResponseWriter, status int, resp *rpcResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleRPC answers a JSON-RPC request. envTokens allows tool calls to fall
+// back to tokens from the environment.
+func (s *Server) handleRPC(ctx context.Context, msg *rpcMessage, envTokens bool) *rpcResponse {
+	if msg.JSONRPC != "2.0" || msg.Method == "" {
+		return rpcFailure(msg.ID, rpcInvalidRequest, "Invalid request")
+	}
+
+	var result interface{}
+	var rerr *rpcError
+	switch msg.Method {
+	case "initialize":
+		result, rerr = mcpInitialize(msg.Params)
+	case "ping":
+		result = struct{}{}
+	case "tools/list":
+		result = map[string]interface{}{"tools": mcpTools()}
+	case "tools/call":
+		result, rerr = s.mcpCallTool(ctx, msg.Params, envTokens)
+	case "prompts/list":
+		result = map[string]interface{}{"prompts": prompts()}
+	case "prompts/get":
+		result, rerr = mcpGetPrompt(msg.Params)
+	case "resources/list":
+		result = map[string]interface{}{"resources": s.reports.list(callerName(ctx))}
+	case "resources/read":
+		result, rerr = s.mcpReadResource(ctx, msg.Params)
+	default:
+		rerr = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("Method not found: %s", msg.Method)}
+	}
+
+	if rerr != nil {
+		return &rpcResponse{JSONRPC: "2.0", ID: msg.ID, Error: rerr}
+	}
+	return &rpcResponse{JSONRPC: "2.0", ID: msg.ID, Result: result}
+}
+
+func rpcFailure(id json.RawMessage, code int, message string) *rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}}
+}
+
+// parseFailure answers a message that could not be decoded. Valid JSON that
+// is not a single message, such as a batch, is an invalid request.
+func parseFailure(data []byte) *rpcResponse {
+	if json.Valid(data) {
+		return rpcFailure(nil, rpcInvalidRequest, "Invalid request")
+	}
+	return rpcFailure(nil, rpcParseError, "Parse error")
+}
+
+func supportedProtocol(version string) bool {
+	for _, supported := range mcpProtocolVersions {
+		if version == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// mcpInitialize agrees on the protocol version: the client's if supported,
+// otherwise the newest the server speaks
+func mcpInitialize(params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("Invalid params: %v", err)}
+	}
+
+	version := mcpProtocolVersions[0]
+	if supportedProtocol(p.ProtocolVersion) {
+		version = p.ProtocolVersion
+	}
+	return mcpInitializeResult{
+		ProtocolVersion: version,
+		Capabilities: map[string]interface{}{
+			"tools":     map[string]interface{}{},
+			"prompts":   map[string]interface{}{},
+			"resources": map[string]interface{}{},
+		},
+		ServerInfo:   mcpImplementation{Name: "git-analyzer", Version: Version},
+		Instructions: "Call git-blame to see who owns the lines a pull request changes, git-log to run code-maat analyses over a repository's history, and pr-overlap to find open pull requests likely to conflict. Completed analyses are kept as resources.",
+	}, nil
+}
+
+// mcpTools describes each message as a tool, with a JSON Schema built from
+// its prompt arguments
+func mcpTools() []mcpTool {
+	var tools []mcpTool
+	for _, prompt := range prompts() {
+		schema := jsonSchema{Type: "object", Properties: map[string]jsonSchema{}}
+		for _, arg := range prompt.Arguments {
+			property := jsonSchema{Type: "string", Description: arg.Description}
+			switch arg.Name {
+			case "provider":
+				property.Enum = []string{string(repo.GitHub), string(repo.GitLab), string(repo.Bitbucket), string(repo.Gitea), string(repo.Azure), string(repo.Local)}
+				if prompt.Name == "git-blame" {
+					property.Enum = blameProviders()
+				}
+			case "pullRequest":
+				property.Type = "integer"
+			case "analysis":
+				property.Enum = maat.Analyses()
+			}
+			schema.Properties[arg.Name] = property
+
+			// Whether a token or pull request is needed depends on the
+			// provider, so it is checked when the tool is called
+			if arg.Required && arg.Name != "token" && arg.Name != "pullRequest" {
+				schema.Required = append(schema.Required, arg.Name)
+			}
+		}
+		tools = append(tools, mcpTool{Name: prompt.Name, Description: prompt.Description, InputSchema: schema})
+	}
+	return tools
+}
+
+// mcpCallTool runs an analysis. Failures of the analysis itself are reported
+// in the result, so the model can see them, rather than as protocol errors.
+func (s *Server) mcpCallTool(ctx context.Context, params json.RawMessage, envTokens bool) (interface{}, *rpcError) {
+	var p struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("Invalid params: %v", err)}
+	}
+	if p.Name != "git-blame" && p.Name != "git-log" && p.Name != "pr-overlap" {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("Unknown tool: %s", p.Name)}
+	}
+	if p.Arguments == nil {
+		p.Arguments = map[string]interface{}{}
+	}
+	var fallback func(repo.ProviderType) string
+	if envTokens {
+		fallback = func(provider repo.ProviderType) string {
+			return auth.GetTokenFromEnv(string(provider))
+		}
+	}
+
+	start := time.Now()
+	req := &AnalysisRequest{Name: p.Name, Arguments: p.Arguments}
+	if rerr := s.parseArguments(req); rerr != nil {
+		return toolError(rerr.message), nil
+	}
+	if rerr := authorize(ctx, req); rerr != nil {
+		return toolError(rerr.message), nil
+	}
+	if rerr := s.resolveToken(req, fallback); rerr != nil {
+		return toolError(rerr.message), nil
+	}
+	result, rerr := s.analyze(ctx, req)
+	if rerr != nil {
+		return toolError(rerr.message), nil
+	}
+
+	response := newResponseV2(req, result)
+	response.Metadata.DurationMS = time.Since(start).Milliseconds()
+	text, err := json.Marshal(response)
+	if err != nil {
+		return toolError(fmt.Sprintf("Failed to encode result: %v", err)), nil
+	}
+
+	name := fmt.Sprintf("%s %s #%d", req.Name, response.Metadata.Repository, response.Metadata.PullRequest)
+	switch req.Name {
+	case "git-log":
+		name = fmt.Sprintf("%s %s (%s)", req.Name, response.Metadata.Repository, req.Arguments["analysis"])
+	case "pr-overlap":
+		name = fmt.Sprintf("%s %s", req.Name, response.Metadata.Repository)
+	}
+	s.reports.add(callerName(ctx), name, fmt.Sprintf("%s, completed %s", response.Message, time.Now().UTC().Format(time.RFC3339)), string(text))
+
+	return mcpToolResult{
+		Content:           []mcpContent{{Type: "text", Text: string(text)}},
+		StructuredContent: response,
+	}, nil
+}
+
+func toolError(message string) mcpToolResult {
+	return mcpToolResult{Content: []mcpContent{{Type: "text", Text: message}}, IsError: true}
+}
+
+// mcpGetPrompt turns a prompt and its arguments into a message asking the
+// model to call the matching tool
+func mcpGetPrompt(params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Name      string            `json:"name"`
+		Arguments map[string]string `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("Invalid params: %v", err)}
+	}
+
+	for _, prompt := range prompts() {
+		if prompt.Name != p.Name {
+			continue
+		}
+
+		var args []string
+		for _, arg := range prompt.Arguments {
+			value, ok := p.Arguments[arg.Name]
+			if !ok {
+				if arg.Required && arg.Name != "token" {
+					return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("Missing required argument: %s", arg.Name)}
+				}
+				continue
+			}
+			// Tokens are never echoed into the conversation
+			if arg.Name != "token" {
+				args = append(args, fmt.Sprintf("%s=%s", arg.Name, value))
+			}
+		}
+
+		text := fmt.Sprintf("Call the %s tool with %s, then summarize the result: %s", prompt.Name, strings.Join(args, ", "), prompt.Description)
+		return mcpPromptResult{
+			Description: prompt.Description,
+			Messages:    []mcpPromptMessage{{Role: "user", Content: mcpContent{Type: "text", Text: text}}},
+		}, nil
+	}
+	return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("Unknown prompt: %s", p.Name)}
+}
+
+func (s *Server) mcpReadResource(ctx context.Context, params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("Invalid params: %v", err)}
+	}
+
+	r, ok := s.reports.get(callerName(ctx), p.URI)
+	if !ok {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("Resource not found: %s", p.URI)}
+	}
+	return map[string]interface{}{
+		"contents": []mcpResourceContents{{URI: r.resource.URI, MimeType: r.resource.MimeType, Text: r.text}},
+	}, nil
+}
@@ -1,18 +1,28 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/andrewweb/hackday/pkg/access"
 	"github.com/andrewweb/hackday/pkg/auth"
+	"github.com/andrewweb/hackday/pkg/maat"
+	"github.com/andrewweb/hackday/pkg/overlap"
+	"github.com/andrewweb/hackday/pkg/render"
 	"github.com/andrewweb/hackday/pkg/repo"
+	"github.com/andrewweb/hackday/pkg/workspace"
 	"github.com/google/go-github/v45/github"
 	"github.com/xanzy/go-gitlab"
 )
@@ -42,34 +52,185 @@ type Prompt struct {
 }
 
 type Server struct {
-	port int
-	mux  *http.ServeMux
+	port    int
+	mux     *http.ServeMux
+	reports *reportStore
+	jobs    *jobQueue
+
+	workspace    *workspace.Manager
+	credentials  *auth.CredentialStore
+	config       *auth.Config
+	inlineTokens bool
+	// baseURLs are the instances, besides each provider's public service and
+	// configured instance, that requests may name
+	baseURLs []string
+	// localRoot is the directory local repositories must be under; empty
+	// turns the local provider away
+	localRoot    string
+	workers      int
+	queueDepth   int
+	jobRetention time.Duration
+
+	// authenticator identifies callers; nil leaves the server open
+	authenticator access.Authenticator
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithWorkers sets how many jobs run at once
+func WithWorkers(n int) Option {
+	return func(s *Server) {
+		s.workers = n
+	}
+}
+
+// WithQueueDepth sets how many jobs may wait for a worker before new jobs are
+// turned away
+func WithQueueDepth(n int) Option {
+	return func(s *Server) {
+		s.queueDepth = n
+	}
+}
+
+// WithJobRetention sets how long the results of finished jobs are kept
+func WithJobRetention(d time.Duration) Option {
+	return func(s *Server) {
+		s.jobRetention = d
+	}
+}
+
+// WithWorkspace sets where repositories are mirrored for git-log
+func WithWorkspace(m *workspace.Manager) Option {
+	return func(s *Server) {
+		s.workspace = m
+	}
+}
+
+// WithCredentials sets the credentials requests may reference by name. Start
+// refuses to serve them over HTTP unless callers are authenticated.
+func WithCredentials(store *auth.CredentialStore) Option {
+	return func(s *Server) {
+		s.credentials = store
+	}
+}
+
+// WithConfig sets the providers' configuration, which gives the instances
+// requests without a base URL are sent to
+func WithConfig(config *auth.Config) Option {
+	return func(s *Server) {
+		s.config = config
+	}
+}
+
+// WithAllowedBaseURLs lets requests name the instances at urls as their base
+// URL, besides the provider's public service and configured instance
+func WithAllowedBaseURLs(urls []string) Option {
+	return func(s *Server) {
+		s.baseURLs = urls
+	}
+}
+
+// WithInlineTokens sets whether requests may carry their own tokens, rather
+// than only reference credentials
+func WithInlineTokens(allowed bool) Option {
+	return func(s *Server) {
+		s.inlineTokens = allowed
+	}
+}
+
+// WithLocalRoot lets requests use the local provider on repositories under
+// dir. Without it, the server turns the local provider away, as it would
+// otherwise read any repository on its disk.
+func WithLocalRoot(dir string) Option {
+	return func(s *Server) {
+		s.localRoot = dir
+	}
+}
+
+// WithAuthenticator requires every request to be authenticated by a, and
+// limits callers to the analyses, repositories and credentials their scope
+// allows
+func WithAuthenticator(a access.Authenticator) Option {
+	return func(s *Server) {
+		s.authenticator = a
+	}
 }
 
-func NewServer(port int) *Server {
+func NewServer(port int, opts ...Option) *Server {
 	mux := http.NewServeMux()
 	server := &Server{
-		port: port,
-		mux:  mux,
+		port:         port,
+		mux:          mux,
+		reports:      &reportStore{},
+		workspace:    workspace.New(filepath.Join(os.TempDir(), "repo-analyzer-mirrors"), 0),
+		credentials:  auth.NewCredentialStore(),
+		config:       &auth.Config{},
+		inlineTokens: true,
+		workers:      4,
+		queueDepth:   64,
+		jobRetention: time.Hour,
 	}
+	for _, opt := range opts {
+		opt(server)
+	}
+	if server.workers < 1 {
+		server.workers = 1
+	}
+	server.jobs = newJobQueue(server.workers, server.queueDepth, server.jobRetention, server.runJob)
 
-	// Register routes
+	// Register routes; /messages is the original, unversioned path of /v1
 	mux.HandleFunc("/messages", server.handleMessages)
+	mux.HandleFunc("/v1/messages", server.handleMessages)
+	mux.HandleFunc("/v2/messages", server.handleMessagesV2)
 	mux.HandleFunc("/prompts", server.handlePrompts)
+	mux.HandleFunc("/mcp", server.handleMCP)
+	mux.HandleFunc("/jobs", server.handleJobs)
+	mux.HandleFunc("/jobs/{id}", server.handleJob)
+	mux.HandleFunc("/jobs/{id}/events", server.handleJobEvents)
 
 	return server
 }
 
-func (s *Server) Start() error {
-	// Add logging middleware
-	handler := loggingMiddleware(s.mux)
+// Start serves requests until ctx is cancelled. Request contexts derive from
+// ctx, so in-flight analyses and jobs stop when the server shuts down.
+func (s *Server) Start(ctx context.Context) error {
+	// Anyone who can reach the port could use stored credentials by name
+	if s.authenticator == nil && len(s.credentials.Names()) > 0 {
+		return fmt.Errorf("the server holds credentials (%s) but does not authenticate callers; configure authentication with --auth-config", strings.Join(s.credentials.Names(), ", "))
+	}
+
+	// Add logging and authentication middleware
+	handler := loggingMiddleware(s.authenticate(s.mux))
+
+	httpServer := &http.Server{
+		Addr:        fmt.Sprintf(":%d", s.port),
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.jobs.close()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
 
-	addr := fmt.Sprintf(":%d", s.port)
 	fmt.Printf("Starting server on port %d...\n", s.port)
 	fmt.Printf("Registered routes:\n")
 	fmt.Printf("- GET /prompts\n")
 	fmt.Printf("- POST /messages\n")
-	return http.ListenAndServe(addr, handler)
+	fmt.Printf("- POST /v1/messages\n")
+	fmt.Printf("- POST /v2/messages\n")
+	fmt.Printf("- POST /mcp\n")
+	fmt.Printf("- POST /jobs\n")
+	fmt.Printf("- GET, DELETE /jobs/{id}\n")
+	fmt.Printf("- GET /jobs/{id}/events\n")
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -79,6 +240,82 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// maxUnauthenticatedBody is the most of a request body that is read before its
+// caller is authenticated
+const maxUnauthenticatedBody = 1 << 20
+
+// authenticate rejects requests the authenticator does not accept, and passes
+// the caller of the others on in the request context
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if s.authenticator == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// HMAC signatures cover the body, so it is read here and replaced.
+		// Callers are not known yet, so how much is read is bounded.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUnauthenticatedBody))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			sendErrorResponse(w, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		principal, err := s.authenticator.Authenticate(r, body)
+		if err != nil {
+			message := "Authentication required"
+			if !errors.Is(err, access.ErrNoCredentials) {
+				log.Printf("Authentication failed from %s: %v", r.RemoteAddr, err)
+				message = fmt.Sprintf("Authentication failed: %v", err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="repo-analyzer"`)
+			sendErrorResponse(w, message, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(access.NewContext(r.Context(), principal)))
+	})
+}
+
+// authorize checks a request with parsed arguments against the scope of its
+// caller. Requests without a caller, such as those over stdio, are allowed.
+func authorize(ctx context.Context, req *AnalysisRequest) *requestError {
+	principal, ok := access.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	analysis := req.Arguments["analysis"].(string)
+	if !principal.Scope.AllowsAnalysis(req.Name, analysis) {
+		message := fmt.Sprintf("%s may not run %s", principal.Name, req.Name)
+		if req.Name == "git-log" {
+			message = fmt.Sprintf("%s may not run the %s analysis", principal.Name, analysis)
+		}
+		return &requestError{status: http.StatusForbidden, message: message, reason: "analysis not allowed"}
+	}
+	repository := req.Arguments["repository"].(string)
+	if !principal.Scope.AllowsRepository(repository) {
+		return &requestError{
+			status:  http.StatusForbidden,
+			message: fmt.Sprintf("%s may not analyse %s", principal.Name, repository),
+			reason:  "repository not allowed",
+		}
+	}
+	credential := req.Arguments["credential"].(string)
+	if !principal.Scope.AllowsCredential(credential) {
+		return &requestError{
+			status:  http.StatusForbidden,
+			message: fmt.Sprintf("%s may not use the credential %s", principal.Name, credential),
+			reason:  "credential not allowed",
+		}
+	}
+	return nil
+}
+
 func (s *Server) validate(w http.ResponseWriter, r *http.Request) (*AnalysisRequest, error) {
 	// Only allow POST requests
 	if r.Method != http.MethodPost {
@@ -111,161 +348,415 @@ func (s *Server) validate(w http.ResponseWriter, r *http.Request) (*AnalysisRequ
 		return nil, fmt.Errorf("invalid JSON format")
 	}
 
+	if rerr := s.parseArguments(&req); rerr != nil {
+		sendErrorResponse(w, rerr.message, rerr.status)
+		return nil, rerr
+	}
+	if rerr := authorize(r.Context(), &req); rerr != nil {
+		sendErrorResponse(w, rerr.message, rerr.status)
+		return nil, rerr
+	}
+	if rerr := s.resolveToken(&req, nil); rerr != nil {
+		sendErrorResponse(w, rerr.message, rerr.status)
+		return nil, rerr
+	}
+
+	// The output query parameter asks for a rendered document instead of the
+	// default response shape
+	var output render.Format
+	if name := r.URL.Query().Get("output"); name != "" {
+		if output, err = render.ParseFormat(name); err != nil {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return nil, fmt.Errorf("invalid output format")
+		}
+	}
+	req.Arguments["output"] = output
+
+	return &req, nil
+}
+
+// parseArguments checks the name and arguments of a request, replacing the
+// arguments with typed values
+func (s *Server) parseArguments(req *AnalysisRequest) *requestError {
+	var err error
+
 	// Validate request
-	if req.Name != "git-blame" && req.Name != "git-log" {
-		sendErrorResponse(w, "Invalid name. Must be one of: 'git-blame', 'git-log'", http.StatusBadRequest)
-		return nil, fmt.Errorf("invalid name")
+	if req.Name != "git-blame" && req.Name != "git-log" && req.Name != "pr-overlap" {
+		return badRequest("Invalid name. Must be one of: 'git-blame', 'git-log', 'pr-overlap'", "invalid name")
 	}
 
 	// Extract and validate arguments
 	var providerType repo.ProviderType
 	var token string
+	var credential string
 	var repository string
+	var baseURL string
 	var pullRequest int
 
 	if providerVal, ok := req.Arguments["provider"]; ok {
 		if str, ok := providerVal.(string); ok {
 			providerType = repo.ProviderType(str)
 			if !providerType.IsValid() {
-				sendErrorResponse(w, fmt.Sprintf("Invalid provider type. Must be one of: %s, %s", repo.GitHub, repo.GitLab), http.StatusBadRequest)
-				return nil, fmt.Errorf("invalid provider type")
+				return badRequest(fmt.Sprintf("Invalid provider type. Must be one of: %s, %s, %s, %s, %s, %s", repo.GitHub, repo.GitLab, repo.Bitbucket, repo.Gitea, repo.Azure, repo.Local), "invalid provider type")
 			}
 		} else {
-			sendErrorResponse(w, "Provider type must be a string", http.StatusBadRequest)
-			return nil, fmt.Errorf("invalid provider type format")
+			return badRequest("Provider type must be a string", "invalid provider type format")
 		}
 	} else {
-		sendErrorResponse(w, "Provider is required", http.StatusBadRequest)
-		return nil, fmt.Errorf("provider is required")
+		return badRequest("Provider is required", "provider is required")
 	}
 
 	if tokenVal, ok := req.Arguments["token"]; ok {
 		if str, ok := tokenVal.(string); ok {
 			token = str
 		} else {
-			sendErrorResponse(w, "Token must be a string", http.StatusBadRequest)
-			return nil, fmt.Errorf("invalid token format")
+			return badRequest("Token must be a string", "invalid token format")
 		}
-	} else {
-		sendErrorResponse(w, "Token is required", http.StatusBadRequest)
-		return nil, fmt.Errorf("token is required")
+	}
+
+	if credentialVal, ok := req.Arguments["credential"]; ok {
+		if str, ok := credentialVal.(string); ok {
+			credential = str
+		} else {
+			return badRequest("Credential must be a string", "invalid credential format")
+		}
+	}
+	if token != "" && credential != "" {
+		return badRequest("Pass either a token or a credential, not both", "token and credential are exclusive")
 	}
 
 	if repoVal, ok := req.Arguments["repository"]; ok {
 		if str, ok := repoVal.(string); ok {
 			repository = str
 		} else {
-			sendErrorResponse(w, "Repository must be a string", http.StatusBadRequest)
-			return nil, fmt.Errorf("invalid repository format")
+			return badRequest("Repository must be a string", "invalid repository format")
 		}
 	} else {
-		sendErrorResponse(w, "Repository is required", http.StatusBadRequest)
-		return nil, fmt.Errorf("repository is required")
+		return badRequest("Repository is required", "repository is required")
+	}
+
+	// A self-hosted instance may be named instead of the provider's default
+	if baseURLVal, ok := req.Arguments["baseUrl"]; ok {
+		str, ok := baseURLVal.(string)
+		if !ok {
+			return badRequest("Base URL must be a string", "invalid base URL format")
+		}
+		if str != "" {
+			if err := auth.ValidateBaseURL(str); err != nil {
+				return badRequest(fmt.Sprintf("Invalid base URL: %v", err), "invalid base URL")
+			}
+			// Only known instances, so requests cannot reach other hosts
+			// through the server
+			if !s.allowsBaseURL(providerType, str) {
+				return badRequest(fmt.Sprintf("Base URL %s is not allowed by this server", str), "base URL not allowed")
+			}
+		}
+		baseURL = str
+	}
+
+	// Turn away blame the provider's API cannot serve before any request is
+	// sent to it
+	if req.Name == "git-blame" {
+		effective := baseURL
+		if effective == "" {
+			effective = s.config.BaseURL(string(providerType))
+		}
+		if service, ok := blameUnsupported(providerType, effective); ok {
+			return badRequest(fmt.Sprintf("git-blame is not supported by %s", service), "blame not supported")
+		}
+	}
+
+	// Local repositories name a branch rather than a number, as numbers
+	// shift when branches are created or deleted
+	var head, base string
+	if headVal, ok := req.Arguments["head"]; ok {
+		if head, ok = headVal.(string); !ok {
+			return badRequest("Head must be a string", "invalid head format")
+		}
+	}
+	if baseVal, ok := req.Arguments["base"]; ok {
+		if base, ok = baseVal.(string); !ok {
+			return badRequest("Base must be a string", "invalid base format")
+		}
+	}
+	_, hasPullRequest := req.Arguments["pullRequest"]
+	switch {
+	case providerType != repo.Local && (head != "" || base != ""):
+		return badRequest("Head and base are only taken by the local provider", "head and base are local only")
+	case providerType == repo.Local && hasPullRequest:
+		return badRequest("The local provider takes a head branch, not a pull request number", "local takes head")
+	case providerType == repo.Local && head == "" && req.Name != "pr-overlap":
+		return badRequest("Head is required for the local provider", "head is required")
 	}
 
 	if prVal, ok := req.Arguments["pullRequest"]; ok {
 		if num, ok := prVal.(float64); ok {
 			pullRequest = int(num)
 		} else {
-			sendErrorResponse(w, "Pull request must be a number", http.StatusBadRequest)
-			return nil, fmt.Errorf("invalid pull request format")
+			return badRequest("Pull request must be a number", "invalid pull request format")
 		}
-	} else {
-		sendErrorResponse(w, "Pull request is required", http.StatusBadRequest)
-		return nil, fmt.Errorf("pull request is required")
+	} else if req.Name != "pr-overlap" && providerType != repo.Local {
+		return badRequest("Pull request is required", "pull request is required")
 	}
 
-	// Validate required arguments
-	if token == "" {
-		sendErrorResponse(w, "Token is required", http.StatusBadRequest)
-		return nil, fmt.Errorf("token is required")
+	analysis := "fragmentation"
+	if analysisVal, ok := req.Arguments["analysis"]; ok {
+		str, ok := analysisVal.(string)
+		if !ok {
+			return badRequest("Analysis must be a string", "invalid analysis format")
+		}
+		if !maat.IsValid(str) {
+			return badRequest(fmt.Sprintf("Invalid analysis. Must be one of: %s", strings.Join(maat.Analyses(), ", ")), "invalid analysis")
+		}
+		analysis = str
+	}
+
+	// The analysis window is optional; times may be relative, such as 90d
+	var window repo.Window
+	if sinceVal, ok := req.Arguments["since"]; ok {
+		str, ok := sinceVal.(string)
+		if !ok {
+			return badRequest("Since must be a string", "invalid since format")
+		}
+		if window.Since, err = repo.ParseTime(str, time.Now()); err != nil {
+			return badRequest(fmt.Sprintf("Invalid since: %v", err), "invalid since")
+		}
+	}
+	if untilVal, ok := req.Arguments["until"]; ok {
+		str, ok := untilVal.(string)
+		if !ok {
+			return badRequest("Until must be a string", "invalid until format")
+		}
+		if window.Until, err = repo.ParseUntil(str, time.Now()); err != nil {
+			return badRequest(fmt.Sprintf("Invalid until: %v", err), "invalid until")
+		}
+	}
+	if refVal, ok := req.Arguments["ref"]; ok {
+		str, ok := refVal.(string)
+		if !ok {
+			return badRequest("Ref must be a string", "invalid ref format")
+		}
+		window.Ref = str
+	}
+	if !window.Since.IsZero() && !window.Until.IsZero() && window.Until.Before(window.Since) {
+		return badRequest("Until must not be before since", "until must not be before since")
 	}
+
+	// Validate required arguments; whether a token is needed is decided once
+	// credentials are resolved
 	if repository == "" {
-		sendErrorResponse(w, "Repository is required", http.StatusBadRequest)
-		return nil, fmt.Errorf("repository is required")
+		return badRequest("Repository is required", "repository is required")
 	}
-	if pullRequest <= 0 {
-		sendErrorResponse(w, "Pull request number must be positive", http.StatusBadRequest)
-		return nil, fmt.Errorf("pull request number must be positive")
+	// Repositories may be given as paths, GitLab project IDs or web URLs, and
+	// are scoped and reported by their path
+	repoRef, err := repo.ParseRepoRef(providerType, repository)
+	if err != nil {
+		return badRequest(fmt.Sprintf("Invalid repository: %v", err), "invalid repository")
+	}
+	if providerType == repo.Local {
+		var rerr *requestError
+		if repository, rerr = s.localPath(repository); rerr != nil {
+			return rerr
+		}
+		repoRef = repo.RepoRef{Name: repository}
+	}
+	repository = repoRef.String()
+	// pr-overlap looks at every open pull request rather than one
+	if pullRequest <= 0 && req.Name != "pr-overlap" && providerType != repo.Local {
+		return badRequest("Pull request number must be positive", "pull request number must be positive")
 	}
 
-	// Create a new request with the extracted values
+	// Replace the arguments with the extracted values
 	req.Arguments = map[string]interface{}{
 		"provider":    providerType,
 		"token":       token,
+		"credential":  credential,
 		"repository":  repository,
+		"repoRef":     repoRef,
+		"baseUrl":     baseURL,
 		"pullRequest": pullRequest,
+		"head":        head,
+		"base":        base,
+		"analysis":    analysis,
+		"window":      window,
 	}
 
-	return &req, nil
+	return nil
 }
 
-func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
-	// Validate request and parse body
-	req, err := s.validate(w, r)
+// blameUnsupported names the service of a provider whose API has no blame:
+// Gitea, Azure DevOps, and Bitbucket Cloud, which a Bitbucket base URL names
+// unless it is the root of a Bitbucket Server instance
+func blameUnsupported(provider repo.ProviderType, baseURL string) (string, bool) {
+	switch provider {
+	case repo.Gitea:
+		return "Gitea", true
+	case repo.Azure:
+		return "Azure DevOps", true
+	case repo.Bitbucket:
+		if baseURL == "" || auth.NewBitbucketAuth(baseURL, "").Cloud() || strings.HasSuffix(auth.ProviderHost(string(provider), baseURL), "bitbucket.org") {
+			return "Bitbucket Cloud", true
+		}
+	}
+	return "", false
+}
+
+// blameProviders are the providers git-blame may be sent to; Bitbucket only
+// for Bitbucket Server
+func blameProviders() []string {
+	return []string{string(repo.GitHub), string(repo.GitLab), string(repo.Bitbucket), string(repo.Local)}
+}
+
+// allowsBaseURL reports whether a request may name baseURL as the instance of
+// a provider: its public service, its configured instance or one of the
+// allowed base URLs, compared by host
+func (s *Server) allowsBaseURL(provider repo.ProviderType, baseURL string) bool {
+	host := auth.ProviderHost(string(provider), baseURL)
+	allowed := append([]string{"", s.config.BaseURL(string(provider))}, s.baseURLs...)
+	for _, u := range allowed {
+		if strings.EqualFold(auth.ProviderHost(string(provider), u), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// localPath returns the absolute path of a local repository, which must be
+// under the local root once symbolic links are followed. Relative paths are
+// taken to be relative to the root.
+func (s *Server) localPath(path string) (string, *requestError) {
+	if s.localRoot == "" {
+		return "", &requestError{status: http.StatusForbidden, message: "The local provider is not enabled on this server", reason: "local provider not enabled"}
+	}
+	root, err := filepath.Abs(s.localRoot)
 	if err != nil {
-		return
+		return "", &requestError{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to resolve the local root: %v", err)}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
 	}
+	path = filepath.Clean(path)
 
-	// Extract arguments
+	// Links are followed where they exist, so none leads out of the root
+	inside := isWithin(root, path)
+	if resolvedRoot, err := filepath.EvalSymlinks(root); err == nil {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			inside = inside && isWithin(resolvedRoot, resolved)
+		}
+	}
+	if !inside {
+		return "", &requestError{status: http.StatusForbidden, message: fmt.Sprintf("Repository %s is outside the local root", path), reason: "repository outside local root"}
+	}
+	return path, nil
+}
+
+// isWithin reports whether path is dir or below it
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolveToken sets the token of a request with parsed arguments: the token
+// of the credential it names, its own token if the policy allows inline
+// tokens, or else the token fallback returns for its provider. fallback may
+// be nil. Requests without a base URL are given the configured one, which a
+// repository given as a URL must be on. Credentials without a host and
+// fallback tokens are only sent to the configured instance, so a request
+// cannot send them elsewhere.
+func (s *Server) resolveToken(req *AnalysisRequest, fallback func(repo.ProviderType) string) *requestError {
 	providerType := req.Arguments["provider"].(repo.ProviderType)
 	token := req.Arguments["token"].(string)
-	repository := req.Arguments["repository"].(string)
-	pullRequest := req.Arguments["pullRequest"].(int)
+	name := req.Arguments["credential"].(string)
 
-	// Create repository client based on provider
-	var repoClient repo.RepositoryClient
-	switch providerType {
-	case repo.GitHub:
-		authProvider := auth.NewGitHubAuth(token)
-		if err := authProvider.Authenticate(); err != nil {
-			sendErrorResponse(w, fmt.Sprintf("GitHub authentication failed: %v", err), http.StatusUnauthorized)
-			return
+	configured := s.config.BaseURL(string(providerType))
+	baseURL := req.Arguments["baseUrl"].(string)
+	if baseURL == "" {
+		baseURL = configured
+		req.Arguments["baseUrl"] = baseURL
+	}
+	host := auth.ProviderHost(string(providerType), baseURL)
+	configuredHost := auth.ProviderHost(string(providerType), configured)
+	if repoRef := req.Arguments["repoRef"].(repo.RepoRef); repoRef.Host != "" && repoRef.Host != host {
+		return badRequest(fmt.Sprintf("Repository URL is on %s, not %s; set baseUrl to use another instance", repoRef.Host, host), "repository host mismatch")
+	}
+
+	switch {
+	case name != "":
+		cred, ok := s.credentials.Get(name)
+		if !ok {
+			return badRequest(fmt.Sprintf("Unknown credential: %s", name), "unknown credential")
 		}
-		repoClient = repo.NewGitHubClient(authProvider.GetClient().(*github.Client))
-	case repo.GitLab:
-		authProvider := auth.NewGitLabAuth(token)
-		if err := authProvider.Authenticate(); err != nil {
-			sendErrorResponse(w, fmt.Sprintf("GitLab authentication failed: %v", err), http.StatusUnauthorized)
-			return
+		if repo.ProviderType(cred.Provider) != providerType {
+			return badRequest(fmt.Sprintf("Credential %s is for %s, not %s", name, cred.Provider, providerType), "credential provider mismatch")
 		}
-		repoClient = repo.NewGitLabClient(authProvider.GetClient().(*gitlab.Client))
+		credHost := cred.Host
+		if credHost == "" {
+			credHost = configuredHost
+		}
+		if !strings.EqualFold(credHost, host) {
+			return badRequest(fmt.Sprintf("Credential %s is for %s, not %s", name, credHost, host), "credential host mismatch")
+		}
+		token = cred.Token
+	case token != "":
+		if !s.inlineTokens {
+			return &requestError{
+				status:  http.StatusForbidden,
+				message: "Inline tokens are not allowed by this server; reference a credential by name instead",
+				reason:  "inline tokens are not allowed",
+			}
+		}
+	case fallback != nil && host == configuredHost:
+		token = fallback(providerType)
 	}
 
-	// Get pull request information
-	prs, err := repoClient.ListPullRequests(repository)
-	if err != nil {
-		sendErrorResponse(w, fmt.Sprintf("Failed to get pull requests: %v", err), http.StatusInternalServerError)
-		return
+	if token == "" && providerType != repo.Local {
+		return badRequest("Token is required", "token is required")
 	}
+	req.Arguments["token"] = token
+	return nil
+}
 
-	// Find the specific pull request
-	var selectedPR *repo.PullRequest
-	for _, pr := range prs {
-		if pr.Number == pullRequest {
-			selectedPR = &pr
-			break
-		}
+// analysisResult is the outcome of a message, before it is encoded in one of
+// the response shapes. Blame is set for git-blame, Entries and Table for
+// git-log, and Examined and Overlaps for pr-overlap, which has no pull request.
+type analysisResult struct {
+	repoClient  repo.RepositoryClient
+	pullRequest *repo.PullRequest
+	blame       *repo.BlameResult
+	entries     []maat.Entry
+	table       *maat.Table
+	examined    int
+	overlaps    []overlap.Pair
+}
+
+// handleMessages serves the original response shape, where every value is a
+// string. It is kept at /messages and /v1/messages for existing clients.
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	// Validate request and parse body
+	req, err := s.validate(w, r)
+	if err != nil {
+		return
 	}
 
-	if selectedPR == nil {
-		sendErrorResponse(w, fmt.Sprintf("Pull request #%d not found", pullRequest), http.StatusNotFound)
+	result, rerr := s.analyze(r.Context(), req)
+	if rerr != nil {
+		sendErrorResponse(w, rerr.message, rerr.status)
 		return
 	}
 
-	// Handle different message types
+	repository := req.Arguments["repository"].(string)
+	output := req.Arguments["output"].(render.Format)
+
 	switch req.Name {
 	case "git-blame":
-		// Get blame information
-		blameInfo, err := repoClient.GetBlameInfo(repository, pullRequest, selectedPR.ChangedFiles)
-		if err != nil {
-			sendErrorResponse(w, fmt.Sprintf("Failed to get blame information: %v", err), http.StatusInternalServerError)
+		if output != "" {
+			writeDocument(w, output, render.BlameDocument(repository, result.pullRequest, result.blame))
 			return
 		}
 
 		// Convert blame info to a simpler map for JSON response
 		blameData := make(map[string]string)
-		for _, info := range blameInfo {
+		for _, info := range result.blame.Authors {
 			blameData[info.User] = fmt.Sprintf("%d", info.Lines)
 		}
 
@@ -274,57 +765,24 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(AnalysisResponse{
 			Status:  "success",
-			Message: "Blame analysis completed",
+			Message: completionMessage(skippedMessage("Blame analysis completed", result.blame), result.repoClient),
 			Data:    blameData,
 		})
 
 	case "git-log":
-		// Create a temporary directory for the log files
-		tempDir, err := os.MkdirTemp("", "git-log-*")
-		if err != nil {
-			sendErrorResponse(w, fmt.Sprintf("Failed to create temporary directory: %v", err), http.StatusInternalServerError)
-			return
-		}
-		defer os.RemoveAll(tempDir)
-
-		// Clone the repository
-		cloneCmd := exec.Command("git", "clone", selectedPR.URL, tempDir)
-		if err := cloneCmd.Run(); err != nil {
-			sendErrorResponse(w, fmt.Sprintf("Failed to clone repository: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		// Change to the repository directory
-		if err := os.Chdir(tempDir); err != nil {
-			sendErrorResponse(w, fmt.Sprintf("Failed to change directory: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		// Run git log command
-		logFile := filepath.Join(tempDir, "logfile.log")
-		gitLogCmd := exec.Command("git", "log", "--all", "--numstat", "--date=short", "--pretty=format:--%h--%ad--%aN", "--no-renames", "--after=2024-01-01")
-		output, err := gitLogCmd.Output()
-		if err != nil {
-			sendErrorResponse(w, fmt.Sprintf("Failed to run git log: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		// Write git log output to file
-		if err := os.WriteFile(logFile, output, 0644); err != nil {
-			sendErrorResponse(w, fmt.Sprintf("Failed to write log file: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		// Run code-maat
-		codeMaatCmd := exec.Command("java", "-jar", "code-maat-1.0.4-standalone.jar", "-l", logFile, "-c", "git2", "-a", "fragmentation")
-		codeMaatOutput, err := codeMaatCmd.Output()
-		if err != nil {
-			sendErrorResponse(w, fmt.Sprintf("Failed to run code-maat: %v", err), http.StatusInternalServerError)
+		if output != "" {
+			summary, err := maat.Run("summary", result.entries, maat.Options{})
+			if err != nil {
+				sendErrorResponse(w, fmt.Sprintf("Failed to run analysis: %v", err), http.StatusInternalServerError)
+				return
+			}
+			analysis := req.Arguments["analysis"].(string)
+			writeDocument(w, output, render.AnalysisDocument(repository, analysis, summary, result.table))
 			return
 		}
 
 		// Parse CSV output
-		lines := strings.Split(string(codeMaatOutput), "\n")
+		lines := strings.Split(result.table.CSV(), "\n")
 		csvData := make(map[string]string)
 		for i, line := range lines {
 			if i == 0 {
@@ -339,10 +797,192 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(AnalysisResponse{
 			Status:  "success",
-			Message: "Git log analysis completed",
+			Message: completionMessage("Git log analysis completed", result.repoClient),
 			Data:    csvData,
 		})
+
+	case "pr-overlap":
+		if output != "" {
+			writeDocument(w, output, render.OverlapDocument(repository, result.examined, result.overlaps))
+			return
+		}
+
+		// Describe each overlapping pair, keyed by its pull request numbers
+		overlapData := make(map[string]string)
+		for _, pair := range result.overlaps {
+			overlapData[fmt.Sprintf("%d-%d", pair.A.Number, pair.B.Number)] =
+				fmt.Sprintf("%d shared files, %d overlapping lines", len(pair.Files), pair.Lines)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(AnalysisResponse{
+			Status:  "success",
+			Message: completionMessage("Overlap analysis completed", result.repoClient),
+			Data:    overlapData,
+		})
+	}
+}
+
+// analyze runs the analysis a request with parsed arguments asks for
+func (s *Server) analyze(ctx context.Context, req *AnalysisRequest) (*analysisResult, *requestError) {
+	// Extract arguments
+	providerType := req.Arguments["provider"].(repo.ProviderType)
+	token := req.Arguments["token"].(string)
+	repository := req.Arguments["repository"].(string)
+	repoRef := req.Arguments["repoRef"].(repo.RepoRef)
+	baseURL := req.Arguments["baseUrl"].(string)
+	pullRequest := req.Arguments["pullRequest"].(int)
+	window := req.Arguments["window"].(repo.Window)
+
+	// Create repository client based on provider
+	repo.ReportProgress(ctx, repo.Progress{Phase: repo.PhaseAuth})
+	var repoClient repo.RepositoryClient
+	switch providerType {
+	case repo.GitHub:
+		authProvider := auth.NewGitHubAuth(baseURL, token)
+		if err := authProvider.Authenticate(ctx); err != nil {
+			return nil, &requestError{status: http.StatusUnauthorized, message: fmt.Sprintf("GitHub authentication failed: %v", err)}
+		}
+		repoClient = repo.NewGitHubClient(authProvider.GetClient().(*github.Client))
+	case repo.GitLab:
+		authProvider := auth.NewGitLabAuth(baseURL, token)
+		if err := authProvider.Authenticate(ctx); err != nil {
+			return nil, &requestError{status: http.StatusUnauthorized, message: fmt.Sprintf("GitLab authentication failed: %v", err)}
+		}
+		repoClient = repo.NewGitLabClient(authProvider.GetClient().(*gitlab.Client))
+	case repo.Bitbucket:
+		authProvider := auth.NewBitbucketAuth(baseURL, token)
+		if err := authProvider.Authenticate(ctx); err != nil {
+			return nil, &requestError{status: http.StatusUnauthorized, message: fmt.Sprintf("Bitbucket authentication failed: %v", err)}
+		}
+		repoClient = repo.NewBitbucketClient(authProvider.GetClient().(*http.Client), authProvider.BaseURL(), authProvider.Cloud())
+	case repo.Gitea:
+		authProvider := auth.NewGiteaAuth(baseURL, token)
+		if err := authProvider.Authenticate(ctx); err != nil {
+			return nil, &requestError{status: http.StatusUnauthorized, message: fmt.Sprintf("Gitea authentication failed: %v", err)}
+		}
+		repoClient = repo.NewGiteaClient(authProvider.GetClient().(*http.Client), authProvider.BaseURL())
+	case repo.Azure:
+		authProvider := auth.NewAzureAuth(baseURL, repoRef.Owner(), token)
+		if err := authProvider.Authenticate(ctx); err != nil {
+			return nil, &requestError{status: http.StatusUnauthorized, message: fmt.Sprintf("Azure DevOps authentication failed: %v", err)}
+		}
+		repoClient = repo.NewAzureClient(authProvider.GetClient().(*http.Client), authProvider.BaseURL(), authProvider.Organization())
+	case repo.Local:
+		// The repository argument is a path to a working copy on the server
+		repoClient = repo.NewLocalClient(repository)
+	}
+
+	// pr-overlap compares every open pull request
+	if req.Name == "pr-overlap" {
+		repo.ReportProgress(ctx, repo.Progress{Phase: repo.PhaseListPullRequests})
+		prs, err := repoClient.ListPullRequests(ctx, repoRef)
+		if err != nil {
+			return nil, &requestError{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to get pull requests: %v", err)}
+		}
+		repo.ReportProgress(ctx, repo.Progress{Phase: repo.PhaseAnalysis})
+		return &analysisResult{repoClient: repoClient, examined: len(prs), overlaps: overlap.Find(prs)}, nil
+	}
+
+	// Fetch just the one pull request, which may also be closed or merged,
+	// or for a local repository compare its head branch with the base.
+	// git-log only checks that it exists, as it analyses the whole history.
+	repo.ReportProgress(ctx, repo.Progress{Phase: repo.PhaseListPullRequests})
+	var selectedPR *repo.PullRequest
+	var err error
+	if localClient, ok := repoClient.(*repo.LocalClient); ok {
+		selectedPR, err = localClient.CompareRefs(ctx, repoRef, req.Arguments["base"].(string), req.Arguments["head"].(string))
+	} else {
+		selectedPR, err = repoClient.GetPullRequest(ctx, repoRef, pullRequest)
+	}
+	if errors.Is(err, repo.ErrNotFound) {
+		message := fmt.Sprintf("Pull request #%d not found", pullRequest)
+		if providerType == repo.Local {
+			message = fmt.Sprintf("Cannot compare %s with its base: %v", req.Arguments["head"], err)
+		}
+		return nil, &requestError{status: http.StatusNotFound, message: message}
+	}
+	if err != nil {
+		return nil, &requestError{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to get pull request: %v", err)}
+	}
+
+	result := &analysisResult{repoClient: repoClient, pullRequest: selectedPR}
+
+	// Handle different message types
+	switch req.Name {
+	case "git-blame":
+		// Get blame information
+		if localClient, ok := repoClient.(*repo.LocalClient); ok {
+			result.blame, err = localClient.BlamePullRequest(ctx, repoRef, selectedPR, selectedPR.ChangedFiles, window)
+		} else {
+			result.blame, err = repoClient.GetBlameInfo(ctx, repoRef, pullRequest, selectedPR.ChangedFiles, window)
+		}
+		if err != nil {
+			return nil, &requestError{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to get blame information: %v", err)}
+		}
+
+	case "git-log":
+		cloneURL, err := repoClient.CloneURL(ctx, repoRef)
+		if err != nil {
+			return nil, &requestError{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to get clone URL: %v", err)}
+		}
+
+		// Bring the mirror up to date, so every branch is a local ref
+		repo.ReportProgress(ctx, repo.Progress{Phase: repo.PhaseClone})
+		dir, release, err := s.workspace.Mirror(ctx, cloneURL, cloneCredentials(providerType, token))
+		if err != nil {
+			return nil, &requestError{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to clone repository: %v", err)}
+		}
+		defer release()
+
+		// Run git log command
+		logArgs := append([]string{"log", "--numstat", "--date=short", "--pretty=format:--%h--%ad--%aN", "--no-renames"}, window.LogArgs()...)
+		gitLogCmd := exec.CommandContext(ctx, "git", logArgs...)
+		gitLogCmd.Dir = dir
+		logOutput, err := gitLogCmd.Output()
+		if err != nil {
+			return nil, &requestError{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to run git log: %v", err)}
+		}
+
+		// Run the code-maat analysis
+		repo.ReportProgress(ctx, repo.Progress{Phase: repo.PhaseAnalysis})
+		result.entries, err = maat.Parse(bytes.NewReader(logOutput))
+		if err != nil {
+			return nil, &requestError{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to parse git log: %v", err)}
+		}
+		result.table, err = maat.Run(req.Arguments["analysis"].(string), result.entries, maat.Options{})
+		if err != nil {
+			return nil, &requestError{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to run analysis: %v", err)}
+		}
+	}
+
+	return result, nil
+}
+
+// cloneCredentials returns the credentials git fetches with. Each provider
+// accepts the API token as a password, under its own username; Bitbucket app
+// passwords carry their username.
+func cloneCredentials(provider repo.ProviderType, token string) workspace.Credentials {
+	switch provider {
+	case repo.GitHub:
+		return workspace.Credentials{Username: "x-access-token", Token: token}
+	case repo.GitLab:
+		return workspace.Credentials{Username: "oauth2", Token: token}
+	case repo.Bitbucket:
+		username, secret := auth.SplitBitbucketToken(token)
+		if username == "" {
+			username = "x-token-auth"
+		}
+		return workspace.Credentials{Username: username, Token: secret}
+	case repo.Gitea:
+		// Gitea takes a token as the password of any user
+		return workspace.Credentials{Username: "token", Token: token}
+	case repo.Azure:
+		// Azure DevOps ignores the username a personal access token is sent with
+		return workspace.Credentials{Username: "pat", Token: token}
 	}
+	return workspace.Credentials{}
 }
 
 func (s *Server) handlePrompts(w http.ResponseWriter, r *http.Request) {
@@ -353,64 +993,221 @@ func (s *Server) handlePrompts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	prompts := []Prompt{
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(prompts())
+}
+
+// prompts describes the messages the server accepts
+func prompts() []Prompt {
+	return []Prompt{
 		{
 			Name:        "git-blame",
-			Description: "Analyzes the blame information for files in a pull request, showing which authors modified which lines.",
+			Description: "Analyzes the blame information for files in a pull request, showing which authors own the surviving lines at the pull request's base commit.",
 			Arguments: []Argument{
 				{
 					Name:        "provider",
-					Description: "The Git provider (github or gitlab)",
+					Description: "The Git provider (github, gitlab, bitbucket for Bitbucket Server, or local; Bitbucket Cloud, Gitea and Azure DevOps offer no blame)",
 					Required:    true,
 				},
 				{
 					Name:        "token",
-					Description: "Personal access token for authentication",
+					Description: "Personal access token for authentication, unless a credential is named (not used by the local provider)",
 					Required:    true,
 				},
+				{
+					Name:        "credential",
+					Description: "Name of a credential held by the server, to authenticate with instead of a token",
+					Required:    false,
+				},
 				{
 					Name:        "repository",
-					Description: "Full repository name in the format owner/repo",
+					Description: "Repository path in the format owner/repo (group/subgroup/project or a project ID for GitLab, organization/project/repo for Azure DevOps), a repository URL, or a path on the server for the local provider",
 					Required:    true,
 				},
+				{
+					Name:        "baseUrl",
+					Description: "Root URL of a self-hosted instance, such as GitHub Enterprise Server or a self-managed GitLab (defaults to the server's configuration, or the public service)",
+					Required:    false,
+				},
 				{
 					Name:        "pullRequest",
-					Description: "Pull request number",
+					Description: "Pull request number (not used by the local provider, which takes head)",
 					Required:    true,
 				},
+				{
+					Name:        "head",
+					Description: "For the local provider, the branch to compare with base, as a pull request",
+					Required:    false,
+				},
+				{
+					Name:        "base",
+					Description: "For the local provider, the branch head is compared with (defaults to the default branch)",
+					Required:    false,
+				},
+				{
+					Name:        "since",
+					Description: "Only analyse commits authored after this date (2024-01-01) or age (90d)",
+					Required:    false,
+				},
+				{
+					Name:        "until",
+					Description: "Only analyse commits authored before this date or age",
+					Required:    false,
+				},
+				{
+					Name:        "ref",
+					Description: "Blame at this branch, tag or commit instead of the pull request's base commit",
+					Required:    false,
+				},
 			},
 		},
 		{
 			Name:        "git-log",
-			Description: "Returns a success response for the specified repository and pull request.",
+			Description: "Runs a code-maat analysis over the repository's git log.",
 			Arguments: []Argument{
 				{
 					Name:        "provider",
-					Description: "The Git provider (github or gitlab)",
+					Description: "The Git provider (github, gitlab, bitbucket, gitea, azure or local)",
 					Required:    true,
 				},
 				{
 					Name:        "token",
-					Description: "Personal access token for authentication",
+					Description: "Personal access token for authentication, unless a credential is named (not used by the local provider)",
 					Required:    true,
 				},
+				{
+					Name:        "credential",
+					Description: "Name of a credential held by the server, to authenticate with instead of a token",
+					Required:    false,
+				},
 				{
 					Name:        "repository",
-					Description: "Full repository name in the format owner/repo",
+					Description: "Repository path in the format owner/repo (group/subgroup/project or a project ID for GitLab, organization/project/repo for Azure DevOps), a repository URL, or a path on the server for the local provider",
 					Required:    true,
 				},
+				{
+					Name:        "baseUrl",
+					Description: "Root URL of a self-hosted instance, such as GitHub Enterprise Server or a self-managed GitLab (defaults to the server's configuration, or the public service)",
+					Required:    false,
+				},
 				{
 					Name:        "pullRequest",
-					Description: "Pull request number",
+					Description: "Pull request number (not used by the local provider, which takes head)",
 					Required:    true,
 				},
+				{
+					Name:        "head",
+					Description: "For the local provider, the branch to compare with base, as a pull request",
+					Required:    false,
+				},
+				{
+					Name:        "base",
+					Description: "For the local provider, the branch head is compared with (defaults to the default branch)",
+					Required:    false,
+				},
+				{
+					Name:        "analysis",
+					Description: "code-maat analysis to run (defaults to fragmentation)",
+					Required:    false,
+				},
+				{
+					Name:        "since",
+					Description: "Only analyse commits authored after this date (2024-01-01) or age (90d)",
+					Required:    false,
+				},
+				{
+					Name:        "until",
+					Description: "Only analyse commits authored before this date or age",
+					Required:    false,
+				},
+				{
+					Name:        "ref",
+					Description: "Branch, tag or commit whose history is analysed (defaults to every branch)",
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "pr-overlap",
+			Description: "Cross-references the open pull requests of a repository and ranks the pairs that change the same files, and the same lines where diffs are available, so merges can be sequenced before they conflict.",
+			Arguments: []Argument{
+				{
+					Name:        "provider",
+					Description: "The Git provider (github, gitlab, bitbucket, gitea, azure or local)",
+					Required:    true,
+				},
+				{
+					Name:        "token",
+					Description: "Personal access token for authentication, unless a credential is named (not used by the local provider)",
+					Required:    true,
+				},
+				{
+					Name:        "credential",
+					Description: "Name of a credential held by the server, to authenticate with instead of a token",
+					Required:    false,
+				},
+				{
+					Name:        "repository",
+					Description: "Repository path in the format owner/repo (group/subgroup/project or a project ID for GitLab, organization/project/repo for Azure DevOps), a repository URL, or a path on the server for the local provider",
+					Required:    true,
+				},
+				{
+					Name:        "baseUrl",
+					Description: "Root URL of a self-hosted instance, such as GitHub Enterprise Server or a self-managed GitLab (defaults to the server's configuration, or the public service)",
+					Required:    false,
+				},
 			},
 		},
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
+// writeDocument responds with doc rendered in the given format
+func writeDocument(w http.ResponseWriter, format render.Format, doc *render.Document) {
+	var buf bytes.Buffer
+	if err := render.Write(&buf, format, doc); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to render response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(prompts)
+	w.Write(buf.Bytes())
+}
+
+// skippedMessage notes any files that were not blamed, as they are missing at
+// the blamed ref
+func skippedMessage(message string, blame *repo.BlameResult) string {
+	if len(blame.Skipped) > 0 {
+		message += fmt.Sprintf(" (skipped files missing at %s: %s)", blame.Ref, strings.Join(blame.Skipped, ", "))
+	}
+	return message
+}
+
+// completionMessage notes any lists that stopped at the client's item limit
+func completionMessage(message string, repoClient repo.RepositoryClient) string {
+	if truncated := repoClient.Truncated(); len(truncated) > 0 {
+		message += fmt.Sprintf(" (results truncated: %s)", strings.Join(truncated, "; "))
+	}
+	return message
+}
+
+// requestError is a request that failed. The message is sent to the client
+// along with the HTTP status, and Error gives a short reason for logs.
+type requestError struct {
+	status  int
+	message string
+	reason  string
+}
+
+func (e *requestError) Error() string {
+	if e.reason != "" {
+		return e.reason
+	}
+	return e.message
+}
+
+func badRequest(message, reason string) *requestError {
+	return &requestError{status: http.StatusBadRequest, message: message, reason: reason}
 }
 
 func sendErrorResponse(w http.ResponseWriter, errorMsg string, statusCode int) {
@@ -2,14 +2,28 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/andrewweb/hackday/pkg/access"
+	"github.com/andrewweb/hackday/pkg/auth"
+	"github.com/andrewweb/hackday/pkg/maat"
+	"github.com/andrewweb/hackday/pkg/render"
+	"github.com/andrewweb/hackday/pkg/repo"
+	"github.com/andrewweb/hackday/pkg/workspace"
 )
 
 func TestServer_Validate(t *testing.T) {
-	server := NewServer(8080)
+	server := NewServer(8080, WithLocalRoot("/srv/mirrors"))
 
 	tests := []struct {
 		name           string
@@ -81,6 +95,58 @@ func TestServer_Validate(t *testing.T) {
 			expectedStatus: http.StatusUnsupportedMediaType,
 			expectedError:  "invalid content type",
 		},
+		{
+			name:        "invalid analysis",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-log",
+				Arguments: map[string]interface{}{
+					"provider":    "github",
+					"token":       "token",
+					"repository":  "owner/repo",
+					"pullRequest": 1,
+					"analysis":    "hotspots",
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid analysis",
+		},
+		{
+			name:        "relative time window",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-log",
+				Arguments: map[string]interface{}{
+					"provider":    "github",
+					"token":       "token",
+					"repository":  "owner/repo",
+					"pullRequest": 1,
+					"since":       "90d",
+					"until":       "2099-01-01",
+					"ref":         "main",
+				},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:        "invalid since",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-log",
+				Arguments: map[string]interface{}{
+					"provider":    "github",
+					"token":       "token",
+					"repository":  "owner/repo",
+					"pullRequest": 1,
+					"since":       "last tuesday",
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid since",
+		},
 		{
 			name:        "invalid provider type",
 			method:      http.MethodPost,
@@ -112,6 +178,66 @@ func TestServer_Validate(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedError:  "token is required",
 		},
+		{
+			name:        "local provider without token",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-blame",
+				Arguments: map[string]interface{}{
+					"provider":   "local",
+					"repository": "/srv/mirrors/repo",
+					"head":       "feature",
+				},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:        "local provider with a pull request number",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-blame",
+				Arguments: map[string]interface{}{
+					"provider":    "local",
+					"repository":  "/srv/mirrors/repo",
+					"pullRequest": 1,
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "local takes head",
+		},
+		{
+			name:        "local provider without head",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-blame",
+				Arguments: map[string]interface{}{
+					"provider":   "local",
+					"repository": "/srv/mirrors/repo",
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "head is required",
+		},
+		{
+			name:        "head on another provider",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-blame",
+				Arguments: map[string]interface{}{
+					"provider":    "github",
+					"token":       "token",
+					"repository":  "owner/repo",
+					"pullRequest": 1,
+					"head":        "feature",
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "head and base are local only",
+		},
 		{
 			name:        "missing repository",
 			method:      http.MethodPost,
@@ -127,6 +253,53 @@ func TestServer_Validate(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedError:  "repository is required",
 		},
+		{
+			name:        "invalid repository",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-blame",
+				Arguments: map[string]interface{}{
+					"provider":    "github",
+					"token":       "token",
+					"repository":  "owner/repo/extra",
+					"pullRequest": 1,
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid repository",
+		},
+		{
+			name:        "GitLab subgroup URL",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-blame",
+				Arguments: map[string]interface{}{
+					"provider":    "gitlab",
+					"token":       "token",
+					"repository":  "https://gitlab.com/group/sub/project/-/merge_requests/4",
+					"pullRequest": 4,
+				},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:        "repository URL on another host",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-blame",
+				Arguments: map[string]interface{}{
+					"provider":    "github",
+					"token":       "token",
+					"repository":  "https://ghe.example.com/owner/repo",
+					"pullRequest": 1,
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "repository host mismatch",
+		},
 		{
 			name:        "invalid pull request number",
 			method:      http.MethodPost,
@@ -143,6 +316,53 @@ func TestServer_Validate(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedError:  "pull request number must be positive",
 		},
+		{
+			name:        "git-blame on Gitea",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-blame",
+				Arguments: map[string]interface{}{
+					"provider":    "gitea",
+					"token":       "token",
+					"repository":  "owner/repo",
+					"pullRequest": 1,
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "blame not supported",
+		},
+		{
+			name:        "git-blame on Bitbucket Cloud",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-blame",
+				Arguments: map[string]interface{}{
+					"provider":    "bitbucket",
+					"token":       "user:app-password",
+					"repository":  "workspace/repo",
+					"pullRequest": 1,
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "blame not supported",
+		},
+		{
+			name:        "git-log on Azure DevOps",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			requestBody: AnalysisRequest{
+				Name: "git-log",
+				Arguments: map[string]interface{}{
+					"provider":    "azure",
+					"token":       "token",
+					"repository":  "org/project/repo",
+					"pullRequest": 1,
+				},
+			},
+			expectedStatus: http.StatusOK,
+		},
 	}
 
 	for _, tt := range tests {
@@ -182,6 +402,220 @@ func TestServer_Validate(t *testing.T) {
 	}
 }
 
+func TestServer_LocalRoot(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "app"), 0755); err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		root           string
+		repository     string
+		expected       string
+		expectedStatus int
+		expectedError  string
+	}{
+		{"absolute path under the root", root, filepath.Join(root, "app"), filepath.Join(root, "app"), http.StatusOK, ""},
+		{"relative path", root, "app", filepath.Join(root, "app"), http.StatusOK, ""},
+		{"no local root", "", filepath.Join(root, "app"), "", http.StatusForbidden, "local provider not enabled"},
+		{"path outside the root", root, outside, "", http.StatusForbidden, "repository outside local root"},
+		{"parent directory", root, "app/../..", "", http.StatusForbidden, "repository outside local root"},
+		{"link out of the root", root, "escape", "", http.StatusForbidden, "repository outside local root"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(8080, WithLocalRoot(tt.root))
+			body, err := json.Marshal(AnalysisRequest{Name: "git-blame", Arguments: map[string]interface{}{
+				"provider":   "local",
+				"repository": tt.repository,
+				"head":       "feature",
+			}})
+			if err != nil {
+				t.Fatalf("Failed to marshal request body: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/messages", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			validated, err := server.validate(w, req)
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status code %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedError != "" {
+				if err == nil || err.Error() != tt.expectedError {
+					t.Errorf("Expected error '%s', got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got '%s'", err.Error())
+			}
+			if got := validated.Arguments["repository"]; got != tt.expected {
+				t.Errorf("Expected repository %s, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestServer_Credentials(t *testing.T) {
+	store := auth.NewCredentialStore()
+	store.Add(auth.Credential{Name: "ci", Provider: "github", Token: "stored"})
+	store.Add(auth.Credential{Name: "enterprise", Provider: "github", Host: "github.example.com", Token: "stored"})
+
+	tests := []struct {
+		name           string
+		inlineTokens   bool
+		arguments      map[string]interface{}
+		expectedStatus int
+		expectedError  string
+	}{
+		{"stored credential", false, map[string]interface{}{"credential": "ci"}, http.StatusOK, ""},
+		{"inline token allowed", true, map[string]interface{}{"token": "inline"}, http.StatusOK, ""},
+		{"inline token denied", false, map[string]interface{}{"token": "inline"}, http.StatusForbidden, "inline tokens are not allowed"},
+		{"unknown credential", true, map[string]interface{}{"credential": "other"}, http.StatusBadRequest, "unknown credential"},
+		{"other host", true, map[string]interface{}{"credential": "enterprise"}, http.StatusBadRequest, "credential host mismatch"},
+		{"credential host", false, map[string]interface{}{"credential": "enterprise", "baseUrl": "https://github.example.com"}, http.StatusOK, ""},
+		{"credential sent elsewhere", true, map[string]interface{}{"credential": "ci", "baseUrl": "https://gitlab.example.com"}, http.StatusBadRequest, "credential host mismatch"},
+		{"inline token at base URL", true, map[string]interface{}{"token": "inline", "baseUrl": "https://gitlab.example.com/"}, http.StatusOK, ""},
+		{"public service", true, map[string]interface{}{"token": "inline", "baseUrl": "https://github.com"}, http.StatusOK, ""},
+		{"base URL not allowed", true, map[string]interface{}{"token": "inline", "baseUrl": "http://169.254.169.254"}, http.StatusBadRequest, "base URL not allowed"},
+		{"relative base URL", true, map[string]interface{}{"token": "inline", "baseUrl": "github.example.com"}, http.StatusBadRequest, "invalid base URL"},
+		{"both", true, map[string]interface{}{"credential": "ci", "token": "inline"}, http.StatusBadRequest, "token and credential are exclusive"},
+		{"neither", false, map[string]interface{}{}, http.StatusBadRequest, "token is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(8080, WithCredentials(store), WithInlineTokens(tt.inlineTokens),
+				WithAllowedBaseURLs([]string{"https://github.example.com", "https://gitlab.example.com"}))
+
+			arguments := map[string]interface{}{"provider": "github", "repository": "owner/repo", "pullRequest": 1}
+			for k, v := range tt.arguments {
+				arguments[k] = v
+			}
+			body, _ := json.Marshal(AnalysisRequest{Name: "git-blame", Arguments: arguments})
+			req := httptest.NewRequest(http.MethodPost, "/messages", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			parsed, err := server.validate(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status code %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedError != "" {
+				if err == nil || err.Error() != tt.expectedError {
+					t.Errorf("Expected error '%s', got '%v'", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got '%s'", err.Error())
+			}
+			expected := "inline"
+			if _, ok := tt.arguments["credential"]; ok {
+				expected = "stored"
+			}
+			if token := parsed.Arguments["token"]; token != expected {
+				t.Errorf("Expected token %s, got %v", expected, token)
+			}
+		})
+	}
+
+	// Without authentication, anyone could use the credentials
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewServer(0, WithCredentials(store)).Start(ctx); err == nil || !strings.Contains(err.Error(), "does not authenticate callers") {
+		t.Errorf("Expected Start to refuse credentials without authentication, got %v", err)
+	}
+}
+
+func TestServer_Authentication(t *testing.T) {
+	keys, err := access.NewAPIKeys([]access.APIKey{
+		{Name: "blame-only", Key: "blame-key", Scope: access.Scope{Analyses: []string{"git-blame"}, Repositories: []string{"myorg/*"}}},
+		{Name: "ci", Key: "ci-key", Scope: access.Scope{Credentials: []string{"ci-*"}}},
+	})
+	if err != nil {
+		t.Fatalf("NewAPIKeys failed: %v", err)
+	}
+	store := auth.NewCredentialStore()
+	store.Add(auth.Credential{Name: "ci-gitlab", Provider: "gitlab", Token: "stored"})
+	store.Add(auth.Credential{Name: "release", Provider: "github", Token: "stored"})
+	server := NewServer(8080, WithAuthenticator(keys), WithCredentials(store))
+	handler := server.authenticate(server.mux)
+
+	tests := []struct {
+		name           string
+		key            string
+		message        string
+		repository     string
+		credential     string
+		expectedStatus int
+	}{
+		{"no key", "", "git-blame", "myorg/api", "", http.StatusUnauthorized},
+		{"wrong key", "other", "git-blame", "myorg/api", "", http.StatusUnauthorized},
+		{"analysis out of scope", "blame-key", "git-log", "myorg/api", "", http.StatusForbidden},
+		{"repository out of scope", "blame-key", "git-blame", "other/api", "", http.StatusForbidden},
+		{"another key's credential", "ci-key", "git-blame", "myorg/api", "release", http.StatusForbidden},
+		// In scope, the request is only turned away for want of a token, or
+		// because the credential is for GitLab
+		{"in scope", "blame-key", "git-blame", "myorg/api", "", http.StatusBadRequest},
+		{"credential in scope", "ci-key", "git-blame", "myorg/api", "ci-gitlab", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arguments := map[string]interface{}{"provider": "github", "repository": tt.repository, "pullRequest": 1}
+			if tt.credential != "" {
+				arguments["credential"] = tt.credential
+			}
+			body, _ := json.Marshal(AnalysisRequest{Name: tt.message, Arguments: arguments})
+			req := httptest.NewRequest(http.MethodPost, "/v2/messages", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.key != "" {
+				req.Header.Set(access.APIKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status code %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestServer_AuthenticationBodyLimit(t *testing.T) {
+	keys, err := access.NewAPIKeys([]access.APIKey{{Name: "ci", Key: "ci-key"}})
+	if err != nil {
+		t.Fatalf("NewAPIKeys failed: %v", err)
+	}
+	server := NewServer(8080, WithAuthenticator(keys))
+	handler := server.authenticate(server.mux)
+
+	// The body is turned away before anything says who sent it
+	body := bytes.Repeat([]byte("a"), maxUnauthenticatedBody+1)
+	req := httptest.NewRequest(http.MethodPost, "/v2/messages", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	}
+}
+
 func TestServer_HandlePrompts(t *testing.T) {
 	server := NewServer(8080)
 
@@ -220,8 +654,8 @@ func TestServer_HandlePrompts(t *testing.T) {
 				}
 
 				// Verify the structure of the response
-				if len(prompts) != 2 {
-					t.Errorf("Expected 2 prompts, got %d", len(prompts))
+				if len(prompts) != 3 {
+					t.Fatalf("Expected 3 prompts, got %d", len(prompts))
 				}
 
 				// Check git-blame prompt
@@ -229,8 +663,8 @@ func TestServer_HandlePrompts(t *testing.T) {
 				if blamePrompt.Name != "git-blame" {
 					t.Errorf("Expected first prompt to be git-blame, got %s", blamePrompt.Name)
 				}
-				if len(blamePrompt.Arguments) != 4 {
-					t.Errorf("Expected 4 arguments for git-blame, got %d", len(blamePrompt.Arguments))
+				if len(blamePrompt.Arguments) != 11 {
+					t.Errorf("Expected 11 arguments for git-blame, got %d", len(blamePrompt.Arguments))
 				}
 
 				// Check git-log prompt
@@ -238,10 +672,643 @@ func TestServer_HandlePrompts(t *testing.T) {
 				if logPrompt.Name != "git-log" {
 					t.Errorf("Expected second prompt to be git-log, got %s", logPrompt.Name)
 				}
-				if len(logPrompt.Arguments) != 4 {
-					t.Errorf("Expected 4 arguments for git-log, got %d", len(logPrompt.Arguments))
+				if len(logPrompt.Arguments) != 12 {
+					t.Errorf("Expected 12 arguments for git-log, got %d", len(logPrompt.Arguments))
+				}
+
+				// Check pr-overlap prompt
+				overlapPrompt := prompts[2]
+				if overlapPrompt.Name != "pr-overlap" {
+					t.Errorf("Expected third prompt to be pr-overlap, got %s", overlapPrompt.Name)
+				}
+				if len(overlapPrompt.Arguments) != 5 {
+					t.Errorf("Expected 5 arguments for pr-overlap, got %d", len(overlapPrompt.Arguments))
 				}
 			}
 		})
 	}
 }
+
+func TestBlameData(t *testing.T) {
+	data := blameData(&repo.BlameResult{Authors: map[string]repo.BlameInfo{
+		"alice": {User: "alice", Lines: 1},
+		"bob":   {User: "bob", Lines: 2},
+	}})
+
+	if data.TotalLines != 3 {
+		t.Errorf("Expected 3 lines, got %d", data.TotalLines)
+	}
+	expected := []AuthorShare{{"bob", 2, 66.67}, {"alice", 1, 33.33}}
+	for i, share := range expected {
+		if data.Authors[i] != share {
+			t.Errorf("Expected %+v, got %+v", share, data.Authors[i])
+		}
+	}
+}
+
+func TestLogData(t *testing.T) {
+	data := logData("revisions", &maat.Table{
+		Columns: []string{"entity", "n-revs"},
+		Rows:    [][]interface{}{{"a.go", 3}},
+	})
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("Failed to marshal log data: %v", err)
+	}
+	expected := `{"analysis":"revisions","columns":["entity","n-revs"],"records":[{"entity":"a.go","n-revs":3}]}`
+	if string(body) != expected {
+		t.Errorf("Expected %s, got %s", expected, body)
+	}
+}
+
+// newTestRepo creates a local repository whose one branch, the pull request
+// to blame, changes a file alice wrote
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	run := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+			"GIT_COMMITTER_NAME=alice", "GIT_COMMITTER_EMAIL=alice@example.com",
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+	}
+	write := func(content string) {
+		if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write a.txt: %v", err)
+		}
+	}
+
+	run("init", "-q", "-b", "main")
+	write("one\ntwo\n")
+	run("add", ".")
+	run("commit", "-q", "-m", "initial")
+	run("checkout", "-q", "-b", "feature")
+	write("one\n")
+	run("commit", "-q", "-am", "feature")
+	run("checkout", "-q", "main")
+
+	return dir
+}
+
+func TestServer_MessagesV2(t *testing.T) {
+	dir := newTestRepo(t)
+
+	body, _ := json.Marshal(AnalysisRequest{
+		Name: "git-blame",
+		Arguments: map[string]interface{}{
+			"provider":   "local",
+			"repository": dir,
+			"head":       "feature",
+			"since":      "2000-01-01",
+		},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v2/messages", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	NewServer(8080, WithLocalRoot(dir)).mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response struct {
+		Status   string    `json:"status"`
+		Metadata Metadata  `json:"metadata"`
+		Data     BlameData `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if response.Metadata.Repository != dir || response.Metadata.Head != "feature" || response.Metadata.Since == nil {
+		t.Errorf("Unexpected metadata: %+v", response.Metadata)
+	}
+	if len(response.Metadata.HeadSHA) != 40 || response.Metadata.Ref == "" {
+		t.Errorf("Expected head and blamed commits, got %+v", response.Metadata)
+	}
+	expected := []AuthorShare{{"alice", 2, 100}}
+	if len(response.Data.Authors) != 1 || response.Data.Authors[0] != expected[0] {
+		t.Errorf("Expected %+v, got %+v", expected, response.Data.Authors)
+	}
+}
+
+func TestServer_MessagesV2NotFound(t *testing.T) {
+	dir := newTestRepo(t)
+
+	body, _ := json.Marshal(AnalysisRequest{
+		Name:      "git-blame",
+		Arguments: map[string]interface{}{"provider": "local", "repository": dir, "head": "gone"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v2/messages", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	NewServer(8080, WithLocalRoot(dir)).mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusNotFound, w.Code, w.Body.String())
+	}
+}
+
+func TestServer_GitLogConcurrent(t *testing.T) {
+	dir := newTestRepo(t)
+	wd, _ := os.Getwd()
+	s := NewServer(8080, WithWorkspace(workspace.New(t.TempDir(), 0)), WithLocalRoot(dir))
+
+	body, _ := json.Marshal(AnalysisRequest{
+		Name: "git-log",
+		Arguments: map[string]interface{}{
+			"provider":   "local",
+			"repository": dir,
+			"head":       "feature",
+			"analysis":   "authors",
+		},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/v2/messages", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			s.mux.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, _ := os.Getwd(); got != wd {
+		t.Errorf("Expected working directory %s, got %s", wd, got)
+	}
+}
+
+func TestServer_PROverlap(t *testing.T) {
+	dir := newTestRepo(t)
+	run := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=bob", "GIT_AUTHOR_EMAIL=bob@example.com",
+			"GIT_COMMITTER_NAME=bob", "GIT_COMMITTER_EMAIL=bob@example.com",
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+	}
+	// A second branch changing the same line as feature
+	run("checkout", "-q", "-b", "other")
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\nTWO\n"), 0644); err != nil {
+		t.Fatalf("Failed to write a.txt: %v", err)
+	}
+	run("commit", "-q", "-am", "other")
+	run("checkout", "-q", "main")
+
+	body, _ := json.Marshal(AnalysisRequest{
+		Name:      "pr-overlap",
+		Arguments: map[string]interface{}{"provider": "local", "repository": dir},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v2/messages", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	NewServer(8080, WithLocalRoot(dir)).mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response struct {
+		Data render.Overlap `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Data.PullRequests != 2 || len(response.Data.Pairs) != 1 {
+		t.Fatalf("Expected 1 pair of 2 pull requests, got %+v", response.Data)
+	}
+	pair := response.Data.Pairs[0]
+	if pair.SharedFiles != 1 || pair.ConflictingFiles != 1 || pair.OverlappingLines == 0 {
+		t.Errorf("Expected a.txt to conflict, got %+v", pair)
+	}
+}
+
+func TestServer_HandleMCP(t *testing.T) {
+	server := NewServer(8080)
+
+	tests := []struct {
+		name           string
+		method         string
+		origin         string
+		body           string
+		expectedStatus int
+		expectedError  int
+	}{
+		{
+			name:           "initialize",
+			method:         http.MethodPost,
+			body:           `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "notification",
+			method:         http.MethodPost,
+			body:           `{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:           "unknown method",
+			method:         http.MethodPost,
+			body:           `{"jsonrpc":"2.0","id":1,"method":"sampling/createMessage"}`,
+			expectedStatus: http.StatusOK,
+			expectedError:  rpcMethodNotFound,
+		},
+		{
+			name:           "parse error",
+			method:         http.MethodPost,
+			body:           `{"jsonrpc":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  rpcParseError,
+		},
+		{
+			name:           "unknown tool",
+			method:         http.MethodPost,
+			body:           `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"rm"}}`,
+			expectedStatus: http.StatusOK,
+			expectedError:  rpcInvalidParams,
+		},
+		{
+			name:           "no event stream",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "foreign origin",
+			method:         http.MethodPost,
+			origin:         "http://evil.example",
+			body:           `{"jsonrpc":"2.0","id":1,"method":"ping"}`,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/mcp", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+
+			server.mux.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status code %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if w.Header().Get("Content-Type") != "application/json" {
+				return
+			}
+
+			var resp struct {
+				Result json.RawMessage `json:"result"`
+				Error  *rpcError       `json:"error"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if tt.expectedError == 0 && resp.Error != nil {
+				t.Errorf("Expected no error, got %+v", resp.Error)
+			} else if tt.expectedError != 0 && (resp.Error == nil || resp.Error.Code != tt.expectedError) {
+				t.Errorf("Expected error code %d, got %+v", tt.expectedError, resp.Error)
+			}
+		})
+	}
+}
+
+func TestMCPTools_BlameProviders(t *testing.T) {
+	for _, tool := range mcpTools() {
+		providers := strings.Join(tool.InputSchema.Properties["provider"].Enum, ",")
+		withoutBlame := strings.Contains(providers, "gitea") || strings.Contains(providers, "azure")
+		if tool.Name == "git-blame" && withoutBlame {
+			t.Errorf("Expected git-blame to leave out providers without blame, got %s", providers)
+		}
+		if tool.Name == "git-log" && !withoutBlame {
+			t.Errorf("Expected git-log to offer every provider, got %s", providers)
+		}
+	}
+}
+
+func TestServer_ServeMCP(t *testing.T) {
+	dir := newTestRepo(t)
+	call, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "blame",
+		"method":  "tools/call",
+		"params": map[string]interface{}{
+			"name":      "git-blame",
+			"arguments": map[string]interface{}{"provider": "local", "repository": dir, "head": "feature"},
+		},
+	})
+	in := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"1999-01-01"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		string(call),
+	}, "\n")
+
+	server := NewServer(8080, WithLocalRoot(dir))
+	var out bytes.Buffer
+	if err := server.ServeMCP(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatalf("ServeMCP failed: %v", err)
+	}
+
+	responses := map[string]json.RawMessage{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var resp struct {
+			ID     json.RawMessage `json:"id"`
+			Result json.RawMessage `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("Failed to decode response %q: %v", line, err)
+		}
+		responses[string(resp.ID)] = resp.Result
+	}
+	if len(responses) != 2 {
+		t.Fatalf("Expected 2 responses, got %d: %s", len(responses), out.String())
+	}
+
+	var initialize mcpInitializeResult
+	json.Unmarshal(responses["1"], &initialize)
+	if initialize.ProtocolVersion != mcpProtocolVersions[0] {
+		t.Errorf("Expected protocol version %s, got %s", mcpProtocolVersions[0], initialize.ProtocolVersion)
+	}
+
+	var result struct {
+		IsError           bool `json:"isError"`
+		StructuredContent struct {
+			Data BlameData `json:"data"`
+		} `json:"structuredContent"`
+	}
+	json.Unmarshal(responses[`"blame"`], &result)
+	if result.IsError || result.StructuredContent.Data.TotalLines != 2 {
+		t.Errorf("Unexpected tool result: %s", responses[`"blame"`])
+	}
+
+	// The report is kept as a resource
+	resources := server.reports.list("")
+	if len(resources) != 1 {
+		t.Fatalf("Expected 1 report, got %d", len(resources))
+	}
+	if _, ok := server.reports.get("", resources[0].URI); !ok {
+		t.Errorf("Expected report %s to be readable", resources[0].URI)
+	}
+}
+
+func TestJobQueue(t *testing.T) {
+	// Without workers, jobs stay queued
+	q := newJobQueue(0, 1, time.Hour, nil)
+	defer q.close()
+
+	job, err := q.submit("", &AnalysisRequest{Name: "git-log"})
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	if job.Status != JobQueued {
+		t.Errorf("Expected status %s, got %s", JobQueued, job.Status)
+	}
+	if _, err := q.submit("", &AnalysisRequest{Name: "git-log"}); err != errQueueFull {
+		t.Errorf("Expected error '%v', got '%v'", errQueueFull, err)
+	}
+
+	job, err = q.cancelJob(job.ID)
+	if err != nil || job.Status != JobCancelled {
+		t.Errorf("Expected a cancelled job, got %s (%v)", job.Status, err)
+	}
+	if _, err := q.cancelJob(job.ID); err != errJobFinished {
+		t.Errorf("Expected error '%v', got '%v'", errJobFinished, err)
+	}
+	if _, err := q.get("unknown"); err != errJobNotFound {
+		t.Errorf("Expected error '%v', got '%v'", errJobNotFound, err)
+	}
+}
+
+func TestJobQueue_CancelRunning(t *testing.T) {
+	started := make(chan struct{})
+	q := newJobQueue(1, 1, time.Hour, func(ctx context.Context, req *AnalysisRequest) (*AnalysisResponseV2, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	defer q.close()
+
+	job, err := q.submit("", &AnalysisRequest{Name: "git-log"})
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	<-started
+	if _, err := q.cancelJob(job.ID); err != nil {
+		t.Fatalf("cancelJob failed: %v", err)
+	}
+
+	job = waitForJob(t, func() (Job, error) { return q.get(job.ID) })
+	if job.Status != JobCancelled || job.Error != "" {
+		t.Errorf("Expected a cancelled job, got %+v", job)
+	}
+}
+
+func TestJobQueue_Prune(t *testing.T) {
+	q := newJobQueue(1, 1, 50*time.Millisecond, func(ctx context.Context, req *AnalysisRequest) (*AnalysisResponseV2, error) {
+		return &AnalysisResponseV2{}, nil
+	})
+	defer q.close()
+
+	req := &AnalysisRequest{Name: "git-log", Arguments: map[string]interface{}{"provider": "github", "token": "secret"}}
+	job, err := q.submit("", req)
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	waitForJob(t, func() (Job, error) { return q.get(job.ID) })
+
+	q.mu.Lock()
+	_, kept := req.Arguments["token"]
+	q.mu.Unlock()
+	if kept {
+		t.Error("Expected the token to be dropped once the job finished")
+	}
+
+	// No further job is submitted, so the workers prune it
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := q.get(job.ID); err == errJobNotFound {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected the finished job to be pruned")
+}
+
+func TestServer_Jobs(t *testing.T) {
+	dir := newTestRepo(t)
+	server := NewServer(8080, WithWorkers(1), WithLocalRoot(dir))
+	defer server.jobs.close()
+
+	body, _ := json.Marshal(AnalysisRequest{
+		Name:      "git-blame",
+		Arguments: map[string]interface{}{"provider": "local", "repository": dir, "head": "feature"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/jobs", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	var created Job
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if w.Header().Get("Location") != "/jobs/"+created.ID {
+		t.Errorf("Expected Location /jobs/%s, got %s", created.ID, w.Header().Get("Location"))
+	}
+
+	get := func(method, id string) (Job, int) {
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, httptest.NewRequest(method, "/jobs/"+id, nil))
+		var job Job
+		json.NewDecoder(w.Body).Decode(&job)
+		return job, w.Code
+	}
+
+	job := waitForJob(t, func() (Job, error) {
+		job, _ := get(http.MethodGet, created.ID)
+		return job, nil
+	})
+	if job.Status != JobSucceeded || job.Result == nil || job.Result.Metadata.Repository != dir {
+		t.Fatalf("Expected a successful job, got %+v", job)
+	}
+
+	if _, code := get(http.MethodDelete, created.ID); code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, code)
+	}
+	if _, code := get(http.MethodGet, "unknown"); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+// waitForJob polls a job until it finishes
+func waitForJob(t *testing.T, get func() (Job, error)) Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := get()
+		if err != nil {
+			t.Fatalf("Failed to get job: %v", err)
+		}
+		if job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Job did not finish")
+	return Job{}
+}
+
+func TestServer_JobEvents(t *testing.T) {
+	dir := newTestRepo(t)
+	server := NewServer(8080, WithWorkers(1))
+	defer server.jobs.close()
+
+	job, err := server.jobs.submit("", &AnalysisRequest{Name: "git-blame", Arguments: map[string]interface{}{
+		"provider":    repo.Local,
+		"token":       "",
+		"repository":  dir,
+		"repoRef":     repo.RepoRef{Name: dir},
+		"baseUrl":     "",
+		"pullRequest": 0,
+		"head":        "feature",
+		"base":        "",
+		"window":      repo.Window{},
+	}})
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+
+	// The stream ends once the job finishes
+	events := func(lastID string) []string {
+		req := httptest.NewRequest(http.MethodGet, "/jobs/"+job.ID+"/events", nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, req)
+		if w.Header().Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("Expected an event stream, got %d: %s", w.Code, w.Body.String())
+		}
+		return strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	}
+
+	all := events("")
+	var phases []string
+	for _, event := range all {
+		lines := strings.Split(event, "\n")
+		if len(lines) != 3 {
+			t.Fatalf("Unexpected event %q", event)
+		}
+		if lines[1] == "event: progress" {
+			var progress ProgressEvent
+			json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &progress)
+			// A live stream sends every update of a phase it sees
+			if len(phases) == 0 || phases[len(phases)-1] != progress.Phase {
+				phases = append(phases, progress.Phase)
+			}
+			if progress.Phase == repo.PhaseBlame && progress.Done == 1 && progress.Authors["alice"] != 2 {
+				t.Errorf("Expected partial author totals, got %+v", progress)
+			}
+		}
+	}
+
+	expected := []string{repo.PhaseAuth, repo.PhaseListPullRequests, repo.PhaseBlame}
+	if strings.Join(phases, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected phases %v, got %v", expected, phases)
+	}
+	if last := all[len(all)-1]; !strings.Contains(last, "event: status") || !strings.Contains(last, `"status":"succeeded"`) {
+		t.Errorf("Expected the stream to end with the final status, got %q", last)
+	}
+
+	// Reconnecting replays only the events after Last-Event-ID
+	id := strings.TrimPrefix(strings.Split(all[len(all)-2], "\n")[0], "id: ")
+	if resumed := events(id); len(resumed) != 1 || resumed[0] != all[len(all)-1] {
+		t.Errorf("Expected only the final event, got %q", resumed)
+	}
+}
+
+func TestCloneCredentials(t *testing.T) {
+	tests := []struct {
+		provider repo.ProviderType
+		token    string
+		expected workspace.Credentials
+	}{
+		{repo.GitHub, "ghp", workspace.Credentials{Username: "x-access-token", Token: "ghp"}},
+		{repo.GitLab, "glpat", workspace.Credentials{Username: "oauth2", Token: "glpat"}},
+		{repo.Bitbucket, "alice:app-password", workspace.Credentials{Username: "alice", Token: "app-password"}},
+		{repo.Bitbucket, "access-token", workspace.Credentials{Username: "x-token-auth", Token: "access-token"}},
+		{repo.Gitea, "gta", workspace.Credentials{Username: "token", Token: "gta"}},
+		{repo.Azure, "pat", workspace.Credentials{Username: "pat", Token: "pat"}},
+		{repo.Local, "", workspace.Credentials{}},
+	}
+
+	for _, tt := range tests {
+		if got := cloneCredentials(tt.provider, tt.token); got != tt.expected {
+			t.Errorf("%s %q: expected %+v, got %+v", tt.provider, tt.token, tt.expected, got)
+		}
+	}
+}
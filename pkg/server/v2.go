@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/andrewweb/hackday/pkg/maat"
+	"github.com/andrewweb/hackday/pkg/render"
+	"github.com/andrewweb/hackday/pkg/repo"
+)
+
+// AnalysisResponseV2 is the response of /v2/messages. Data holds a BlameData
+// for git-blame, a LogData for git-log and a render.Overlap for pr-overlap.
+type AnalysisResponseV2 struct {
+	Status   string      `json:"status"`
+	Message  string      `json:"message,omitempty"`
+	Metadata *Metadata   `json:"metadata,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// Metadata describes what an analysis ran on
+type Metadata struct {
+	Name        string `json:"name"`
+	Provider    string `json:"provider"`
+	Repository  string `json:"repository"`
+	PullRequest int    `json:"pullRequest,omitempty"`
+	// Head is the branch a local repository's analysis compared with its base
+	Head    string `json:"head,omitempty"`
+	HeadSHA string `json:"headSha,omitempty"`
+	// Ref is the commit blamed at for git-blame, and the requested ref for
+	// git-log (empty for every branch)
+	Ref        string     `json:"ref,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+	Until      *time.Time `json:"until,omitempty"`
+	DurationMS int64      `json:"durationMs"`
+	// Truncated lists the provider lists cut short by the item limit
+	Truncated []string `json:"truncated,omitempty"`
+}
+
+// BlameData is the result of git-blame, with authors ordered by lines owned
+type BlameData struct {
+	TotalLines int           `json:"totalLines"`
+	Authors    []AuthorShare `json:"authors"`
+	// Skipped lists the files missing at the blamed ref
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// AuthorShare is the number and share of the blamed lines an author owns
+type AuthorShare struct {
+	Author  string  `json:"author"`
+	Lines   int     `json:"lines"`
+	Percent float64 `json:"percent"`
+}
+
+// LogData is the result of a git-log analysis. Records are keyed by column,
+// with numbers kept as JSON numbers.
+type LogData struct {
+	Analysis string                   `json:"analysis"`
+	Columns  []string                 `json:"columns"`
+	Records  []map[string]interface{} `json:"records"`
+}
+
+// handleMessagesV2 serves the typed response shape
+func (s *Server) handleMessagesV2(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	req, err := s.validate(w, r)
+	if err != nil {
+		return
+	}
+
+	result, rerr := s.analyze(r.Context(), req)
+	if rerr != nil {
+		sendErrorResponse(w, rerr.message, rerr.status)
+		return
+	}
+
+	response := newResponseV2(req, result)
+	response.Metadata.DurationMS = time.Since(start).Milliseconds()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// newResponseV2 encodes the result of a request in the typed response shape,
+// leaving the duration for the caller to fill in
+func newResponseV2(req *AnalysisRequest, result *analysisResult) AnalysisResponseV2 {
+	window := req.Arguments["window"].(repo.Window)
+	metadata := &Metadata{
+		Name:       req.Name,
+		Provider:   string(req.Arguments["provider"].(repo.ProviderType)),
+		Repository: req.Arguments["repository"].(string),
+		Ref:        window.Ref,
+		Truncated:  result.repoClient.Truncated(),
+	}
+	// pr-overlap looks at every open pull request rather than one
+	if result.pullRequest != nil {
+		metadata.PullRequest = req.Arguments["pullRequest"].(int)
+		metadata.Head = req.Arguments["head"].(string)
+		metadata.HeadSHA = result.pullRequest.HeadSHA
+	}
+	if !window.Since.IsZero() {
+		since := window.Since.UTC()
+		metadata.Since = &since
+	}
+	if !window.Until.IsZero() {
+		until := window.Until.UTC()
+		metadata.Until = &until
+	}
+
+	response := AnalysisResponseV2{Status: "success", Metadata: metadata}
+	switch req.Name {
+	case "git-blame":
+		metadata.Ref = result.blame.Ref
+		response.Message = "Blame analysis completed"
+		response.Data = blameData(result.blame)
+	case "git-log":
+		response.Message = "Git log analysis completed"
+		response.Data = logData(req.Arguments["analysis"].(string), result.table)
+	case "pr-overlap":
+		response.Message = "Overlap analysis completed"
+		response.Data = render.OverlapDocument(metadata.Repository, result.examined, result.overlaps).Data
+	}
+	return response
+}
+
+// blameData orders the authors by lines owned, with percentages rounded to
+// two decimal places
+func blameData(result *repo.BlameResult) BlameData {
+	data := BlameData{Authors: []AuthorShare{}, Skipped: result.Skipped}
+	for _, info := range result.Authors {
+		data.TotalLines += info.Lines
+		data.Authors = append(data.Authors, AuthorShare{Author: info.User, Lines: info.Lines})
+	}
+	for i := range data.Authors {
+		share := float64(data.Authors[i].Lines) * 100 / float64(data.TotalLines)
+		data.Authors[i].Percent = math.Round(share*100) / 100
+	}
+
+	sort.Slice(data.Authors, func(i, j int) bool {
+		if data.Authors[i].Lines != data.Authors[j].Lines {
+			return data.Authors[i].Lines > data.Authors[j].Lines
+		}
+		return data.Authors[i].Author < data.Authors[j].Author
+	})
+	return data
+}
+
+func logData(analysis string, table *maat.Table) LogData {
+	data := LogData{Analysis: analysis, Columns: table.Columns, Records: []map[string]interface{}{}}
+	for _, row := range table.Rows {
+		record := make(map[string]interface{}, len(row))
+		for i, cell := range row {
+			if i < len(table.Columns) {
+				record[table.Columns[i]] = cell
+			}
+		}
+		data.Records = append(data.Records, record)
+	}
+	return data
+}
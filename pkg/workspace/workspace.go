@@ -0,0 +1,291 @@
+// Package workspace keeps bare mirrors of remote repositories on disk, so
+// analyses that need the full history only fetch what changed since the last
+// run.
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials authenticate git over HTTPS. They are handed to git by a
+// credential helper reading the environment, so the token never appears in
+// command lines, remote URLs or the mirror's config.
+type Credentials struct {
+	Username string
+	Token    string
+}
+
+// Manager keeps a bare mirror per repository under a directory. Mirrors are
+// safe to use from several goroutines: a mirror is only fetched while nobody
+// reads it, and in-use mirrors are never evicted. Every git command runs
+// with its working directory set, never by changing the process's.
+type Manager struct {
+	dir   string
+	quota int64
+
+	mu      sync.Mutex
+	mirrors map[string]*sync.RWMutex
+}
+
+// New returns a Manager keeping mirrors in dir. When the mirrors take up more
+// than quota bytes, the least recently used are evicted; 0 means no quota.
+// The directory is created on first use.
+func New(dir string, quota int64) *Manager {
+	return &Manager{dir: dir, quota: quota, mirrors: map[string]*sync.RWMutex{}}
+}
+
+// Dir returns the directory holding the mirrors
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Mirror brings the mirror of the repository at url up to date, cloning it
+// the first time, and returns its path. The mirror stays readable until
+// release is called, which must happen exactly once.
+func (m *Manager) Mirror(ctx context.Context, url string, creds Credentials) (path string, release func(), err error) {
+	if err := os.MkdirAll(m.dir, 0700); err != nil {
+		return "", nil, fmt.Errorf("failed to create workspace directory: %v", err)
+	}
+
+	name := mirrorName(url)
+	path = filepath.Join(m.dir, name)
+	lock := m.lock(name)
+
+	// Fetch with the mirror to ourselves, then share it with other readers
+	lock.Lock()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		err = m.clone(ctx, url, path, creds)
+	} else if err == nil {
+		err = m.fetch(ctx, path, creds)
+	}
+	if err != nil {
+		lock.Unlock()
+		return "", nil, err
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	lock.Unlock()
+	lock.RLock()
+
+	if err := m.evict(name); err != nil {
+		lock.RUnlock()
+		return "", nil, err
+	}
+
+	var once sync.Once
+	return path, func() { once.Do(lock.RUnlock) }, nil
+}
+
+// lock returns the lock guarding a mirror
+func (m *Manager) lock(name string) *sync.RWMutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, ok := m.mirrors[name]
+	if !ok {
+		lock = &sync.RWMutex{}
+		m.mirrors[name] = lock
+	}
+	return lock
+}
+
+// clone creates the mirror in a temporary directory and moves it into place,
+// so an interrupted clone never leaves a broken mirror behind
+func (m *Manager) clone(ctx context.Context, url, path string, creds Credentials) error {
+	tmp, err := os.MkdirTemp(m.dir, ".clone-*")
+	if err != nil {
+		return fmt.Errorf("failed to create clone directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Only branches and tags are mirrored, not provider refs such as
+	// GitHub's refs/pull/*
+	for _, args := range [][]string{
+		{"init", "--quiet", "--bare"},
+		{"remote", "add", "origin", "--", url},
+		{"config", "remote.origin.fetch", "+refs/heads/*:refs/heads/*"},
+	} {
+		if err := git(ctx, tmp, Credentials{}, args...); err != nil {
+			return fmt.Errorf("failed to clone repository: %v", err)
+		}
+	}
+	if err := git(ctx, tmp, creds, "fetch", "--quiet", "--prune", "--tags", "origin"); err != nil {
+		return fmt.Errorf("failed to clone repository: %v", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to move clone into place: %v", err)
+	}
+	return nil
+}
+
+// fetch updates an existing mirror, downloading only new objects
+func (m *Manager) fetch(ctx context.Context, path string, creds Credentials) error {
+	if err := git(ctx, path, creds, "fetch", "--quiet", "--prune", "--tags", "origin"); err != nil {
+		return fmt.Errorf("failed to fetch repository: %v", err)
+	}
+	return nil
+}
+
+// evict removes the least recently used mirrors until the rest fit in the
+// quota. The mirror being used, and any other goroutine holds, are kept.
+func (m *Manager) evict(keep string) error {
+	if m.quota <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read workspace directory: %v", err)
+	}
+
+	type mirror struct {
+		name string
+		used time.Time
+		size int64
+	}
+	var mirrors []mirror
+	var total int64
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		size := dirSize(filepath.Join(m.dir, entry.Name()))
+		mirrors = append(mirrors, mirror{entry.Name(), info.ModTime(), size})
+		total += size
+	}
+
+	sort.Slice(mirrors, func(i, j int) bool {
+		return mirrors[i].used.Before(mirrors[j].used)
+	})
+	for _, mr := range mirrors {
+		if total <= m.quota {
+			break
+		}
+		if mr.name == keep {
+			continue
+		}
+
+		lock := m.lock(mr.name)
+		if !lock.TryLock() {
+			continue
+		}
+		err := os.RemoveAll(filepath.Join(m.dir, mr.name))
+		lock.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to evict mirror: %v", err)
+		}
+		total -= mr.size
+	}
+	return nil
+}
+
+// Size returns the disk space taken up by the mirrors
+func (m *Manager) Size() int64 {
+	return dirSize(m.dir)
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// mirrorName names the mirror of url after the end of its path, for people
+// browsing the directory, and a hash of the whole URL, for uniqueness
+func mirrorName(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	base := strings.TrimSuffix(filepath.Base(strings.TrimRight(url, "/")), ".git")
+	base = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, base)
+	return strings.TrimLeft(base, "-.") + "-" + hex.EncodeToString(sum[:8]) + ".git"
+}
+
+// credentialHelper answers git's credential requests from the environment
+const credentialHelper = `!f() { test "$1" = get && printf 'username=%s\npassword=%s\n' "$WORKSPACE_GIT_USERNAME" "$WORKSPACE_GIT_TOKEN"; }; f`
+
+// git runs a git command in dir. Credentials, when set, replace any helper
+// configured for the user, and git never prompts for missing ones.
+func git(ctx context.Context, dir string, creds Credentials, args ...string) error {
+	command := args[0]
+	if creds.Token != "" {
+		args = append([]string{"-c", "credential.helper=", "-c", "credential.helper=" + credentialHelper}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if creds.Token != "" {
+		username := creds.Username
+		if username == "" {
+			username = "git"
+		}
+		cmd.Env = append(cmd.Env, "WORKSPACE_GIT_USERNAME="+username, "WORKSPACE_GIT_TOKEN="+creds.Token)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("git %s: %v: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// ParseSize parses a size such as 500M or 10GiB. Suffixes are powers of 1024,
+// and a plain number is a count of bytes.
+func ParseSize(s string) (int64, error) {
+	value := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		switch value[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			value = value[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: use a number of bytes or a size such as 500M or 10G", s)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,215 @@
+package workspace
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// gitEnv keeps the user's git configuration out of the tests
+var gitEnv = []string{
+	"GIT_AUTHOR_NAME=alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+	"GIT_COMMITTER_NAME=alice", "GIT_COMMITTER_EMAIL=alice@example.com",
+	"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null",
+}
+
+func run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), gitEnv...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// newTestRepo creates a git repository with one commit on main
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	run(t, dir, "init", "-q", "-b", "main")
+	commit(t, dir, "initial")
+	return dir
+}
+
+func commit(t *testing.T, dir, message string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte(message+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write a.txt: %v", err)
+	}
+	run(t, dir, "add", ".")
+	run(t, dir, "commit", "-q", "-m", message)
+}
+
+func TestManager_Mirror(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	t.Setenv("GIT_CONFIG_SYSTEM", "/dev/null")
+
+	origin := newTestRepo(t)
+	m := New(t.TempDir(), 0)
+	ctx := context.Background()
+
+	dir, release, err := m.Mirror(ctx, origin, Credentials{})
+	if err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	if got := run(t, dir, "rev-parse", "--is-bare-repository"); got != "true" {
+		t.Errorf("Expected a bare repository, got %s", got)
+	}
+	if got, expected := run(t, dir, "rev-parse", "main"), run(t, origin, "rev-parse", "main"); got != expected {
+		t.Errorf("Expected main at %s, got %s", expected, got)
+	}
+	release()
+
+	// A second call fetches the new commits into the same mirror
+	commit(t, origin, "second")
+	run(t, origin, "branch", "feature")
+	again, release, err := m.Mirror(ctx, origin, Credentials{})
+	if err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	defer release()
+	if again != dir {
+		t.Errorf("Expected the mirror at %s, got %s", dir, again)
+	}
+	if got, expected := run(t, dir, "rev-parse", "main"), run(t, origin, "rev-parse", "main"); got != expected {
+		t.Errorf("Expected main at %s after fetching, got %s", expected, got)
+	}
+	if got := run(t, dir, "branch", "--list", "feature"); got == "" {
+		t.Error("Expected the feature branch to be fetched")
+	}
+}
+
+func TestManager_MirrorConcurrent(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	t.Setenv("GIT_CONFIG_SYSTEM", "/dev/null")
+
+	origin := newTestRepo(t)
+	m := New(t.TempDir(), 0)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dir, release, err := m.Mirror(context.Background(), origin, Credentials{})
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer release()
+			if err := exec.Command("git", "-C", dir, "log", "--oneline", "main").Run(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent mirror failed: %v", err)
+	}
+
+	entries, err := os.ReadDir(m.Dir())
+	if err != nil {
+		t.Fatalf("Failed to read workspace: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 mirror, got %d", len(entries))
+	}
+}
+
+func TestManager_Evict(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	t.Setenv("GIT_CONFIG_SYSTEM", "/dev/null")
+
+	first, second := newTestRepo(t), newTestRepo(t)
+	// Any quota smaller than two mirrors keeps only the one in use
+	m := New(t.TempDir(), 1)
+	ctx := context.Background()
+
+	firstDir, release, err := m.Mirror(ctx, first, Credentials{})
+	if err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+
+	// The first mirror is in use, so it survives the second
+	secondDir, releaseSecond, err := m.Mirror(ctx, second, Credentials{})
+	if err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	if _, err := os.Stat(firstDir); err != nil {
+		t.Errorf("Expected the mirror in use to be kept, got %v", err)
+	}
+	release()
+	releaseSecond()
+
+	// Once released, it is the least recently used and goes next
+	if _, release, err = m.Mirror(ctx, second, Credentials{}); err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	release()
+	if _, err := os.Stat(firstDir); !os.IsNotExist(err) {
+		t.Errorf("Expected the least recently used mirror to be evicted, got %v", err)
+	}
+	if _, err := os.Stat(secondDir); err != nil {
+		t.Errorf("Expected the mirror in use to be kept, got %v", err)
+	}
+}
+
+func TestCredentialHelper(t *testing.T) {
+	cmd := exec.Command("git", "-c", "credential.helper=", "-c", "credential.helper="+credentialHelper, "credential", "fill")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null",
+		"WORKSPACE_GIT_USERNAME=x-access-token", "WORKSPACE_GIT_TOKEN=secret")
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=example.com\n\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git credential fill failed: %v", err)
+	}
+
+	for _, expected := range []string{"username=x-access-token", "password=secret"} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("Expected %q in %q", expected, output)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"0", 0, false},
+		{"1024", 1024, false},
+		{"500M", 500 << 20, false},
+		{"10G", 10 << 30, false},
+		{"10GiB", 10 << 30, false},
+		{"2kb", 2 << 10, false},
+		{"", 0, true},
+		{"-1G", 0, true},
+		{"ten", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}